@@ -0,0 +1,563 @@
+// Package client is a Go client for the disapyr API.
+//
+// A Client stores and retrieves secrets with typed requests and responses:
+//
+//	c := client.New("https://disapyr.example.com:8080", client.WithTokenSource(tokens))
+//	created, err := c.Create(ctx, "hunter2", client.WithNotifyEmail("me@example.com"))
+//	secret, err := c.Get(ctx, created.Key)
+//
+// Requests rejected with 429, or that could not connect, are retried with
+// exponential backoff, waiting as long as the API asks for with Retry-After.
+// Responses with a 5xx status are retried too, except for requests that
+// store, read or burn a secret, which the API may have acted on. API errors
+// are returned as *Error and match the sentinel error of their category:
+//
+//	if errors.Is(err, client.ErrNotFound) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Retry defaults, changed with WithRetry
+const (
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Headers shared with the API server
+const (
+	requestIDHeader  = "X-Request-ID"
+	secretKindHeader = "X-Disapyr-Secret-Kind"
+)
+
+// SecretType is the type of a stored secret.
+type SecretType string
+
+// Secret types accepted and returned by the API
+const (
+	SecretTypeText   SecretType = "text"
+	SecretTypeKV     SecretType = "kv"
+	SecretTypeDotenv SecretType = "dotenv"
+	SecretTypeFile   SecretType = "file"
+)
+
+// TokenSource supplies the bearer token sent with each request. An empty
+// token sends the request unauthenticated.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+// Token calls f.
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a TokenSource that always supplies token.
+func StaticToken(token string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (string, error) { return token, nil })
+}
+
+// TokenInvalidator is implemented by token sources that can discard a token
+// the API rejected. A request answered with 401 is then retried once with a
+// fresh token.
+type TokenInvalidator interface {
+	InvalidateToken(token string)
+}
+
+// Client calls the disapyr API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	tokens     TokenSource
+	userAgent  string
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests, for example one
+// trusting a private CA. The default is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithTokenSource sets the source of bearer tokens.
+func WithTokenSource(ts TokenSource) Option {
+	return func(c *Client) { c.tokens = ts }
+}
+
+// WithUserAgent sets the User-Agent header of requests.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// WithRetry sets how many times a request is retried after a temporary
+// failure, and the bounds of the backoff between attempts. A Retry-After
+// longer than maxBackoff is not waited for; the error is returned instead.
+func WithRetry(maxRetries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries, c.minBackoff, c.maxBackoff = maxRetries, minBackoff, maxBackoff
+	}
+}
+
+// New returns a client for the API at baseURL.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		tokens:     StaticToken(""),
+		maxRetries: DefaultMaxRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// CallOption configures a single call. Options that do not apply to a call
+// are ignored.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	requestID      string
+	secretType     SecretType
+	contentType    string
+	notifyURL      string
+	recipientEmail string
+	notifyEmail    string
+}
+
+// WithRequestID sends id as the request ID, to correlate logs across
+// services.
+func WithRequestID(id string) CallOption {
+	return func(o *callOptions) { o.requestID = id }
+}
+
+// WithSecretType sets the type of a secret created with Create, such as
+// SecretTypeDotenv. The default is text.
+func WithSecretType(t SecretType) CallOption {
+	return func(o *callOptions) { o.secretType = t }
+}
+
+// WithContentType sets the content type of a file created with CreateFile.
+// The default is guessed from the file name.
+func WithContentType(contentType string) CallOption {
+	return func(o *callOptions) { o.contentType = contentType }
+}
+
+// WithNotifyURL asks the API to call url when the secret is opened.
+func WithNotifyURL(url string) CallOption {
+	return func(o *callOptions) { o.notifyURL = url }
+}
+
+// WithRecipientEmail asks the API to email the link to address.
+func WithRecipientEmail(address string) CallOption {
+	return func(o *callOptions) { o.recipientEmail = address }
+}
+
+// WithNotifyEmail asks the API to email address when the secret is opened.
+func WithNotifyEmail(address string) CallOption {
+	return func(o *callOptions) { o.notifyEmail = address }
+}
+
+func newCallOptions(opts []CallOption) *callOptions {
+	o := &callOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// fields returns the optional create fields that are set.
+func (o *callOptions) fields() map[string]string {
+	fields := map[string]string{}
+	for name, v := range map[string]string{"notify_url": o.notifyURL, "recipient_email": o.recipientEmail, "notify_email": o.notifyEmail} {
+		if v != "" {
+			fields[name] = v
+		}
+	}
+	return fields
+}
+
+// Created is the result of storing a secret. Key retrieves the secret;
+// WatchToken follows its status and should be kept by the creator only.
+// NotifySecret, set when a notify URL was given, verifies the signature of
+// the notifications sent to it.
+type Created struct {
+	Key          string `json:"key"`
+	WatchToken   string `json:"watch_token"`
+	NotifySecret string `json:"notify_secret,omitempty"`
+}
+
+// Secret is a retrieved secret. Text holds a text secret or a dotenv
+// document, and Fields the fields of key/value and dotenv secrets. File
+// secrets are streamed in Body, which the caller must close.
+type Secret struct {
+	Type   SecretType        `json:"type"`
+	Text   string            `json:"secret,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+
+	Filename    string        `json:"-"`
+	ContentType string        `json:"-"`
+	Size        int64         `json:"-"` // -1 if unknown
+	Body        io.ReadCloser `json:"-"`
+}
+
+// Status is the status of a secret, as seen by its creator.
+type Status struct {
+	Status    string     `json:"status"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Limits are the size limits published by the API.
+type Limits struct {
+	MaxSecretSize int64  `json:"max_secret_size"`
+	MaxFileSize   int64  `json:"max_file_size"`
+	MaxBodySize   int64  `json:"max_body_size"`
+	TextEncoding  string `json:"text_encoding"`
+}
+
+// Create stores a text secret, or a dotenv document with
+// WithSecretType(SecretTypeDotenv).
+func (c *Client) Create(ctx context.Context, secret string, opts ...CallOption) (*Created, error) {
+	o := newCallOptions(opts)
+	return c.create(ctx, map[string]any{"type": o.secretType, "secret": secret}, o)
+}
+
+// CreateFields stores a key/value secret.
+func (c *Client) CreateFields(ctx context.Context, fields map[string]string, opts ...CallOption) (*Created, error) {
+	return c.create(ctx, map[string]any{"type": SecretTypeKV, "fields": fields}, newCallOptions(opts))
+}
+
+func (c *Client) create(ctx context.Context, payload map[string]any, o *callOptions) (*Created, error) {
+	if payload["type"] == SecretType("") {
+		delete(payload, "type")
+	}
+	for name, v := range o.fields() {
+		payload[name] = v
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling JSON: %w", err)
+	}
+	return c.postSecret(ctx, &requestBody{
+		contentType: "application/json",
+		open:        func() (io.Reader, error) { return bytes.NewReader(b), nil },
+		replayable:  true,
+	}, o)
+}
+
+// CreateFile stores the contents of r as a file secret named filename. The
+// file is streamed, not buffered; the request is only retried if r is an
+// io.Seeker, such as an *os.File.
+func (c *Client) CreateFile(ctx context.Context, filename string, r io.Reader, opts ...CallOption) (*Created, error) {
+	o := newCallOptions(opts)
+	contentType := o.contentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	seeker, replayable := r.(io.Seeker)
+	var start int64
+	if replayable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			replayable = false
+		}
+	}
+	boundary := multipart.NewWriter(nil).Boundary()
+	body := &requestBody{
+		contentType: "multipart/form-data; boundary=" + boundary,
+		replayable:  replayable,
+		open: func() (io.Reader, error) {
+			if replayable {
+				if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+					return nil, err
+				}
+			}
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(writeMultipartFile(pw, boundary, filename, contentType, r, o.fields()))
+			}()
+			return pr, nil
+		},
+	}
+	return c.postSecret(ctx, body, o)
+}
+
+// writeMultipartFile writes fields, formatted as text, and then the file to
+// w as multipart/form-data.
+func writeMultipartFile(w io.Writer, boundary, filename, contentType string, r io.Reader, fields map[string]string) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for name, v := range fields {
+		if err := mw.WriteField(name, v); err != nil {
+			return err
+		}
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": filepath.Base(filename)}))
+	header.Set("Content-Type", contentType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+func (c *Client) postSecret(ctx context.Context, body *requestBody, o *callOptions) (*Created, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/secret", body, http.StatusOK, o)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var created Created
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &created, nil
+}
+
+// Get retrieves a secret, which burns it.
+func (c *Client) Get(ctx context.Context, key string, opts ...CallOption) (*Secret, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/secret/"+url.PathEscape(key), nil, http.StatusOK, newCallOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get(secretKindHeader) == string(SecretTypeFile) {
+		_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		return &Secret{
+			Type:        SecretTypeFile,
+			Filename:    params["filename"],
+			ContentType: resp.Header.Get("Content-Type"),
+			Size:        resp.ContentLength,
+			Body:        resp.Body,
+		}, nil
+	}
+	defer resp.Body.Close()
+	var secret Secret
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	if secret.Type == "" {
+		secret.Type = SecretTypeText
+	}
+	return &secret, nil
+}
+
+// Burn destroys a secret that has not been read.
+func (c *Client) Burn(ctx context.Context, key string, opts ...CallOption) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/secret/"+url.PathEscape(key), nil, http.StatusNoContent, newCallOptions(opts))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Status returns the status of the secret created with watchToken.
+func (c *Client) Status(ctx context.Context, watchToken string, opts ...CallOption) (*Status, error) {
+	var status Status
+	if err := c.GetJSON(ctx, "/status/"+url.PathEscape(watchToken), &status, opts...); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Watch opens the Server-Sent Events stream of the status of the secret
+// created with watchToken. The caller must close it.
+func (c *Client) Watch(ctx context.Context, watchToken string, opts ...CallOption) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/watch/"+url.PathEscape(watchToken), nil, http.StatusOK, newCallOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Limits returns the size limits published by the API.
+func (c *Client) Limits(ctx context.Context, opts ...CallOption) (*Limits, error) {
+	var limits Limits
+	if err := c.GetJSON(ctx, "/v1/limits", &limits, opts...); err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
+
+// GetJSON sends a GET request for path, which may include a query string,
+// and decodes the JSON response into v. It serves endpoints without a typed
+// method, such as the admin endpoints.
+func (c *Client) GetJSON(ctx context.Context, path string, v any, opts ...CallOption) error {
+	resp, err := c.do(ctx, http.MethodGet, path, nil, http.StatusOK, newCallOptions(opts))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// requestBody produces the body of a request, once per attempt.
+type requestBody struct {
+	contentType string
+	open        func() (io.Reader, error)
+	replayable  bool
+}
+
+// do sends a request and returns the response if its status is want. Other
+// responses are returned as *Error, after retrying those that are temporary
+// and, with a TokenInvalidator, a 401 once with a new token. Requests that
+// store, read or burn a secret are only retried when the API cannot have
+// acted on them: after a 429, or when the connection failed.
+func (c *Client) do(ctx context.Context, method, path string, body *requestBody, want int, o *callOptions) (*http.Response, error) {
+	once := method != http.MethodGet || strings.HasPrefix(path, "/secret/")
+	invalidated := false
+	for attempt := 0; ; attempt++ {
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting access token: %w", err)
+		}
+		req, err := c.newRequest(ctx, method, path, body, token, o)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			err = fmt.Errorf("calling API: %w", err)
+			if !notSent(err) || (body != nil && !body.replayable) || attempt >= c.maxRetries {
+				return nil, err
+			}
+			if serr := sleep(ctx, c.backoff(attempt)); serr != nil {
+				return nil, errors.Join(serr, err)
+			}
+			continue
+		}
+		if resp.StatusCode == want {
+			return resp, nil
+		}
+		apiErr := readError(resp)
+		resp.Body.Close()
+		if body != nil && !body.replayable {
+			return nil, apiErr
+		}
+
+		if inv, ok := c.tokens.(TokenInvalidator); ok && resp.StatusCode == http.StatusUnauthorized && token != "" && !invalidated {
+			inv.InvalidateToken(token)
+			invalidated = true
+			attempt--
+			continue
+		}
+		if !apiErr.Temporary() || (once && resp.StatusCode != http.StatusTooManyRequests) || attempt >= c.maxRetries {
+			return nil, apiErr
+		}
+		wait := c.backoff(attempt)
+		if apiErr.RetryAfter > 0 {
+			if apiErr.RetryAfter > c.maxBackoff {
+				return nil, apiErr
+			}
+			wait = apiErr.RetryAfter
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, errors.Join(err, apiErr)
+		}
+	}
+}
+
+// notSent reports whether err means the connection failed, so the request
+// never reached the API.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// sleep waits for d, or returns the context's error if it is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body *requestBody, token string, o *callOptions) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		var err error
+		if r, err = body.open(); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", body.contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	if method == http.MethodGet && strings.HasPrefix(path, "/watch/") {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if o.requestID != "" {
+		req.Header.Set(requestIDHeader, o.requestID)
+	}
+	return req, nil
+}
+
+// backoff returns the delay before retry attempt+1: exponential from
+// minBackoff, capped at maxBackoff, with jitter so clients do not retry in
+// step.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << min(attempt, 30)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int64N(half+1))
+	}
+	return d
+}
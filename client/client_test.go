@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/squarehole/disapyr/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	opts = append([]Option{WithRetry(2, time.Millisecond, 10*time.Millisecond)}, opts...)
+	return New(server.URL+"/", opts...)
+}
+
+func writeError(w http.ResponseWriter, status int, category Category) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": "failed", "category": string(category)})
+}
+
+func TestCreateAndGet(t *testing.T) {
+	var payload map[string]any
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, "req-1", r.Header.Get(requestIDHeader))
+		switch r.Method + " " + r.URL.Path {
+		case "POST /secret":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			_, _ = io.WriteString(w, `{"key":"abc","watch_token":"wt"}`)
+		case "GET /secret/abc":
+			_, _ = io.WriteString(w, `{"type":"dotenv","secret":"A=1","fields":{"A":"1"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}, WithTokenSource(StaticToken("token")))
+
+	created, err := c.Create(context.Background(), "A=1", WithSecretType(SecretTypeDotenv),
+		WithNotifyEmail("me@example.com"), WithRequestID("req-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, &Created{Key: "abc", WatchToken: "wt"}, created)
+	assert.Equal(t, map[string]any{"type": "dotenv", "secret": "A=1", "notify_email": "me@example.com"}, payload)
+
+	secret, err := c.Get(context.Background(), "abc", WithRequestID("req-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, SecretTypeDotenv, secret.Type)
+	assert.Equal(t, "A=1", secret.Text)
+	assert.Equal(t, map[string]string{"A": "1"}, secret.Fields)
+}
+
+func TestCreateFileAndGetFile(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			file, header, err := r.FormFile("file")
+			assert.NoError(t, err)
+			b, _ := io.ReadAll(file)
+			assert.Equal(t, "contents", string(b))
+			assert.Equal(t, "notes.txt", header.Filename)
+			assert.Equal(t, "https://hooks.example.com", r.FormValue("notify_url"))
+			_, _ = io.WriteString(w, `{"key":"abc","watch_token":"wt"}`)
+			return
+		}
+		w.Header().Set(secretKindHeader, "file")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Disposition", `attachment; filename="notes.txt"`)
+		_, _ = io.WriteString(w, "contents")
+	})
+
+	created, err := c.CreateFile(context.Background(), "/tmp/notes.txt", strings.NewReader("contents"), WithNotifyURL("https://hooks.example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", created.Key)
+
+	secret, err := c.Get(context.Background(), "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, SecretTypeFile, secret.Type)
+	assert.Equal(t, "notes.txt", secret.Filename)
+	assert.Equal(t, "text/plain", secret.ContentType)
+	b, _ := io.ReadAll(secret.Body)
+	assert.NoError(t, secret.Body.Close())
+	assert.Equal(t, "contents", string(b))
+}
+
+func TestRetryAfterRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) == 1 {
+			w.Header().Set("Retry-After", "0")
+			writeError(w, http.StatusTooManyRequests, CategoryRateLimit)
+			return
+		}
+		_, _ = io.WriteString(w, `{"key":"abc","watch_token":"wt"}`)
+	})
+
+	_, err := c.Create(context.Background(), "s")
+	assert.NoError(t, err)
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, bodies[0], bodies[1], "body is replayed")
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	attempts := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		writeError(w, http.StatusServiceUnavailable, CategoryServer)
+	})
+	_, err := c.Limits(context.Background())
+	assert.ErrorIs(t, err, ErrServer)
+	assert.Equal(t, 3, attempts)
+
+	// A Retry-After over the maximum backoff is not waited for.
+	attempts = 0
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "3600")
+		writeError(w, http.StatusTooManyRequests, CategoryRateLimit)
+	})
+	_, err = c.Limits(context.Background())
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, time.Hour, apiErr.RetryAfter)
+	assert.Equal(t, 1, attempts)
+
+	// Client errors are not retried.
+	attempts = 0
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		writeError(w, http.StatusBadRequest, CategoryValidation)
+	})
+	_, err = c.Create(context.Background(), "")
+	assert.ErrorIs(t, err, ErrValidation)
+	assert.Equal(t, "API returned 400 Bad Request: failed", err.Error())
+	assert.Equal(t, 1, attempts)
+}
+
+func TestNoRetryAfterServerErrorForSecrets(t *testing.T) {
+	attempts := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		writeError(w, http.StatusBadGateway, CategoryServer)
+	})
+	ctx := context.Background()
+
+	_, err := c.Create(ctx, "s")
+	assert.ErrorIs(t, err, ErrServer)
+	_, err = c.CreateFields(ctx, map[string]string{"A": "1"})
+	assert.ErrorIs(t, err, ErrServer)
+	_, err = c.CreateFile(ctx, "notes.txt", strings.NewReader("file"))
+	assert.ErrorIs(t, err, ErrServer)
+	_, err = c.Get(ctx, "abc")
+	assert.ErrorIs(t, err, ErrServer)
+	assert.ErrorIs(t, c.Burn(ctx, "abc"), ErrServer)
+	assert.Equal(t, 5, attempts, "each request is sent once")
+
+	// Status lookups are safe to repeat.
+	attempts = 0
+	_, err = c.Status(ctx, "wt")
+	assert.ErrorIs(t, err, ErrServer)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryConnectionErrors(t *testing.T) {
+	dials := 0
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials++
+			return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+		},
+	}}
+	c := New("http://disapyr.invalid", WithHTTPClient(hc), WithRetry(2, time.Millisecond, 10*time.Millisecond))
+
+	_, err := c.Get(context.Background(), "abc")
+	assert.Error(t, err)
+	assert.Equal(t, 3, dials, "a request that never connected is retried")
+}
+
+type rotatingTokens struct {
+	tokens      []string
+	invalidated []string
+}
+
+func (r *rotatingTokens) Token(context.Context) (string, error) {
+	return r.tokens[len(r.invalidated)], nil
+}
+
+func (r *rotatingTokens) InvalidateToken(token string) {
+	r.invalidated = append(r.invalidated, token)
+}
+
+func TestInvalidateTokenOnUnauthorized(t *testing.T) {
+	tokens := &rotatingTokens{tokens: []string{"old", "new", "newer"}}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new" {
+			writeError(w, http.StatusUnauthorized, CategoryAuth)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}, WithTokenSource(tokens))
+
+	assert.NoError(t, c.Burn(context.Background(), "abc"))
+	assert.Equal(t, []string{"old"}, tokens.invalidated)
+
+	// A second 401 in a row is returned.
+	tokens.tokens[1] = "stale"
+	tokens.invalidated = nil
+	assert.ErrorIs(t, c.Burn(context.Background(), "abc"), ErrAuth)
+	assert.Equal(t, []string{"old"}, tokens.invalidated)
+}
+
+func TestTokenSourceError(t *testing.T) {
+	c := New("http://127.0.0.1:0", WithTokenSource(TokenSourceFunc(func(context.Context) (string, error) {
+		return "", ErrAuth
+	})))
+	_, err := c.Status(context.Background(), "wt")
+	assert.ErrorIs(t, err, ErrAuth)
+}
+
+func TestErrorCategories(t *testing.T) {
+	// The categories and their status codes mirror the API's.
+	known := map[Category]bool{CategoryAuth: true, CategoryForbidden: true, CategoryValidation: true, CategoryDatabase: true,
+		CategoryServer: true, CategoryRateLimit: true, CategoryNotFound: true, CategoryPayloadTooLarge: true}
+	assert.Len(t, internal.ErrorStatusMap, len(known))
+	for category, status := range internal.ErrorStatusMap {
+		assert.True(t, known[Category(category)], "category %s", category)
+		if want, ok := categoryStatus[Category(category)]; ok {
+			assert.Equal(t, status, want, "category %s", category)
+		} else {
+			assert.Equal(t, http.StatusInternalServerError, status, "category %s", category)
+		}
+	}
+	assert.Equal(t, CategoryNotFound, categoryForStatus(http.StatusNotFound))
+	assert.Equal(t, CategoryServer, categoryForStatus(http.StatusBadGateway))
+	assert.Equal(t, Category(""), categoryForStatus(http.StatusTeapot))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, time.Minute, parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now))
+	assert.Zero(t, parseRetryAfter("", now))
+	assert.Zero(t, parseRetryAfter("-1", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Category is the general category of an API error. The values match the
+// category field of the API's error responses.
+type Category string
+
+// Error categories returned by the API
+const (
+	CategoryAuth            Category = "auth"
+	CategoryForbidden       Category = "forbidden"
+	CategoryValidation      Category = "validation"
+	CategoryDatabase        Category = "database"
+	CategoryServer          Category = "server"
+	CategoryRateLimit       Category = "rate_limit"
+	CategoryNotFound        Category = "not_found"
+	CategoryPayloadTooLarge Category = "payload_too_large"
+)
+
+// Sentinel errors, one per category, for use with errors.Is. Any *Error of
+// the same category matches.
+var (
+	ErrAuth            = &Error{Category: CategoryAuth}
+	ErrForbidden       = &Error{Category: CategoryForbidden}
+	ErrValidation      = &Error{Category: CategoryValidation}
+	ErrDatabase        = &Error{Category: CategoryDatabase}
+	ErrServer          = &Error{Category: CategoryServer}
+	ErrRateLimit       = &Error{Category: CategoryRateLimit}
+	ErrNotFound        = &Error{Category: CategoryNotFound}
+	ErrPayloadTooLarge = &Error{Category: CategoryPayloadTooLarge}
+)
+
+// categoryStatus maps each category to the status code the API sends it with.
+var categoryStatus = map[Category]int{
+	CategoryAuth:            http.StatusUnauthorized,
+	CategoryForbidden:       http.StatusForbidden,
+	CategoryValidation:      http.StatusBadRequest,
+	CategoryRateLimit:       http.StatusTooManyRequests,
+	CategoryNotFound:        http.StatusNotFound,
+	CategoryPayloadTooLarge: http.StatusRequestEntityTooLarge,
+}
+
+// Error is an error response from the API. Errors detected before a request
+// is sent, such as a secret over the published limits, have no StatusCode.
+type Error struct {
+	StatusCode int
+	Category   Category
+	Message    string
+	// RetryAfter is the delay the API asked for with Retry-After, if any.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		if e.Message == "" {
+			return string(e.Category)
+		}
+		return e.Message
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("API returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("API returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is reports whether target is the sentinel error of e's category.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.StatusCode == 0 && t.Message == "" && t.Category == e.Category
+}
+
+// Temporary reports whether the request may succeed if retried later.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// readError builds an *Error from a response with an unexpected status.
+// Responses without a category, such as those of a proxy, have it inferred
+// from the status code.
+func readError(resp *http.Response) *Error {
+	var body struct {
+		Error    string   `json:"error"`
+		Category Category `json:"category"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+	category := body.Category
+	if category == "" {
+		category = categoryForStatus(resp.StatusCode)
+	}
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Category:   category,
+		Message:    body.Error,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// categoryForStatus returns the category the API uses for status.
+func categoryForStatus(status int) Category {
+	for category, s := range categoryStatus {
+		if s == status {
+			return category
+		}
+	}
+	if status >= 500 {
+		return CategoryServer
+	}
+	return ""
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP
+// date. It returns 0 if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
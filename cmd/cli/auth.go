@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/squarehole/disapyr/client"
+	"github.com/squarehole/disapyr/internal"
+)
+
+// loginScope is requested by "disapyr login"; offline_access asks for a
+// refresh token so the login outlives the access token.
+const loginScope = "openid offline_access"
+
+// idpTimeout bounds each request to the identity provider.
+const idpTimeout = 30 * time.Second
+
+// cachedToken is a token kept in the token cache along with what is needed
+// to refresh or replace it.
+type cachedToken struct {
+	internal.Token
+	Domain   string `json:"domain"`
+	Audience string `json:"audience"`
+	ClientID string `json:"client_id"`
+}
+
+func (t cachedToken) authConfig() internal.AuthConfig {
+	return internal.AuthConfig{Domain: t.Domain, Audience: t.Audience, ClientID: t.ClientID}
+}
+
+// tokenCache holds tokens by API server URL, with client credentials tokens
+// kept apart from the user's login. It is stored as JSON with mode 0600
+// under the user config directory and never holds client secrets.
+type tokenCache struct {
+	Tokens map[string]cachedToken `json:"tokens"`
+}
+
+// authFromEnv returns the identity provider settings from the environment.
+func authFromEnv() internal.AuthConfig {
+	return internal.AuthConfig{
+		Domain:       os.Getenv("DISAPYR_AUTH_DOMAIN"),
+		Audience:     os.Getenv("DISAPYR_AUDIENCE"),
+		ClientID:     os.Getenv("DISAPYR_CLIENT_ID"),
+		ClientSecret: os.Getenv("DISAPYR_CLIENT_SECRET"),
+	}
+}
+
+// tokenCachePath returns the path of the token cache file.
+func tokenCachePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "disapyr", "tokens.json"), nil
+}
+
+// loadTokenCache reads the token cache at path. A missing file is an empty
+// cache.
+func loadTokenCache(path string) (*tokenCache, error) {
+	cache := &tokenCache{Tokens: map[string]cachedToken{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cache); err != nil {
+		return nil, fmt.Errorf("reading token cache %s: %w", path, err)
+	}
+	if cache.Tokens == nil {
+		cache.Tokens = map[string]cachedToken{}
+	}
+	return cache, nil
+}
+
+// save writes the cache to path with mode 0600, replacing it atomically.
+func (c *tokenCache) save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// cacheKey normalises a server URL for use as a token cache key.
+func cacheKey(server string) string {
+	return strings.TrimRight(server, "/")
+}
+
+// cachedAccessToken returns a token for server. With a client secret in auth
+// a client credentials token is used; otherwise the token saved by "disapyr
+// login" is used, refreshed if it has expired. It returns "" if there is
+// neither, and the request is sent unauthenticated.
+func cachedAccessToken(ctx context.Context, server string, auth internal.AuthConfig) (string, error) {
+	path, err := tokenCachePath()
+	if err != nil {
+		return "", err
+	}
+	cache, err := loadTokenCache(path)
+	if err != nil {
+		return "", err
+	}
+
+	key := cacheKey(server)
+	if auth.ClientSecret != "" {
+		key += " " + internal.GrantClientCredentials + " " + auth.ClientID
+	}
+	entry, ok := cache.Tokens[key]
+	now := time.Now()
+	idp := &http.Client{Timeout: idpTimeout}
+	switch {
+	case auth.ClientSecret != "":
+		if ok && entry.Valid(now) && entry.Domain == auth.Domain && entry.ClientID == auth.ClientID && entry.Audience == auth.Audience {
+			return entry.AccessToken, nil
+		}
+		tok, err := internal.ClientCredentialsToken(ctx, idp, auth)
+		if err != nil {
+			return "", fmt.Errorf("requesting a client credentials token: %w", err)
+		}
+		entry = cachedToken{Token: *tok, Domain: auth.Domain, Audience: auth.Audience, ClientID: auth.ClientID}
+	case !ok:
+		return "", nil
+	case entry.Valid(now):
+		return entry.AccessToken, nil
+	case entry.RefreshToken != "":
+		tok, err := internal.RefreshAccessToken(ctx, idp, entry.authConfig(), entry.RefreshToken)
+		if err != nil {
+			return "", fmt.Errorf("refreshing the login for %s: %w; run disapyr login", key, err)
+		}
+		entry.Token = *tok
+	default:
+		return "", fmt.Errorf("the login for %s has expired; run disapyr login", key)
+	}
+
+	cache.Tokens[key] = entry
+	if err := cache.save(path); err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: could not save token cache:", err)
+	}
+	return entry.AccessToken, nil
+}
+
+// runLogin implements "disapyr login", the OAuth device authorization flow.
+// The user approves the login in a browser on any device and the token is
+// cached for the server.
+func runLogin(opts *apiOptions, args []string) error {
+	fs := newFlagSet(opts, "login", "login [--domain DOMAIN] [--audience AUDIENCE] [--client-id ID]")
+	domain := fs.String("domain", "", "Identity provider domain (default: $DISAPYR_AUTH_DOMAIN or the profile's auth.domain)")
+	audience := fs.String("audience", "", "API audience (default: $DISAPYR_AUDIENCE or the profile's auth.audience)")
+	clientID := fs.String("client-id", "", "OAuth client ID of the CLI (default: $DISAPYR_CLIENT_ID or the profile's auth.client_id)")
+	if _, err := opts.parse(fs, args, 0); err != nil {
+		return err
+	}
+	auth := internal.AuthConfig{
+		Domain:   firstNonEmpty(*domain, opts.auth.Domain),
+		Audience: firstNonEmpty(*audience, opts.auth.Audience),
+		ClientID: firstNonEmpty(*clientID, opts.auth.ClientID),
+	}
+	if auth.Domain == "" || auth.ClientID == "" {
+		return usageErrorf("login needs --domain and --client-id, DISAPYR_AUTH_DOMAIN and DISAPYR_CLIENT_ID, or a profile with auth.domain and auth.client_id")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	idp := &http.Client{Timeout: idpTimeout}
+
+	dc, err := internal.RequestDeviceCode(ctx, idp, auth, loginScope)
+	if err != nil {
+		return fmt.Errorf("starting login: %w", err)
+	}
+	if dc.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "To log in, open %s\nand check that it shows the code %s\n", dc.VerificationURIComplete, dc.UserCode)
+	} else {
+		fmt.Fprintf(os.Stderr, "To log in, open %s\nand enter the code %s\n", dc.VerificationURI, dc.UserCode)
+	}
+
+	tok, err := internal.PollDeviceToken(ctx, idp, auth, dc)
+	if err != nil {
+		return &client.Error{Category: client.CategoryAuth, Message: fmt.Sprintf("login failed: %v", err)}
+	}
+
+	path, err := tokenCachePath()
+	if err != nil {
+		return err
+	}
+	cache, err := loadTokenCache(path)
+	if err != nil {
+		return err
+	}
+	cache.Tokens[cacheKey(opts.server)] = cachedToken{Token: *tok, Domain: auth.Domain, Audience: auth.Audience, ClientID: auth.ClientID}
+	if err := cache.save(path); err != nil {
+		return fmt.Errorf("saving token cache: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Logged in to %s.\n", cacheKey(opts.server))
+	return nil
+}
+
+// runLogout implements "disapyr logout", which forgets the cached token for
+// the server.
+func runLogout(opts *apiOptions, args []string) error {
+	fs := newFlagSet(opts, "logout", "logout")
+	if _, err := opts.parse(fs, args, 0); err != nil {
+		return err
+	}
+	path, err := tokenCachePath()
+	if err != nil {
+		return err
+	}
+	cache, err := loadTokenCache(path)
+	if err != nil {
+		return err
+	}
+	key := cacheKey(opts.server)
+	if _, ok := cache.Tokens[key]; !ok {
+		fmt.Fprintf(os.Stderr, "Not logged in to %s.\n", key)
+		return nil
+	}
+	delete(cache.Tokens, key)
+	if err := cache.save(path); err != nil {
+		return fmt.Errorf("saving token cache: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Logged out of %s.\n", key)
+	return nil
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/squarehole/disapyr/internal"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultServer is used when neither --server nor the profile names a server.
+// It matches the API server's default port.
+const defaultServer = "https://localhost:8080"
+
+// defaultProfile is used when no profile is selected.
+const defaultProfile = "default"
+
+// cliConfig is the CLI config file. It holds named profiles, one per
+// disapyr deployment, and never holds tokens or client secrets.
+type cliConfig struct {
+	CurrentProfile string              `yaml:"current_profile,omitempty"`
+	Profiles       map[string]*profile `yaml:"profiles,omitempty"`
+}
+
+// profile holds the settings for one disapyr deployment. Flags and
+// environment variables take precedence over it.
+type profile struct {
+	Server string      `yaml:"server,omitempty"`
+	UIURL  string      `yaml:"ui_url,omitempty"`
+	CACert string      `yaml:"ca_cert,omitempty"`
+	Auth   profileAuth `yaml:"auth,omitempty"`
+}
+
+// profileAuth holds the identity provider settings of a profile.
+type profileAuth struct {
+	Domain   string `yaml:"domain,omitempty"`
+	Audience string `yaml:"audience,omitempty"`
+	ClientID string `yaml:"client_id,omitempty"`
+}
+
+// profileKeys are the settings accepted by "disapyr config set", in the order
+// they are shown.
+var profileKeys = []string{"server", "ui_url", "ca_cert", "auth.domain", "auth.audience", "auth.client_id"}
+
+// get returns the value of a profile setting, or "" if it is not set.
+func (p *profile) get(key string) string {
+	switch key {
+	case "server":
+		return p.Server
+	case "ui_url":
+		return p.UIURL
+	case "ca_cert":
+		return p.CACert
+	case "auth.domain":
+		return p.Auth.Domain
+	case "auth.audience":
+		return p.Auth.Audience
+	case "auth.client_id":
+		return p.Auth.ClientID
+	}
+	return ""
+}
+
+// set validates and sets a profile setting. An empty value unsets it.
+func (p *profile) set(key, value string) error {
+	switch key {
+	case "server", "ui_url":
+		if value != "" {
+			if err := checkBaseURL(value); err != nil {
+				return err
+			}
+		}
+		if key == "server" {
+			p.Server = value
+		} else {
+			p.UIURL = value
+		}
+	case "ca_cert":
+		if value != "" {
+			abs, err := filepath.Abs(value)
+			if err != nil {
+				return err
+			}
+			value = abs
+		}
+		p.CACert = value
+	case "auth.domain":
+		p.Auth.Domain = value
+	case "auth.audience":
+		p.Auth.Audience = value
+	case "auth.client_id":
+		p.Auth.ClientID = value
+	default:
+		return fmt.Errorf("unknown setting %q; settings are %v", key, profileKeys)
+	}
+	return nil
+}
+
+// checkBaseURL checks that s is an absolute http or https URL.
+func checkBaseURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+	return nil
+}
+
+// configPath returns the path of the CLI config file: DISAPYR_CLI_CONFIG if
+// set, otherwise disapyr/config.yaml under the user config directory.
+func configPath() (string, error) {
+	if path := os.Getenv("DISAPYR_CLI_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "disapyr", "config.yaml"), nil
+}
+
+// loadCLIConfig reads the config file at path. A missing file is an empty
+// config.
+func loadCLIConfig(path string) (*cliConfig, error) {
+	cfg := &cliConfig{}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*profile{}
+	}
+	return cfg, nil
+}
+
+// save writes the config to path with mode 0600, replacing it atomically.
+func (c *cliConfig) save(path string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// profileName returns the selected profile: name if given, otherwise
+// DISAPYR_PROFILE, the config's current profile or "default".
+func (c *cliConfig) profileName(name string) string {
+	switch {
+	case name != "":
+		return name
+	case os.Getenv("DISAPYR_PROFILE") != "":
+		return os.Getenv("DISAPYR_PROFILE")
+	case c.CurrentProfile != "":
+		return c.CurrentProfile
+	}
+	return defaultProfile
+}
+
+// writeFileAtomic writes b to path with mode 0600 through a temporary file,
+// creating its directory with mode 0700.
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// resolve fills in the settings not given as flags from the environment, the
+// selected profile and the built-in defaults, in that order. Selecting a
+// profile that does not exist is an error, except for the default profile.
+func (o *apiOptions) resolve() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadCLIConfig(path)
+	if err != nil {
+		return err
+	}
+	name := cfg.profileName(o.profile)
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		if name != defaultProfile {
+			return usageErrorf("profile %q is not defined in %s", name, path)
+		}
+		p = &profile{}
+	}
+
+	o.server = firstNonEmpty(o.server, p.Server, defaultServer)
+	o.uiURL = firstNonEmpty(o.uiURL, p.UIURL)
+	o.caCert = firstNonEmpty(os.Getenv("CUSTOM_CA_CERT"), p.CACert)
+	env := authFromEnv()
+	o.auth = internal.AuthConfig{
+		Domain:       firstNonEmpty(env.Domain, p.Auth.Domain),
+		Audience:     firstNonEmpty(env.Audience, p.Auth.Audience),
+		ClientID:     firstNonEmpty(env.ClientID, p.Auth.ClientID),
+		ClientSecret: env.ClientSecret,
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// configSubcommands maps each "disapyr config" subcommand to its
+// implementation.
+var configSubcommands = map[string]func(opts *apiOptions, cfg *cliConfig, path string, args []string) error{
+	"path":   runConfigPath,
+	"list":   runConfigList,
+	"show":   runConfigShow,
+	"set":    runConfigSet,
+	"unset":  runConfigUnset,
+	"use":    runConfigUse,
+	"delete": runConfigDelete,
+}
+
+const configUsage = `Usage: disapyr config <subcommand> [flags] [arguments]
+
+Subcommands:
+  path                   Print the path of the config file
+  list                   List the profiles, marking the selected one
+  show                   Print the settings of a profile
+  set KEY VALUE          Set a setting of a profile, creating the profile
+  unset KEY              Remove a setting from a profile
+  use NAME               Make NAME the current profile
+  delete NAME            Delete a profile
+
+set, unset and show act on the profile given with --profile, DISAPYR_PROFILE
+or the current profile. Settings: server, ui_url, ca_cert, auth.domain,
+auth.audience, auth.client_id.
+`
+
+// runConfig implements "disapyr config", which manages the profiles in the
+// CLI config file.
+func runConfig(opts *apiOptions, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return usageErrorf("config needs a subcommand")
+	}
+	name, args := args[0], args[1:]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		fmt.Print(configUsage)
+		return nil
+	}
+	run, ok := configSubcommands[name]
+	if !ok {
+		fmt.Fprint(os.Stderr, configUsage)
+		return usageErrorf("unknown config subcommand %q", name)
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadCLIConfig(path)
+	if err != nil {
+		return err
+	}
+	return run(opts, cfg, path, args)
+}
+
+// newConfigFlagSet returns the flag set of a config subcommand, which takes
+// only --profile and --output.
+func newConfigFlagSet(opts *apiOptions, name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet("config "+name, flag.ContinueOnError)
+	fs.StringVar(&opts.profile, "profile", "", "Profile to act on (default: $DISAPYR_PROFILE or the current profile)")
+	fs.Func("output", "Output format: text or json (default: text)", func(v string) error {
+		return opts.setOutput("config", v)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: disapyr config %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func runConfigPath(opts *apiOptions, _ *cliConfig, path string, args []string) error {
+	fs := newConfigFlagSet(opts, "path", "path")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	return opts.print(map[string]string{"path": path}, func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, path)
+		return err
+	})
+}
+
+func runConfigList(opts *apiOptions, cfg *cliConfig, _ string, args []string) error {
+	fs := newConfigFlagSet(opts, "list", "list")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	selected := cfg.profileName(opts.profile)
+
+	result := struct {
+		Selected string   `json:"selected"`
+		Profiles []string `json:"profiles"`
+	}{selected, names}
+	return opts.print(result, func(w io.Writer) error {
+		for _, name := range names {
+			mark := " "
+			if name == selected {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "%s %s\n", mark, name)
+		}
+		return nil
+	})
+}
+
+func runConfigShow(opts *apiOptions, cfg *cliConfig, path string, args []string) error {
+	fs := newConfigFlagSet(opts, "show", "show [--profile NAME]")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	name := cfg.profileName(opts.profile)
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return usageErrorf("profile %q is not defined in %s", name, path)
+	}
+
+	settings := map[string]string{}
+	for _, key := range profileKeys {
+		if v := p.get(key); v != "" {
+			settings[key] = v
+		}
+	}
+	result := struct {
+		Profile  string            `json:"profile"`
+		Settings map[string]string `json:"settings"`
+	}{name, settings}
+	return opts.print(result, func(w io.Writer) error {
+		fmt.Fprintf(w, "profile: %s\n", name)
+		for _, key := range profileKeys {
+			if v, ok := settings[key]; ok {
+				fmt.Fprintf(w, "%s: %s\n", key, v)
+			}
+		}
+		return nil
+	})
+}
+
+func runConfigSet(opts *apiOptions, cfg *cliConfig, path string, args []string) error {
+	fs := newConfigFlagSet(opts, "set", "set [--profile NAME] KEY VALUE")
+	pos, err := parseFlags(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	name := cfg.profileName(opts.profile)
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		p = &profile{}
+	}
+	if pos[1] == "" {
+		return usageErrorf("the value is empty; use disapyr config unset %s", pos[0])
+	}
+	if err := p.set(pos[0], pos[1]); err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	cfg.Profiles[name] = p
+	if err := cfg.save(path); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Set %s in profile %s.\n", pos[0], name)
+	return nil
+}
+
+func runConfigUnset(opts *apiOptions, cfg *cliConfig, path string, args []string) error {
+	fs := newConfigFlagSet(opts, "unset", "unset [--profile NAME] KEY")
+	pos, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	name := cfg.profileName(opts.profile)
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return usageErrorf("profile %q is not defined in %s", name, path)
+	}
+	if err := p.set(pos[0], ""); err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	if err := cfg.save(path); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Unset %s in profile %s.\n", pos[0], name)
+	return nil
+}
+
+func runConfigUse(opts *apiOptions, cfg *cliConfig, path string, args []string) error {
+	fs := newConfigFlagSet(opts, "use", "use NAME")
+	pos, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[pos[0]]; !ok {
+		return usageErrorf("profile %q is not defined in %s", pos[0], path)
+	}
+	cfg.CurrentProfile = pos[0]
+	if err := cfg.save(path); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Using profile %s.\n", pos[0])
+	return nil
+}
+
+func runConfigDelete(opts *apiOptions, cfg *cliConfig, path string, args []string) error {
+	fs := newConfigFlagSet(opts, "delete", "delete NAME")
+	pos, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[pos[0]]; !ok {
+		return usageErrorf("profile %q is not defined in %s", pos[0], path)
+	}
+	delete(cfg.Profiles, pos[0])
+	if cfg.CurrentProfile == pos[0] {
+		cfg.CurrentProfile = ""
+	}
+	if err := cfg.save(path); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Deleted profile %s.\n", pos[0])
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileSet(t *testing.T) {
+	tests := []struct {
+		name, key, value string
+		ok               bool
+	}{
+		{"server", "server", "https://disapyr.example.com:8080", true},
+		{"http server", "server", "http://localhost:8080", true},
+		{"server without scheme", "server", "disapyr.example.com", false},
+		{"server with other scheme", "server", "ftp://disapyr.example.com", false},
+		{"server without host", "server", "https://", false},
+		{"ui_url", "ui_url", "https://disapyr.example.com", true},
+		{"bad ui_url", "ui_url", "not a url", false},
+		{"auth.domain", "auth.domain", "tenant.auth0.com", true},
+		{"unknown key", "token", "secret", false},
+		{"ttl is not a setting", "ttl", "24h", false},
+		{"max_views is not a setting", "max_views", "1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p profile
+			err := p.set(tt.key, tt.value)
+			if tt.ok {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.value, p.get(tt.key))
+			} else {
+				assert.Error(t, err)
+				assert.Empty(t, p.get(tt.key))
+			}
+		})
+	}
+
+	t.Run("ca_cert is made absolute", func(t *testing.T) {
+		var p profile
+		assert.NoError(t, p.set("ca_cert", "ca.pem"))
+		assert.True(t, filepath.IsAbs(p.get("ca_cert")))
+		assert.Equal(t, "ca.pem", filepath.Base(p.get("ca_cert")))
+	})
+
+	t.Run("empty value unsets", func(t *testing.T) {
+		p := profile{Server: "https://disapyr.example.com"}
+		assert.NoError(t, p.set("server", ""))
+		assert.Empty(t, p.get("server"))
+	})
+}
+
+// useConfig points the CLI at a config file in a temporary directory, holding
+// content, and clears the environment that overrides profiles.
+func useConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if content != "" {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	t.Setenv("DISAPYR_CLI_CONFIG", path)
+	for _, name := range []string{"DISAPYR_PROFILE", "DISAPYR_UI_URL", "CUSTOM_CA_CERT", "DISAPYR_AUTH_DOMAIN", "DISAPYR_AUDIENCE", "DISAPYR_CLIENT_ID", "DISAPYR_TOKEN"} {
+		t.Setenv(name, "")
+	}
+	return path
+}
+
+const testConfig = `current_profile: staging
+profiles:
+  staging:
+    server: https://staging.example.com
+    ui_url: https://ui.staging.example.com
+    ca_cert: /etc/staging-ca.pem
+    auth:
+      domain: staging.auth0.com
+  prod:
+    server: https://prod.example.com
+    ui_url: https://ui.prod.example.com
+`
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		env      map[string]string
+		args     []string
+		server   string
+		uiURL    string
+		caCert   string
+		domain   string
+		errorMsg string
+	}{
+		{
+			name:   "no config uses the defaults",
+			server: defaultServer,
+		},
+		{
+			name:   "current profile",
+			config: testConfig,
+			server: "https://staging.example.com", uiURL: "https://ui.staging.example.com", caCert: "/etc/staging-ca.pem", domain: "staging.auth0.com",
+		},
+		{
+			name:   "environment selects a profile over current_profile",
+			config: testConfig,
+			env:    map[string]string{"DISAPYR_PROFILE": "prod"},
+			server: "https://prod.example.com", uiURL: "https://ui.prod.example.com",
+		},
+		{
+			name:   "flag selects a profile over the environment",
+			config: testConfig,
+			env:    map[string]string{"DISAPYR_PROFILE": "prod"},
+			args:   []string{"--profile", "staging"},
+			server: "https://staging.example.com", uiURL: "https://ui.staging.example.com", caCert: "/etc/staging-ca.pem", domain: "staging.auth0.com",
+		},
+		{
+			name:   "environment overrides the profile",
+			config: testConfig,
+			env:    map[string]string{"DISAPYR_UI_URL": "https://ui.env.example.com", "CUSTOM_CA_CERT": "/etc/env-ca.pem", "DISAPYR_AUTH_DOMAIN": "env.auth0.com"},
+			server: "https://staging.example.com", uiURL: "https://ui.env.example.com", caCert: "/etc/env-ca.pem", domain: "env.auth0.com",
+		},
+		{
+			name:   "flags override the environment and the profile",
+			config: testConfig,
+			env:    map[string]string{"DISAPYR_UI_URL": "https://ui.env.example.com"},
+			args:   []string{"--server", "https://flag.example.com", "--ui-url", "https://ui.flag.example.com"},
+			server: "https://flag.example.com", uiURL: "https://ui.flag.example.com", caCert: "/etc/staging-ca.pem", domain: "staging.auth0.com",
+		},
+		{
+			name:     "undefined profile",
+			config:   testConfig,
+			args:     []string{"--profile", "qa"},
+			errorMsg: `profile "qa" is not defined`,
+		},
+		{
+			name:   "undefined default profile",
+			config: "profiles: {}\n",
+			server: defaultServer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, tt.config)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			var opts apiOptions
+			_, err := opts.parse(newFlagSet(&opts, "get", "get"), tt.args, 0)
+			if tt.errorMsg != "" {
+				assert.ErrorContains(t, err, tt.errorMsg)
+				assert.Equal(t, exitUsage, exitCode(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.server, opts.server)
+			assert.Equal(t, tt.uiURL, opts.uiURL)
+			assert.Equal(t, tt.caCert, opts.caCert)
+			assert.Equal(t, tt.domain, opts.auth.Domain)
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "disapyr")
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, writeFileAtomic(path, []byte("one")))
+	assert.NoError(t, writeFileAtomic(path, []byte("two")))
+
+	got, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(got))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	info, err = os.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+}
+
+func TestConfigUseAndDelete(t *testing.T) {
+	path := useConfig(t, testConfig)
+	load := func() *cliConfig {
+		cfg, err := loadCLIConfig(path)
+		assert.NoError(t, err)
+		return cfg
+	}
+
+	assert.NoError(t, runConfig(&apiOptions{}, []string{"use", "prod"}))
+	assert.Equal(t, "prod", load().CurrentProfile)
+
+	err := runConfig(&apiOptions{}, []string{"use", "qa"})
+	assert.Equal(t, exitUsage, exitCode(err))
+	assert.Equal(t, "prod", load().CurrentProfile, "an undefined profile is not selected")
+
+	// Deleting another profile keeps the current one.
+	assert.NoError(t, runConfig(&apiOptions{}, []string{"delete", "staging"}))
+	cfg := load()
+	assert.Equal(t, "prod", cfg.CurrentProfile)
+	assert.NotContains(t, cfg.Profiles, "staging")
+
+	// Deleting the current profile clears current_profile, so the default
+	// profile is used again.
+	assert.NoError(t, runConfig(&apiOptions{}, []string{"delete", "prod"}))
+	cfg = load()
+	assert.Empty(t, cfg.CurrentProfile)
+	assert.Empty(t, cfg.Profiles)
+	assert.Equal(t, defaultProfile, cfg.profileName(""))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/squarehole/disapyr/client"
+)
+
+// Exit codes, one per class of failure. API failures use the code of the
+// server's error category, so scripts can tell a burned secret from a bad
+// token or a rate limit.
+const (
+	exitOK              = 0
+	exitError           = 1 // local failures: files, network, unreadable responses
+	exitUsage           = 2
+	exitAuth            = 3
+	exitForbidden       = 4
+	exitValidation      = 5
+	exitNotFound        = 6
+	exitPayloadTooLarge = 7
+	exitRateLimit       = 8
+	exitServer          = 9
+)
+
+// categoryExitCodes maps the server's error categories to exit codes.
+var categoryExitCodes = map[client.Category]int{
+	client.CategoryAuth:            exitAuth,
+	client.CategoryForbidden:       exitForbidden,
+	client.CategoryValidation:      exitValidation,
+	client.CategoryNotFound:        exitNotFound,
+	client.CategoryPayloadTooLarge: exitPayloadTooLarge,
+	client.CategoryRateLimit:       exitRateLimit,
+	client.CategoryServer:          exitServer,
+	client.CategoryDatabase:        exitServer,
+}
+
+// usageError reports a command line that cannot be acted on.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	var apiErr *client.Error
+	var usageErr *usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &apiErr):
+		if code, ok := categoryExitCodes[apiErr.Category]; ok {
+			return code
+		}
+		return exitError
+	case errors.As(err, &usageErr):
+		return exitUsage
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/squarehole/disapyr/client"
+	"github.com/squarehole/disapyr/internal"
+)
+
+// Exit codes used by exec mode for commands that cannot be found or run,
+// following the shell conventions.
+const (
+	exitCannotRun       = 126
+	exitCommandNotFound = 127
+	exitSignalOffset    = 128
+)
+
+// runExec implements "disapyr exec --key K [--env NAME] -- cmd args". The
+// secret is retrieved, set in the environment of cmd only, and never written
+// anywhere. It returns the exit code to exit with.
+func runExec(opts *apiOptions, args []string) int {
+	fs := newFlagSet(opts, "exec", "exec --key KEY [--env NAME] -- command [args...]")
+	keyVal := fs.String("key", "", "Key of the secret to inject")
+	envName := fs.String("env", "", "Variable to set to a text secret")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	command := fs.Args()
+	if *keyVal == "" || len(command) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *envName != "" && !internal.ValidEnvName(*envName) {
+		opts.printError(usageErrorf("%q is not a valid variable name", *envName))
+		return exitUsage
+	}
+	if err := opts.resolve(); err != nil {
+		opts.printError(err)
+		return exitCode(err)
+	}
+
+	// Resolve the command before burning the secret.
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		opts.printError(err)
+		if errors.Is(err, exec.ErrNotFound) {
+			return exitCommandNotFound
+		}
+		return exitCannotRun
+	}
+
+	secret, err := fetchSecretForExec(opts.newClient(), *keyVal)
+	if err != nil {
+		opts.printError(err)
+		return exitCode(err)
+	}
+	vars, err := secretEnv(secret, *envName)
+	if err != nil {
+		opts.printError(err)
+		return exitError
+	}
+
+	env := os.Environ()
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	return runChild(path, command, env)
+}
+
+// fetchSecretForExec retrieves a secret, refusing file secrets. Error
+// messages never include the secret.
+func fetchSecretForExec(c *client.Client, key string) (*client.Secret, error) {
+	secret, err := c.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Body != nil {
+		secret.Body.Close()
+		return nil, errors.New("file secrets cannot be injected into the environment; use disapyr get --out instead")
+	}
+	return secret, nil
+}
+
+// secretEnv returns the variables to set for a secret. Key/value and dotenv
+// secrets set one variable per field. A text secret is never parsed; it sets
+// envName, which must be given.
+func secretEnv(secret *client.Secret, envName string) (map[string]string, error) {
+	if secret.Type == client.SecretTypeText {
+		if envName == "" {
+			return nil, errors.New("the secret is a single value; use --env NAME to choose its variable")
+		}
+		return map[string]string{envName: secret.Text}, nil
+	}
+	if envName != "" {
+		return nil, fmt.Errorf("--env cannot be used with a %s secret", secret.Type)
+	}
+
+	vars := secret.Fields
+	if len(vars) == 0 {
+		return nil, errors.New("the secret has no fields")
+	}
+	for name := range vars {
+		if !internal.ValidEnvName(name) {
+			return nil, fmt.Errorf("field %q is not a valid variable name", name)
+		}
+	}
+	return vars, nil
+}
+
+// runChild runs the command with env, forwarding signals to it, and returns
+// its exit code, or 128 plus the signal number if a signal ended it.
+func runChild(path string, command, env []string) int {
+	cmd := exec.Command(path, command[1:]...)
+	cmd.Args[0] = command[0]
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCannotRun
+	}
+	go func() {
+		for sig := range signals {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return exitSignalOffset + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	default:
+		fmt.Fprintln(os.Stderr, "Error:", strings.TrimSpace(err.Error()))
+		return exitError
+	}
+}
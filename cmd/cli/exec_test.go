@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/squarehole/disapyr/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  client.Secret
+		envName string
+		want    map[string]string
+	}{
+		{"text", client.Secret{Type: client.SecretTypeText, Text: "hunter2"}, "DB_PASSWORD", map[string]string{"DB_PASSWORD": "hunter2"}},
+		{"text is not parsed", client.Secret{Type: client.SecretTypeText, Text: `{"A":"1"}`}, "JSON", map[string]string{"JSON": `{"A":"1"}`}},
+		{"kv", client.Secret{Type: client.SecretTypeKV, Fields: map[string]string{"USER": "app", "PASS": "x"}}, "", map[string]string{"USER": "app", "PASS": "x"}},
+		{"dotenv", client.Secret{Type: client.SecretTypeDotenv, Text: "A=1\n", Fields: map[string]string{"A": "1"}}, "", map[string]string{"A": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := secretEnv(&tt.secret, tt.envName)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, vars)
+		})
+	}
+
+	errs := []struct {
+		name    string
+		secret  client.Secret
+		envName string
+	}{
+		{"text without a name", client.Secret{Type: client.SecretTypeText, Text: "A=1"}, ""},
+		{"text holding JSON without a name", client.Secret{Type: client.SecretTypeText, Text: `{"A":"1"}`}, ""},
+		{"name for kv", client.Secret{Type: client.SecretTypeKV, Fields: map[string]string{"A": "1"}}, "B"},
+		{"no fields", client.Secret{Type: client.SecretTypeKV}, ""},
+		{"invalid field name", client.Secret{Type: client.SecretTypeKV, Fields: map[string]string{"NOT VALID": "1"}}, ""},
+	}
+	for _, tt := range errs {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := secretEnv(&tt.secret, tt.envName)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunChildExitCode(t *testing.T) {
+	assert.Equal(t, 0, runChild("/bin/sh", []string{"sh", "-c", "exit 0"}, nil))
+	assert.Equal(t, 3, runChild("/bin/sh", []string{"sh", "-c", "exit 3"}, nil))
+	assert.Equal(t, exitSignalOffset+int(syscall.SIGTERM), runChild("/bin/sh", []string{"sh", "-c", "kill -TERM $$"}, nil))
+}
+
+func TestRunChildEnv(t *testing.T) {
+	assert.Equal(t, 0, runChild("/bin/sh", []string{"sh", "-c", `test "$DB_PASSWORD" = hunter2`}, []string{"DB_PASSWORD=hunter2"}))
+	assert.Equal(t, 1, runChild("/bin/sh", []string{"sh", "-c", `test "$DB_PASSWORD" = hunter2`}, nil))
+}
+
+func TestRunChildForwardsSignals(t *testing.T) {
+	ready := filepath.Join(t.TempDir(), "ready")
+	script := `trap 'exit 7' USR1; touch "$READY"; while :; do sleep 0.05; done`
+
+	done := make(chan int, 1)
+	go func() {
+		done <- runChild("/bin/sh", []string{"sh", "-c", script}, []string{"READY=" + ready, "PATH=" + os.Getenv("PATH")})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("child did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+
+	select {
+	case code := <-done:
+		assert.Equal(t, 7, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal was not forwarded")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxInputSize bounds a secret read from a file or stdin; the server's own
+// limits are checked separately before uploading.
+const maxInputSize = 64 << 20
+
+// readSecret returns the secret to store, read from path, or from stdin when
+// it is piped, or else from a prompt that does not echo what is typed. A
+// single trailing newline is removed from piped and prompted input.
+func readSecret(path string) (string, error) {
+	var r io.Reader
+	switch {
+	case path != "":
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	case !isTerminal(os.Stdin):
+		r = os.Stdin
+	default:
+		return promptSecret("Secret: ")
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, maxInputSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxInputSize {
+		return "", fmt.Errorf("input is larger than %d bytes", maxInputSize)
+	}
+	secret := string(b)
+	if path == "" {
+		secret = strings.TrimSuffix(strings.TrimSuffix(secret, "\n"), "\r")
+	}
+	return secret, nil
+}
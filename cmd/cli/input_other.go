@@ -0,0 +1,20 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || zos)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// promptSecret is not supported where echo cannot be turned off, so that a
+// secret is never shown as it is typed.
+func promptSecret(string) (string, error) {
+	return "", errors.New("cannot prompt for a secret on this platform; pass it with --file or on stdin")
+}
@@ -0,0 +1,63 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || zos
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlReadTermios)
+	return err == nil
+}
+
+// promptSecret writes prompt to stderr and reads a line from the terminal on
+// stdin with echo turned off. Echo is restored if the prompt is interrupted.
+func promptSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	saved, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return "", fmt.Errorf("reading terminal settings: %w", err)
+	}
+	noEcho := *saved
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &noEcho); err != nil {
+		return "", fmt.Errorf("turning off echo: %w", err)
+	}
+	restore := func() { _ = unix.IoctlSetTermios(fd, ioctlWriteTermios, saved) }
+	defer restore()
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+	go func() {
+		if sig, ok := <-interrupted; ok {
+			restore()
+			fmt.Fprintln(os.Stderr)
+			os.Exit(exitSignalOffset + int(sig.(syscall.Signal)))
+		}
+	}()
+
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("reading secret: %w", err)
+	}
+	secret := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if secret == "" {
+		return "", errors.New("no secret entered")
+	}
+	return secret, nil
+}
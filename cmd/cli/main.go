@@ -1,159 +1,648 @@
 // Package main provides a CLI tool for securely storing and retrieving secrets
-// via an API server. The tool supports two main operations: storing a secret
-// and retrieving a secret using a key.
+// via an API server.
 //
 // Usage:
-//   - To store a secret:
-//     ./cli --store --secret="your-secret" --server="https://your-server-url"
-//   - To retrieve a secret:
-//     ./cli --retrieve --key="your-key" --server="https://your-server-url"
 //
-// Flags:
+//	disapyr <command> [flags] [arguments]
 //
-//	--store       Indicates that a secret should be stored.
-//	--retrieve    Indicates that a secret should be retrieved.
-//	--secret      The secret to store (used with --store).
-//	--key         The key to retrieve the secret (used with --retrieve).
-//	--server      The API server URL (default: https://localhost:3000).
+// Commands:
+//
+//	create        Stores a secret and prints its key and watch token. The secret
+//	              is read from --file, from stdin when it is piped, or from a
+//	              prompt that does not echo, so it never appears in shell history
+//	              or the process list.
+//	get KEY       Retrieves and burns a secret.
+//	status TOKEN  Prints whether the secret created with a watch token has been
+//	              opened or burned.
+//	burn KEY      Burns a secret without reading it.
+//	exec          Runs a command with a secret in its environment.
+//	audit         Lists audit events (requires an admin token).
+//	verify-audit  Verifies the audit log hash chain (requires an admin token).
+//	login         Logs in with the OAuth device code flow and caches the token.
+//	logout        Forgets the cached token for the server.
+//	config        Manages the profiles in the CLI config file.
+//
+// Examples:
+//
+//	disapyr create                                  (prompts for the secret)
+//	pg_dump --schema-only db | disapyr create
+//	disapyr create --file=./id_ed25519              (files are shared as files)
+//	disapyr create --type=kv < fields.json          (a JSON object of string fields)
+//	disapyr create --type=dotenv --file=./.env
+//	disapyr create --output=url < note.txt          (prints only the share URL)
+//	disapyr create --output=json < note.txt
+//	disapyr create --output=qr < note.txt           (a QR code of the share URL to scan)
+//	disapyr get KEY
+//	disapyr get --export KEY                        (key/value and dotenv secrets as export lines)
+//	disapyr get --out=./id_ed25519 KEY              (file secrets; - writes to stdout)
+//	disapyr status WATCH_TOKEN
+//	disapyr burn KEY
+//	disapyr exec --key=KEY --env=DB_PASSWORD -- ./migrate up
+//	disapyr exec --key=KEY -- ./deploy.sh           (kv, dotenv or JSON object secrets)
+//	disapyr audit [--key=KEY] [--event-type=retrieve]
+//	disapyr verify-audit
+//	disapyr login --domain=tenant.auth0.com --client-id=ID --audience=https://disapyr.link
+//	disapyr config set --profile=staging server https://disapyr.staging.example.com
+//	disapyr config use staging                      (or --profile=staging, DISAPYR_PROFILE=staging)
+//
+// Flags accepted by every command:
+//
+//	--profile  Config profile to use (default: $DISAPYR_PROFILE or the current profile).
+//	--token    Bearer token sent to the API (default: $DISAPYR_TOKEN).
+//	--server   The API server URL (default: the profile's server or https://localhost:8080).
+//	--ui-url   Base URL of the web UI, used for share URLs (default: $DISAPYR_UI_URL).
+//	--output   Output format: text, json, or for create also url or qr (default: text).
+//
+// Profiles:
+//
+//	The config file, disapyr/config.yaml under the user config directory or
+//	$DISAPYR_CLI_CONFIG, holds named profiles with a server, ui_url, ca_cert,
+//	auth.domain, auth.audience and auth.client_id. Flags and environment
+//	variables take precedence over the profile. "disapyr config" lists, shows, sets and deletes profiles; the
+//	profile named "default" is used when none is selected.
+//
+// Output:
+//
+//	Text output is for people and may change. JSON output is one indented
+//	object on stdout, and in JSON mode errors are written to stderr as
+//	{"error": ..., "category": ..., "status": ..., "exit_code": ...}, where
+//	category and status are present for API errors. create prints the key,
+//	watch token and, if a UI URL is set, the share URL <ui-url>/secret/<key>;
+//	--output=qr draws the share URL as a QR code in the terminal above them.
+//
+// Authentication:
+//
+//	A token given with --token or DISAPYR_TOKEN is sent as is. Otherwise, if
+//	DISAPYR_CLIENT_SECRET is set, a client credentials token is requested for
+//	DISAPYR_CLIENT_ID. Otherwise the token saved by "disapyr login" for the
+//	server is used, and refreshed when it expires. Tokens are cached in
+//	disapyr/tokens.json under the user config directory with mode 0600; client
+//	secrets are never written to it. Without any token requests are sent
+//	unauthenticated.
+//
+// Run "disapyr <command> --help" for the flags of each command.
 //
 // Environment Variables:
 //
-//	GO_ENV           If set to "production", TLS verification will be enforced.
-//	CUSTOM_CA_CERT   Path to a custom CA certificate for development environments.
-//	                 If provided, this certificate will be used instead of bypassing TLS verification.
+//	DISAPYR_PROFILE        Config profile to use.
+//	DISAPYR_CLI_CONFIG     Path of the CLI config file.
+//	DISAPYR_TOKEN          Bearer token sent to the API.
+//	DISAPYR_UI_URL         Base URL of the web UI, used for share URLs.
+//	DISAPYR_AUTH_DOMAIN    Identity provider domain, e.g. tenant.auth0.com.
+//	DISAPYR_AUDIENCE       Audience of the API's tokens.
+//	DISAPYR_CLIENT_ID      OAuth client ID used to obtain tokens.
+//	DISAPYR_CLIENT_SECRET  Client secret, for client credentials tokens in scripts.
+//	GO_ENV                 If set to "production", TLS verification will be enforced.
+//	CUSTOM_CA_CERT         Path to a custom CA certificate for development environments,
+//	                       overriding the profile's ca_cert. If provided, this certificate
+//	                       will be used instead of bypassing TLS verification.
 //
 // API Endpoints:
 //   - POST /secret: Stores a secret and returns a key.
 //   - GET /secret/:key: Retrieves a secret using the provided key.
+//   - DELETE /secret/:key: Burns a secret without reading it.
+//   - GET /status/:token: Returns the status of a secret for its creator.
+//   - GET /admin/audit: Lists audit events.
+//   - GET /admin/audit/verify: Verifies the audit log hash chain.
 //
-// Error Handling:
-//   - The tool ensures that either --store or --retrieve is specified, but not both.
-//   - Input validation is performed for required flags (--secret for storing and --key for retrieving).
-//   - Errors from API calls or JSON processing are displayed, and the program exits with a non-zero status.
+// Exec mode:
 //
-// Example:
+//	exec retrieves the secret, sets it in the environment of the given command
+//	only, forwards SIGINT, SIGTERM, SIGHUP, SIGQUIT, SIGUSR1 and SIGUSR2 to it and
+//	exits with its exit code (128 plus the signal number if a signal ended it).
+//	A text secret needs --env NAME unless it holds a JSON object or dotenv
+//	document; key/value and dotenv secrets set one variable per field. The value
+//	is never printed. The command is looked up before the secret is burned, and
+//	a missing command exits with 127.
 //
-//	To store a secret:
-//	  ./cli --store --secret="my-secret" --server="https://api.example.com"
-//	To retrieve a secret:
-//	  ./cli --retrieve --key="my-key" --server="https://api.example.com"
+// Exit codes:
+//
+//	0  success
+//	1  local failure, such as an unreadable file or an unreachable server
+//	2  invalid command line
+//	3  authentication failed (API category "auth")
+//	4  permission denied ("forbidden")
+//	5  invalid request ("validation")
+//	6  secret not found or already retrieved ("not_found")
+//	7  secret or file too large ("payload_too_large")
+//	8  rate limited ("rate_limit")
+//	9  server or database error ("server", "database")
+//
+// Errors are written to stderr. Retrieved files are written with mode 0600 and
+// never overwrite an existing file.
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/squarehole/disapyr/client"
+	"github.com/squarehole/disapyr/internal"
 )
 
+// commands maps each subcommand to its implementation. exec is handled
+// separately because it exits with the code of the command it runs.
+var commands = map[string]func(opts *apiOptions, args []string) error{
+	"create":       runCreate,
+	"get":          runGet,
+	"status":       runStatus,
+	"burn":         runBurn,
+	"audit":        runAudit,
+	"verify-audit": runVerifyAudit,
+	"login":        runLogin,
+	"logout":       runLogout,
+	"config":       runConfig,
+}
+
+const usage = `Usage: disapyr <command> [flags] [arguments]
+
+Commands:
+  create        Store a secret read from --file, stdin or a prompt
+  get KEY       Retrieve and burn a secret
+  status TOKEN  Show whether a secret has been opened, using its watch token
+  burn KEY      Burn a secret without reading it
+  exec          Run a command with a secret in its environment
+  audit         List audit events (requires an admin token)
+  verify-audit  Verify the audit log hash chain (requires an admin token)
+  login         Log in with the device code flow and cache the token
+  logout        Forget the cached token for the server
+  config        Manage the profiles in the CLI config file
+
+Run "disapyr <command> --help" for the flags of each command.
+`
+
 func main() {
-	// Define CLI flags.
-	storeCmd := flag.Bool("store", false, "Store a secret")
-	retrieveCmd := flag.Bool("retrieve", false, "Retrieve a secret")
-	secretVal := flag.String("secret", "", "Secret to store (use with --store)")
-	keyVal := flag.String("key", "", "Key to retrieve the secret (use with --retrieve)")
-	server := flag.String("server", "https://localhost:3000", "API server URL (default: https://localhost:3000)")
-	flag.Parse()
-
-	// Ensure that either store or retrieve is selected, but not both.
-	if *storeCmd && *retrieveCmd {
-		fmt.Println("Error: Cannot use both --store and --retrieve flags together.")
-		os.Exit(1)
-	}
-	if !*storeCmd && !*retrieveCmd {
-		fmt.Println("Error: Please specify either --store or --retrieve flag.")
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	// Create HTTP client with appropriate TLS configuration
-	client := createHTTPClient()
-
-	if *storeCmd {
-		// Validate input.
-		if *secretVal == "" {
-			fmt.Println("Error: Please provide a secret using the --secret flag.")
-			os.Exit(1)
-		}
-
-		// Prepare and send a POST request to /secret.
-		url := fmt.Sprintf("%s/secret", *server)
-		payload := map[string]string{"secret": *secretVal}
-		jsonPayload, err := json.Marshal(payload)
-		if err != nil {
-			fmt.Println("Error marshalling JSON:", err)
-			os.Exit(1)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitUsage)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+	opts := &apiOptions{output: outputText}
+	switch name {
+	case "exec":
+		os.Exit(runExec(opts, args))
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	}
+
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n%s", name, usage)
+		os.Exit(exitUsage)
+	}
+	if err := run(opts, args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		opts.printError(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// apiOptions are the flags shared by every command, completed from the
+// environment and the selected profile by resolve.
+type apiOptions struct {
+	profile string
+	server  string
+	token   string
+	output  string
+	uiURL   string
+	caCert  string
+	auth    internal.AuthConfig
+
+	// tokenResolved is set once token has been looked up in the token cache.
+	tokenResolved bool
+}
+
+// newFlagSet returns a flag set for a command with the shared flags defined
+// in opts. synopsis is printed above the flag defaults by --help.
+func newFlagSet(opts *apiOptions, name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.profile, "profile", "", "Config profile to use (default: $DISAPYR_PROFILE or the current profile)")
+	fs.StringVar(&opts.token, "token", os.Getenv("DISAPYR_TOKEN"), "Bearer token sent to the API (default: $DISAPYR_TOKEN)")
+	fs.StringVar(&opts.server, "server", "", "API server URL (default: the profile's server or "+defaultServer+")")
+	fs.StringVar(&opts.uiURL, "ui-url", os.Getenv("DISAPYR_UI_URL"), "Base URL of the web UI, used to build share links (default: $DISAPYR_UI_URL or the profile's ui_url)")
+	fs.Func("output", "Output format: text or json; create also accepts url and qr (default: text)", func(v string) error {
+		return opts.setOutput(name, v)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: disapyr %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args into fs and returns the positional arguments, which
+// must number exactly nargs.
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
 		}
+		return nil, &usageError{msg: err.Error()}
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return nil, usageErrorf("%s takes %d argument(s), got %d", fs.Name(), nargs, fs.NArg())
+	}
+	return fs.Args(), nil
+}
 
-		resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonPayload))
-		if err != nil {
-			fmt.Println("Error calling API:", err)
-			os.Exit(1)
+// parse parses the flags of an API command like parseFlags and then resolves
+// the settings not given as flags.
+func (o *apiOptions) parse(fs *flag.FlagSet, args []string, nargs int) ([]string, error) {
+	pos, err := parseFlags(fs, args, nargs)
+	if err != nil {
+		return nil, err
+	}
+	return pos, o.resolve()
+}
+
+// accessToken returns the bearer token to send: --token or DISAPYR_TOKEN if
+// set, otherwise a cached, client credentials or refreshed token. Failing to
+// get one is an authentication error.
+func (o *apiOptions) accessToken() (string, error) {
+	if o.token != "" || o.tokenResolved {
+		return o.token, nil
+	}
+	token, err := cachedAccessToken(context.Background(), o.server, o.auth)
+	if err != nil {
+		return "", &client.Error{Category: client.CategoryAuth, Message: err.Error()}
+	}
+	o.token, o.tokenResolved = token, true
+	return token, nil
+}
+
+// newClient returns an API client for the selected server, CA certificate and
+// token.
+func (o *apiOptions) newClient() *client.Client {
+	return client.New(o.server,
+		client.WithHTTPClient(createHTTPClient(o.caCert)),
+		client.WithTokenSource(client.TokenSourceFunc(func(context.Context) (string, error) {
+			return o.accessToken()
+		})),
+		client.WithUserAgent("disapyr-cli"),
+	)
+}
+
+// runCreate implements "disapyr create".
+func runCreate(opts *apiOptions, args []string) error {
+	fs := newFlagSet(opts, "create", "create [--type text|kv|dotenv] [--file PATH]")
+	fileVal := fs.String("file", "", "File to store; with --type kv or dotenv its contents are the secret")
+	typeVal := fs.String("type", internal.SecretTypeText, "Secret type: text, kv (a JSON object of string fields) or dotenv")
+	if _, err := opts.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if (opts.output == outputURL || opts.output == outputQR) && opts.uiURL == "" {
+		return usageErrorf("--output %s needs the UI base URL in --ui-url, DISAPYR_UI_URL or the profile's ui_url", opts.output)
+	}
+	// Text files are uploaded as files; everything else is sent as JSON.
+	var secret, file string
+	var err error
+	if *typeVal == internal.SecretTypeText && *fileVal != "" {
+		file = *fileVal
+	} else {
+		if secret, err = readSecret(*fileVal); err != nil {
+			return err
 		}
-		defer resp.Body.Close()
+		if secret == "" {
+			return usageErrorf("the secret is empty")
+		}
+	}
+	fields, err := parseSecret(*typeVal, secret)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println("Error reading response:", err)
-			os.Exit(1)
+	ctx := context.Background()
+	c := opts.newClient()
+	if err := checkLimits(ctx, c, secret, file); err != nil {
+		return err
+	}
+
+	var stored *client.Created
+	switch {
+	case file != "":
+		f, ferr := os.Open(file)
+		if ferr != nil {
+			return ferr
+		}
+		defer f.Close()
+		stored, err = c.CreateFile(ctx, file, f)
+	case fields != nil:
+		stored, err = c.CreateFields(ctx, fields)
+	default:
+		stored, err = c.Create(ctx, secret, client.WithSecretType(client.SecretType(*typeVal)))
+	}
+	if err != nil {
+		return err
+	}
+
+	created := createResult{Key: stored.Key, WatchToken: stored.WatchToken}
+	created.URL = opts.shareURL(created.Key)
+	text := func(w io.Writer) error {
+		fmt.Fprintf(w, "Secret stored successfully.\nKey: %s\nWatch token: %s\n", created.Key, created.WatchToken)
+		if created.URL != "" {
+			fmt.Fprintf(w, "Share URL: %s\n", created.URL)
+		}
+		return nil
+	}
+	switch opts.output {
+	case outputURL:
+		fmt.Println(created.URL)
+		return nil
+	case outputQR:
+		// The secret is stored by now, so a failure here still prints the key.
+		code, err := internal.EncodeQR(created.URL)
+		if err == nil {
+			err = code.WriteTerminal(os.Stdout)
 		}
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("API error: %s\n", body)
-			os.Exit(1)
+		if terr := text(os.Stdout); err == nil {
+			err = terr
 		}
+		return err
+	}
+	return opts.print(created, text)
+}
 
-		// Display the returned key.
-		fmt.Printf("Secret stored successfully. Key: %s\n", body)
-	} else if *retrieveCmd {
-		// Validate input.
-		if *keyVal == "" {
-			fmt.Println("Error: Please provide a key using the --key flag.")
-			os.Exit(1)
+// parseSecret checks a secret of the given type before it is stored. It
+// returns the fields of a key/value secret, and nil for the other types.
+func parseSecret(secretType, secret string) (map[string]string, error) {
+	switch secretType {
+	case internal.SecretTypeText:
+		return nil, nil
+	case internal.SecretTypeKV:
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+			return nil, fmt.Errorf("a kv secret must be a JSON object of strings: %w", err)
+		}
+		return fields, nil
+	case internal.SecretTypeDotenv:
+		if _, err := internal.ParseDotenv(secret); err != nil {
+			return nil, fmt.Errorf("invalid dotenv document: %w", err)
 		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown secret type %q; use text, kv or dotenv", secretType)
+	}
+}
+
+// runGet implements "disapyr get".
+func runGet(opts *apiOptions, args []string) error {
+	fs := newFlagSet(opts, "get", "get [--out PATH] [--export] KEY")
+	outVal := fs.String("out", "", "Path a file secret is written to, - for stdout (default: its original name)")
+	exportOut := fs.Bool("export", false, "Print key/value and dotenv secrets as shell export lines (text output only)")
+	pos, err := opts.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if opts.output == outputJSON && (*exportOut || *outVal == "-") {
+		return usageErrorf("--output json cannot be combined with --export or --out -")
+	}
 
-		// Prepare and send a GET request to /secret/:key.
-		url := fmt.Sprintf("%s/secret/%s", *server, *keyVal)
+	secret, err := opts.newClient().Get(context.Background(), pos[0])
+	if err != nil {
+		return err
+	}
 
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Println("Error creating request:", err)
-			os.Exit(1)
+	// File secrets are streamed to disk rather than printed.
+	if secret.Body != nil {
+		defer secret.Body.Close()
+		path, n, err := saveFileSecret(secret, *outVal)
+		if err != nil || path == "-" {
+			return err
 		}
+		return opts.print(fileResult{Type: "file", Path: path, Size: n}, func(w io.Writer) error {
+			fmt.Fprintf(os.Stderr, "Secret file saved to %s (%d bytes)\n", path, n)
+			return nil
+		})
+	}
 
-		// Get access token for authentication
-		// Note: This is a placeholder. In a real implementation, you would need to
-		// obtain an access token from Auth0 or another authentication provider.
-		// For now, we're just making an unauthenticated request.
+	return opts.print(secret, func(w io.Writer) error {
+		// Key/value and dotenv secrets are printed as their fields.
+		if len(secret.Fields) > 0 {
+			return printFields(w, secret.Fields, *exportOut)
+		}
+		_, err := fmt.Fprintln(w, secret.Text)
+		return err
+	})
+}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Println("Error calling API:", err)
-			os.Exit(1)
+// printFields writes the fields of a structured secret as indented JSON, or
+// as shell export lines sorted by name.
+func printFields(w io.Writer, fields map[string]string, export bool) error {
+	if !export {
+		return writeJSON(w, fields)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if !internal.ValidEnvName(name) {
+			return fmt.Errorf("field %q is not a valid variable name", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "export %s=%s\n", name, shellQuote(fields[name]))
+	}
+	return nil
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runStatus implements "disapyr status".
+func runStatus(opts *apiOptions, args []string) error {
+	fs := newFlagSet(opts, "status", "status WATCH_TOKEN")
+	pos, err := opts.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	status, err := opts.newClient().Status(context.Background(), pos[0])
+	if err != nil {
+		return err
+	}
+	return opts.print(status, func(w io.Writer) error {
+		switch {
+		case status.OpenedAt != nil:
+			fmt.Fprintf(w, "%s at %s\n", status.Status, status.OpenedAt.Format(time.RFC3339))
+		case status.RevokedAt != nil:
+			fmt.Fprintf(w, "%s at %s\n", status.Status, status.RevokedAt.Format(time.RFC3339))
+		default:
+			fmt.Fprintln(w, status.Status)
+		}
+		return nil
+	})
+}
+
+// runBurn implements "disapyr burn".
+func runBurn(opts *apiOptions, args []string) error {
+	fs := newFlagSet(opts, "burn", "burn KEY")
+	pos, err := opts.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if err := opts.newClient().Burn(context.Background(), pos[0]); err != nil {
+		return err
+	}
+	result := burnResult{Key: pos[0], Status: internal.SecretStatusRevoked}
+	return opts.print(result, func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, "Secret burned.")
+		return err
+	})
+}
+
+// checkLimits checks the secret or file against the size limits published by
+// the server before uploading it. If the limits cannot be fetched the upload
+// goes ahead and the server enforces them.
+func checkLimits(ctx context.Context, c *client.Client, secret, path string) error {
+	published, err := c.Limits(ctx)
+	if err != nil {
+		return nil
+	}
+	limits := internal.Limits{
+		MaxSecretSize: published.MaxSecretSize,
+		MaxFileSize:   published.MaxFileSize,
+		MaxBodySize:   published.MaxBodySize,
+		TextEncoding:  published.TextEncoding,
+	}
+
+	tooLarge := &client.Error{Category: client.CategoryPayloadTooLarge}
+	if path == "" {
+		switch err := limits.CheckSecret([]byte(secret)); {
+		case errors.Is(err, internal.ErrSecretTooLarge):
+			tooLarge.Message = fmt.Sprintf("secret is larger than the server's limit of %d bytes", limits.MaxSecretSize)
+			return tooLarge
+		case err != nil:
+			return usageErrorf("%v", err)
 		}
-		defer resp.Body.Close()
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	switch err := limits.CheckFile(info.Size()); {
+	case errors.Is(err, internal.ErrFileTooLarge):
+		tooLarge.Message = fmt.Sprintf("%s is larger than the server's limit of %d bytes", path, limits.MaxFileSize)
+		return tooLarge
+	case err != nil:
+		return usageErrorf("%s: %v", path, err)
+	}
+	return nil
+}
 
-		body, err := io.ReadAll(resp.Body)
+// saveFileSecret writes a retrieved file secret to out, or to its original
+// filename in the current directory if out is empty. The file is created with
+// mode 0600 and an existing file is never overwritten. It returns the path
+// written and its size.
+func saveFileSecret(secret *client.Secret, out string) (string, int64, error) {
+	if out == "-" {
+		n, err := io.Copy(os.Stdout, secret.Body)
 		if err != nil {
-			fmt.Println("Error reading response:", err)
-			os.Exit(1)
+			return "", 0, fmt.Errorf("reading response: %w", err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("API error: %s\n", body)
-			os.Exit(1)
+		return out, n, nil
+	}
+	if out == "" {
+		out = filepath.Base(secret.Filename)
+		if out == "." || out == "/" || out == "" {
+			out = "secret.bin"
 		}
+	}
 
-		// Display the retrieved secret.
-		fmt.Printf("Retrieved secret: %s\n", body)
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", 0, fmt.Errorf("creating output file: %w; the secret has been burned and cannot be retrieved again", err)
+	}
+	n, err := io.Copy(f, secret.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return "", 0, fmt.Errorf("writing output file: %w", err)
+	}
+	return out, n, nil
+}
+
+// runAudit implements "disapyr audit". Events are printed one per line. The
+// key is hashed locally so it is never sent to the server in a query string.
+func runAudit(opts *apiOptions, args []string) error {
+	fs := newFlagSet(opts, "audit", "audit [--key KEY] [--event-type TYPE]")
+	keyVal := fs.String("key", "", "Only list events for this secret key")
+	eventType := fs.String("event-type", "", "Audit event type to filter on")
+	if _, err := opts.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if *keyVal != "" {
+		query.Set("key_hash", internal.HashKey(*keyVal))
+	}
+	if *eventType != "" {
+		query.Set("event_type", *eventType)
+	}
+
+	var result struct {
+		Events []internal.AuditEvent `json:"events"`
+	}
+	if err := opts.newClient().GetJSON(context.Background(), "/admin/audit?"+query.Encode(), &result); err != nil {
+		return err
+	}
+	return opts.print(result, func(w io.Writer) error {
+		for _, e := range result.Events {
+			fmt.Fprintf(w, "%d\t%s\t%s\tprincipal=%s\tip=%s\tkey_hash=%s\tdetail=%s\n",
+				e.ID, e.OccurredAt.Format(time.RFC3339), e.Type, e.Principal, e.IP, e.KeyHash, e.Detail)
+		}
+		return nil
+	})
+}
+
+// runVerifyAudit implements "disapyr verify-audit". It fails if the hash chain
+// is broken; in JSON mode the result is printed first.
+func runVerifyAudit(opts *apiOptions, args []string) error {
+	fs := newFlagSet(opts, "verify-audit", "verify-audit")
+	if _, err := opts.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	var result internal.AuditVerification
+	if err := opts.newClient().GetJSON(context.Background(), "/admin/audit/verify", &result); err != nil {
+		return err
+	}
+	err := opts.print(result, func(w io.Writer) error {
+		if result.Valid {
+			fmt.Fprintf(w, "Audit chain valid: %d events, head %s\n", result.Events, result.Head)
+		}
+		return nil
+	})
+	if err == nil && !result.Valid {
+		err = fmt.Errorf("audit chain BROKEN at event %d: %s", result.BrokenAt, result.Reason)
 	}
+	return err
 }
 
-// createHTTPClient creates an HTTP client with appropriate TLS configuration
-func createHTTPClient() *http.Client {
+// createHTTPClient creates an HTTP client with appropriate TLS configuration.
+// customCACert is the path of a CA certificate to trust outside production,
+// from CUSTOM_CA_CERT or the profile.
+func createHTTPClient(customCACert string) *http.Client {
 	var tr *http.Transport
 
 	// Check if we're in production mode
@@ -162,7 +651,6 @@ func createHTTPClient() *http.Client {
 		tr = &http.Transport{}
 	} else {
 		// In development, check if a custom CA certificate is provided
-		customCACert := os.Getenv("CUSTOM_CA_CERT")
 		if customCACert != "" {
 			// Use the custom CA certificate
 			rootCAs, _ := x509.SystemCertPool()
@@ -173,14 +661,14 @@ func createHTTPClient() *http.Client {
 			// Read the custom CA certificate
 			caCert, err := os.ReadFile(customCACert)
 			if err != nil {
-				fmt.Printf("Warning: Could not read custom CA certificate: %v\n", err)
-				fmt.Println("Falling back to standard TLS verification")
+				fmt.Fprintf(os.Stderr, "Warning: Could not read custom CA certificate: %v\n", err)
+				fmt.Fprintln(os.Stderr, "Falling back to standard TLS verification")
 				tr = &http.Transport{}
 			} else {
 				// Add the custom CA certificate to the cert pool
 				if ok := rootCAs.AppendCertsFromPEM(caCert); !ok {
-					fmt.Println("Warning: Failed to append custom CA certificate to cert pool")
-					fmt.Println("Falling back to standard TLS verification")
+					fmt.Fprintln(os.Stderr, "Warning: Failed to append custom CA certificate to cert pool")
+					fmt.Fprintln(os.Stderr, "Falling back to standard TLS verification")
 					tr = &http.Transport{}
 				} else {
 					// Use the custom CA certificate for TLS verification
@@ -189,14 +677,14 @@ func createHTTPClient() *http.Client {
 							RootCAs: rootCAs,
 						},
 					}
-					fmt.Println("Using custom CA certificate for TLS verification")
+					fmt.Fprintln(os.Stderr, "Using custom CA certificate for TLS verification")
 				}
 			}
 		} else {
 			// No custom CA certificate provided, use standard TLS verification
 			// but print a warning
-			fmt.Println("Warning: No custom CA certificate provided for development environment")
-			fmt.Println("Using standard TLS verification. Set CUSTOM_CA_CERT environment variable to use a custom CA certificate")
+			fmt.Fprintln(os.Stderr, "Warning: No custom CA certificate provided for development environment")
+			fmt.Fprintln(os.Stderr, "Using standard TLS verification. Set CUSTOM_CA_CERT or the profile's ca_cert to use a custom CA certificate")
 			tr = &http.Transport{}
 		}
 	}
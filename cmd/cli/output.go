@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/squarehole/disapyr/client"
+	"github.com/squarehole/disapyr/internal"
+)
+
+// Output formats selected with --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputURL  = "url"
+	outputQR   = "qr"
+)
+
+// createResult is the JSON output of "disapyr create".
+type createResult struct {
+	Key        string `json:"key"`
+	WatchToken string `json:"watch_token"`
+	URL        string `json:"url,omitempty"`
+}
+
+// fileResult is the JSON output of "disapyr get" for a file saved to disk.
+type fileResult struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// burnResult is the JSON output of "disapyr burn".
+type burnResult struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+}
+
+// errorResult is written to stderr for failed commands in JSON mode.
+type errorResult struct {
+	Error    string          `json:"error"`
+	Category client.Category `json:"category,omitempty"`
+	Status   int             `json:"status,omitempty"`
+	ExitCode int             `json:"exit_code"`
+}
+
+// setOutput validates and sets the output format for command.
+func (o *apiOptions) setOutput(command, format string) error {
+	switch format {
+	case outputText, outputJSON:
+	case outputURL, outputQR:
+		if command != "create" {
+			return fmt.Errorf("%s is only supported by create", format)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	o.output = format
+	return nil
+}
+
+// shareURL returns the link for key on the configured UI, or "" if no UI
+// URL is configured.
+func (o *apiOptions) shareURL(key string) string {
+	if o.uiURL == "" {
+		return ""
+	}
+	return internal.ShareURL(o.uiURL, key)
+}
+
+// print writes v as JSON in JSON mode, and otherwise calls text.
+func (o *apiOptions) print(v any, text func(w io.Writer) error) error {
+	if o.output == outputJSON {
+		return writeJSON(os.Stdout, v)
+	}
+	return text(os.Stdout)
+}
+
+// printError reports err on stderr, as JSON in JSON mode.
+func (o *apiOptions) printError(err error) {
+	o.writeError(os.Stderr, err)
+}
+
+func (o *apiOptions) writeError(w io.Writer, err error) {
+	if o.output != outputJSON {
+		fmt.Fprintln(w, "Error:", err)
+		return
+	}
+	result := errorResult{Error: err.Error(), ExitCode: exitCode(err)}
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		result.Category = apiErr.Category
+		result.Status = apiErr.StatusCode
+		if apiErr.Message != "" {
+			result.Error = apiErr.Message
+		}
+	}
+	_ = writeJSON(w, result)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
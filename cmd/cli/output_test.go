@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/squarehole/disapyr/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, exitOK},
+		{"auth", &client.Error{Category: client.CategoryAuth}, exitAuth},
+		{"forbidden", &client.Error{Category: client.CategoryForbidden}, exitForbidden},
+		{"validation", &client.Error{Category: client.CategoryValidation}, exitValidation},
+		{"not found", &client.Error{Category: client.CategoryNotFound}, exitNotFound},
+		{"payload too large", &client.Error{Category: client.CategoryPayloadTooLarge}, exitPayloadTooLarge},
+		{"rate limit", &client.Error{Category: client.CategoryRateLimit}, exitRateLimit},
+		{"server", &client.Error{Category: client.CategoryServer}, exitServer},
+		{"database", &client.Error{Category: client.CategoryDatabase}, exitServer},
+		{"unknown category", &client.Error{Category: "teapot"}, exitError},
+		{"wrapped API error", fmt.Errorf("get: %w", &client.Error{Category: client.CategoryNotFound}), exitNotFound},
+		{"usage", usageErrorf("missing key"), exitUsage},
+		{"local", errors.New("connection refused"), exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exitCode(tt.err))
+		})
+	}
+
+	// Every category has its own exit code, apart from database errors,
+	// which are server errors to the caller.
+	codes := map[int]client.Category{}
+	for category, code := range categoryExitCodes {
+		if category == client.CategoryDatabase {
+			continue
+		}
+		assert.NotContains(t, codes, code, "%s and %s share exit code %d", category, codes[code], code)
+		codes[code] = category
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	decode := func(t *testing.T, err error) map[string]any {
+		var buf bytes.Buffer
+		(&apiOptions{output: outputJSON}).writeError(&buf, err)
+		var out map[string]any
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+		return out
+	}
+
+	t.Run("API error", func(t *testing.T) {
+		out := decode(t, &client.Error{StatusCode: 404, Category: client.CategoryNotFound, Message: "Secret not found"})
+		assert.Equal(t, map[string]any{
+			"error":     "Secret not found",
+			"category":  "not_found",
+			"status":    float64(404),
+			"exit_code": float64(exitNotFound),
+		}, out)
+	})
+
+	t.Run("local error", func(t *testing.T) {
+		out := decode(t, errors.New("connection refused"))
+		assert.Equal(t, map[string]any{"error": "connection refused", "exit_code": float64(exitError)}, out)
+	})
+
+	t.Run("usage error", func(t *testing.T) {
+		out := decode(t, usageErrorf("missing key"))
+		assert.Equal(t, map[string]any{"error": "missing key", "exit_code": float64(exitUsage)}, out)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		(&apiOptions{output: outputText}).writeError(&buf, errors.New("connection refused"))
+		assert.Equal(t, "Error: connection refused\n", buf.String())
+	})
+}
+
+func TestSetOutput(t *testing.T) {
+	tests := []struct {
+		command, format string
+		ok              bool
+	}{
+		{"create", outputText, true},
+		{"create", outputJSON, true},
+		{"create", outputURL, true},
+		{"create", outputQR, true},
+		{"get", outputJSON, true},
+		{"get", outputURL, false},
+		{"get", outputQR, false},
+		{"burn", outputURL, false},
+		{"exec", outputQR, false},
+		{"config", outputURL, false},
+		{"create", "yaml", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+" "+tt.format, func(t *testing.T) {
+			var o apiOptions
+			err := o.setOutput(tt.command, tt.format)
+			if tt.ok {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.format, o.output)
+			} else {
+				assert.Error(t, err)
+				assert.Empty(t, o.output, "a rejected format is not set")
+			}
+		})
+	}
+}
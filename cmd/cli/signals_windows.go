@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// forwardedSignals are caught so that they do not end this process. Windows
+// cannot send them on, but the console delivers Ctrl+C to the child as well.
+var forwardedSignals = []os.Signal{os.Interrupt}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/squarehole/disapyr/client"
+)
+
+// uiError is a failure as shown to the user: a message, the status sent to
+// the browser and the Bootstrap alert class, so that problems the user can
+// fix stand out from outages.
+type uiError struct {
+	Status     int
+	Category   client.Category
+	Class      string
+	Message    string
+	RetryAfter time.Duration
+}
+
+// Alert classes for the kinds of failure
+const (
+	alertValidation = "alert-warning"
+	alertRateLimit  = "alert-info"
+	alertFailure    = "alert-danger"
+)
+
+// createErrors are shown in place of the link when the API refuses a new
+// secret, by error category. Other categories are shown as unavailable.
+var createErrors = map[client.Category]uiError{
+	client.CategoryValidation: {
+		Status: fiber.StatusBadRequest, Class: alertValidation,
+		Message: "This secret could not be stored because it is not valid. Check it and try again.",
+	},
+	client.CategoryPayloadTooLarge: {
+		Status: fiber.StatusRequestEntityTooLarge, Class: alertValidation,
+		Message: "This secret is too large to share.",
+	},
+	client.CategoryRateLimit: {
+		Status: fiber.StatusTooManyRequests, Class: alertRateLimit,
+		Message: "Too many secrets have been shared in a short time.",
+	},
+}
+
+// retrieveErrors are shown on the display page when the API refuses to
+// return a secret, by error category.
+var retrieveErrors = map[client.Category]uiError{
+	client.CategoryNotFound: {
+		Status: fiber.StatusNotFound, Class: alertFailure,
+		Message: "Secret not found. It may have already been retrieved.",
+	},
+	client.CategoryValidation: {
+		Status: fiber.StatusBadRequest, Class: alertValidation,
+		Message: "This link is not valid. Check that it was copied in full.",
+	},
+	client.CategoryRateLimit: {
+		Status: fiber.StatusTooManyRequests, Class: alertRateLimit,
+		Message: "Too many requests in a short time. The secret has not been opened yet.",
+	},
+}
+
+// unavailable is shown for failures the user cannot do anything about, such
+// as the API being down or refusing the UI's credentials.
+var unavailable = uiError{
+	Status: fiber.StatusBadGateway, Class: alertFailure,
+	Message: "The service is unavailable right now. Please try again later.",
+}
+
+// newUIError returns how err, returned by the API client, is shown to the
+// user, looking up API errors in messages by category. Unexpected failures
+// are logged.
+func newUIError(err error, messages map[client.Category]uiError) uiError {
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) {
+		log.Error("Error during API call", "err", err)
+		return unavailable
+	}
+	e, ok := messages[apiErr.Category]
+	if !ok {
+		log.Error("API call failed", "err", err, "category", apiErr.Category)
+		e = unavailable
+	}
+	e.Category = apiErr.Category
+	if apiErr.Category == client.CategoryRateLimit {
+		wait := "a moment"
+		if apiErr.RetryAfter > 0 {
+			e.RetryAfter = apiErr.RetryAfter
+			wait = apiErr.RetryAfter.Round(time.Second).String()
+		}
+		e.Message = fmt.Sprintf("%s Please try again in %s.", e.Message, wait)
+	}
+	return e
+}
+
+// sendError sends e as the "error" fragment shown in place of the link.
+func sendError(c *fiber.Ctx, e uiError) error {
+	if e.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+	c.Status(e.Status)
+	return render(c, fragments, "error", e)
+}
+
+// errorHandler shows posts over the body limit, which are refused before
+// any route runs, with the same fragment as an oversized secret.
+func errorHandler(c *fiber.Ctx, err error) error {
+	var fe *fiber.Error
+	if errors.As(err, &fe) && fe.Code == fiber.StatusRequestEntityTooLarge {
+		e := createErrors[client.CategoryPayloadTooLarge]
+		e.Category = client.CategoryPayloadTooLarge
+		return sendError(c, e)
+	}
+	return fiber.DefaultErrorHandler(c, err)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/squarehole/disapyr/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		messages map[client.Category]uiError
+		status   int
+		class    string
+		message  string
+	}{
+		{"create validation", &client.Error{Category: client.CategoryValidation}, createErrors,
+			fiber.StatusBadRequest, alertValidation, createErrors[client.CategoryValidation].Message},
+		{"create too large", &client.Error{Category: client.CategoryPayloadTooLarge}, createErrors,
+			fiber.StatusRequestEntityTooLarge, alertValidation, createErrors[client.CategoryPayloadTooLarge].Message},
+		{"create rate limited", &client.Error{Category: client.CategoryRateLimit, RetryAfter: 90 * time.Second}, createErrors,
+			fiber.StatusTooManyRequests, alertRateLimit, "Too many secrets have been shared in a short time. Please try again in 1m30s."},
+		{"create rate limited without Retry-After", &client.Error{Category: client.CategoryRateLimit}, createErrors,
+			fiber.StatusTooManyRequests, alertRateLimit, "Too many secrets have been shared in a short time. Please try again in a moment."},
+		{"retrieve not found", &client.Error{Category: client.CategoryNotFound}, retrieveErrors,
+			fiber.StatusNotFound, alertFailure, retrieveErrors[client.CategoryNotFound].Message},
+		{"retrieve validation", &client.Error{Category: client.CategoryValidation}, retrieveErrors,
+			fiber.StatusBadRequest, alertValidation, retrieveErrors[client.CategoryValidation].Message},
+		{"retrieve rate limited", &client.Error{Category: client.CategoryRateLimit, RetryAfter: 1500 * time.Millisecond}, retrieveErrors,
+			fiber.StatusTooManyRequests, alertRateLimit, "Too many requests in a short time. The secret has not been opened yet. Please try again in 2s."},
+		{"auth", &client.Error{Category: client.CategoryAuth}, createErrors,
+			fiber.StatusBadGateway, alertFailure, unavailable.Message},
+		{"forbidden", &client.Error{Category: client.CategoryForbidden}, retrieveErrors,
+			fiber.StatusBadGateway, alertFailure, unavailable.Message},
+		{"server", &client.Error{Category: client.CategoryServer}, createErrors,
+			fiber.StatusBadGateway, alertFailure, unavailable.Message},
+		{"database", &client.Error{Category: client.CategoryDatabase}, retrieveErrors,
+			fiber.StatusBadGateway, alertFailure, unavailable.Message},
+		{"not found on create", &client.Error{Category: client.CategoryNotFound}, createErrors,
+			fiber.StatusBadGateway, alertFailure, unavailable.Message},
+		{"not an API error", errors.New("connection refused"), createErrors,
+			fiber.StatusBadGateway, alertFailure, unavailable.Message},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newUIError(tt.err, tt.messages)
+			assert.Equal(t, tt.status, e.Status)
+			assert.Equal(t, tt.class, e.Class)
+			assert.Equal(t, tt.message, e.Message)
+			var apiErr *client.Error
+			if errors.As(tt.err, &apiErr) {
+				assert.Equal(t, apiErr.Category, e.Category)
+				assert.Equal(t, apiErr.RetryAfter, e.RetryAfter)
+			} else {
+				assert.Empty(t, e.Category)
+			}
+		})
+	}
+
+	// The shared messages are not changed by the lookups above.
+	assert.Equal(t, "Too many secrets have been shared in a short time.", createErrors[client.CategoryRateLimit].Message)
+}
+
+func TestSendErrorRetryAfter(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return sendError(c, newUIError(&client.Error{Category: client.CategoryRateLimit, RetryAfter: 1500 * time.Millisecond}, createErrors))
+	})
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get(fiber.HeaderRetryAfter))
+}
+
+func TestErrorHandlerBodyLimit(t *testing.T) {
+	// The body limit is enforced while reading the request, which app.Test
+	// skips, so the app is served on a real listener.
+	app := fiber.New(fiber.Config{BodyLimit: 1024, ErrorHandler: errorHandler, DisableStartupMessage: true})
+	app.Post("/", func(c *fiber.Ctx) error { return c.SendString("stored") })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() { _ = app.Listener(ln) }()
+	defer app.Shutdown()
+	url := "http://" + ln.Addr().String() + "/"
+
+	resp, err := http.Post(url, "application/x-www-form-urlencoded", strings.NewReader("secret="+strings.Repeat("x", 2048)))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get(fiber.HeaderContentType))
+	assert.Contains(t, string(body), `data-category="payload_too_large"`)
+	assert.Contains(t, string(body), alertValidation)
+	assert.Contains(t, string(body), createErrors[client.CategoryPayloadTooLarge].Message)
+
+	// Other errors keep fiber's default handling.
+	resp, err = http.Get(url + "missing")
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.NotContains(t, string(body), "alert")
+}
@@ -1,197 +1,326 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/log"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/joho/godotenv"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
+	"github.com/squarehole/disapyr/client"
 	"github.com/squarehole/disapyr/internal"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// qrScale is the size in pixels of each module of a PNG QR code.
+const qrScale = 8
+
+// idpTimeout bounds each request to the identity provider.
+const idpTimeout = 30 * time.Second
+
 func main() {
 
-	logger := log.NewWithOptions(os.Stderr, log.Options{
-		ReportCaller:    true,
-		ReportTimestamp: true,
-		TimeFormat:      time.RFC3339Nano,
-		Level:           log.DebugLevel,
-		Formatter:       log.JSONFormatter,
-	})
+	logger := internal.NewLogger()
 
 	log.SetDefault(logger)
 
-	app := fiber.New()
-
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// Load the configuration from file, environment and flags
+	cfg, err := internal.LoadConfig(internal.UIComponent, os.Args[1:])
+	if errors.Is(err, internal.ErrConfigPrinted) || errors.Is(err, flag.ErrHelp) {
+		return
 	}
-
-	root, err := os.OpenRoot("./")
 	if err != nil {
-		log.Fatal("Error opening root:", "err", err)
+		log.Fatal(err)
 	}
+	internal.ApplyLogConfig(logger, cfg.Log)
 
-	defer root.Close()
-
-	// Serve static files
-	app.Static(".", root.Name())
-
-	captureSecretHTML, err := os.ReadFile("capture_secret.html")
+	// Set up trace export before any spans are started
+	shutdownTracing, err := internal.InitTracing(context.Background(), cfg.Tracing, "disapyr-ui")
 	if err != nil {
-		log.Fatal("Error reading capture_secret.html: %v", err)
+		log.Fatal(err)
 	}
 
-	// Load BASE_URL from environment variables
-	baseURL := os.Getenv("BASE_URL")
-	uiHostPort := os.Getenv("UI_HOST_PORT")
-
-	// Get the access token from the external API
-	log.Info("Getting access token...")
-	accessToken, err := internal.GetAccessToken()
-	if err != nil {
-		log.Error("Error getting access token:", "err", err)
-		return
-	}
+	// Reject form posts the API would refuse anyway.
+	app := fiber.New(fiber.Config{BodyLimit: int(cfg.Limits.MaxBodySize), ErrorHandler: errorHandler})
+
+	// Expose Prometheus metrics for UI traffic. This comes first so every
+	// route is counted.
+	internal.RegisterMetrics(app, cfg.Metrics)
+	app.Use(internal.RequestIDMiddleware())
+	app.Use(internal.TracingMiddleware())
+	app.Use(internal.AccessLogMiddleware())
+
+	// Serve the embedded stylesheet
+	app.Use("/static", filesystem.New(filesystem.Config{Root: http.FS(assets), PathPrefix: "static"}))
+
+	baseURL := cfg.UI.APIBaseURL
+
+	// Access tokens are requested and refreshed in the background, so the UI
+	// starts, and reports not ready, while the identity provider is
+	// unreachable.
+	tokens := internal.NewTokenSource(cfg.Auth, &http.Client{Timeout: idpTimeout})
+	tokenCtx, stopTokens := context.WithCancel(context.Background())
+	defer stopTokens()
+	go tokens.Run(tokenCtx)
+
+	// All calls to the API share one client and its connections.
+	httpClient := createSecureHTTPClient(cfg.UI)
+	apiClient := client.New(baseURL,
+		client.WithHTTPClient(httpClient),
+		client.WithTokenSource(tokens),
+		client.WithUserAgent("disapyr-ui"),
+	)
+
+	// Readiness requires a reachable API and an access token to call it with.
+	internal.RegisterHealthRoutes(app, []internal.HealthCheck{
+		{Name: "api", Check: func(ctx context.Context) error {
+			return checkAPIHealth(ctx, httpClient, baseURL)
+		}},
+		{Name: "access_token", Check: tokens.Check},
+	})
 
 	// GET handler to serve the main page for capturing the secret.
 	app.Get("/", func(c *fiber.Ctx) error {
-		log.Info("Serving capture_secret.html")
-		c.Set("Content-Type", "text/html; charset=utf-8")
-		return c.SendString(string(captureSecretHTML))
+		return render(c, capturePage, "layout", nil)
+	})
+
+	// Size limits published by the API, which the capture page checks before
+	// uploading. The UI's own configuration is used if the API is unreachable.
+	fallbackLimits := internal.NewLimits(cfg.Limits)
+	app.Get("/limits", func(c *fiber.Ctx) error {
+		limits, err := apiClient.Limits(c.UserContext(), client.WithRequestID(internal.RequestID(c)))
+		if err != nil {
+			log.Warn("Failed to fetch limits from API", "err", err)
+			return c.JSON(fallbackLimits)
+		}
+		return c.JSON(limits)
 	})
 
 	// POST handler to capture the secret and generate the one-time link.
 	app.Post("/", func(c *fiber.Ctx) error {
 		log.Info("POST /")
 		secret := c.FormValue("secret")
+		file, _ := c.FormFile("file")
+		if file != nil && file.Size == 0 {
+			file = nil
+		}
 
-		if secret != "" {
+		if secret != "" && file != nil {
+			return sendError(c, uiError{Status: fiber.StatusBadRequest, Category: client.CategoryValidation, Class: alertValidation, Message: "Share either a secret or a file, not both."})
+		}
+		if secret != "" || file != nil {
 			log.Info("Secret provided")
-			apiURL := fmt.Sprintf("%s/secret", baseURL)
-			log.Info("API URL: ", "url", apiURL)
-			jsonData := []byte(fmt.Sprintf(`{"secret":"%s"}`, secret))
-
-			// Create HTTP client with secure TLS configuration
-			client := createSecureHTTPClient()
-
-			log.Info("Creating new HTTP client")
-			req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
-			if err != nil {
-				log.Error("Error creating request", "err", err)
-				return c.Status(fiber.StatusInternalServerError).SendString("Internal Server Error")
+			requestID := client.WithRequestID(internal.RequestID(c))
+
+			// Files are streamed to the API as multipart/form-data.
+			var created *client.Created
+			var err error
+			if file != nil {
+				f, ferr := file.Open()
+				if ferr != nil {
+					log.Error("Error opening uploaded file", "err", ferr)
+					return sendError(c, uiError{Status: fiber.StatusInternalServerError, Class: alertFailure, Message: "The file could not be uploaded. Please try again."})
+				}
+				defer f.Close()
+				created, err = apiClient.CreateFile(c.UserContext(), file.Filename, f,
+					client.WithContentType(file.Header.Get("Content-Type")), requestID)
+			} else {
+				created, err = apiClient.Create(c.UserContext(), secret, requestID)
 			}
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-
-			log.Info("Making API call")
-			resp, err := client.Do(req)
 			if err != nil {
-				log.Error("Error during API call", "err", err)
-				return c.Status(fiber.StatusInternalServerError).SendString("Internal Server Error")
-			}
-			defer resp.Body.Close()
-
-			var apiResponse struct {
-				Key string `json:"key"`
+				return sendError(c, newUIError(err, createErrors))
 			}
-			if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-				log.Error("Error decoding API response", "err", err)
-				return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
-			}
-
-			// Get the current URL for the app hosted by Fiber
-			currentURL := fmt.Sprintf("%s://%s/secret/%s", c.Protocol(), c.Hostname(), apiResponse.Key)
-			fmt.Printf("Current URL: %s\n", currentURL)
 
-			// The external API returns a key which is used to build the one-time link.
-			c.Set("Content-Type", "text/html; charset=utf-8")
-			return c.SendString(fmt.Sprintf("<div>%s</div>", currentURL))
+			// The external API returns a key which is used to build the one-time link,
+			// and a watch token used to show live status while the page is open.
+			return render(c, fragments, "result", newResultData(c, created.Key, created.WatchToken))
 		}
-		return c.SendString("No secret provided")
+		return sendError(c, uiError{Status: fiber.StatusBadRequest, Category: client.CategoryValidation, Class: alertValidation, Message: "Enter a secret or choose a file to share."})
 	})
 
 	// GET handler for displaying a secret retrieved from the external API.
 	app.Get("/secret/:key", func(c *fiber.Ctx) error {
-		key := c.Params("key")
-		apiURL := fmt.Sprintf("%s/secret/%s", baseURL, key)
+		secret, err := apiClient.Get(c.UserContext(), c.Params("key"), client.WithRequestID(internal.RequestID(c)))
+		if err != nil {
+			return displayErrorPage(c, newUIError(err, retrieveErrors))
+		}
 
-		// Create HTTP client with secure TLS configuration
-		client := createSecureHTTPClient()
+		// File secrets are streamed straight through as a download; the body
+		// is closed once it has been sent.
+		if secret.Body != nil {
+			c.Set("Content-Type", "application/octet-stream")
+			c.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": secret.Filename}))
+			c.Set("X-Content-Type-Options", "nosniff")
+			c.Set("Cache-Control", "no-store")
+			c.Context().SetBodyStream(secret.Body, int(secret.Size))
+			return nil
+		}
+
+		// Key/value and dotenv secrets are shown as a table of fields.
+		if len(secret.Fields) > 0 {
+			return displayFieldsPage(c, secret.Fields)
+		}
+		return displaySecretPage(c, secret.Text)
+	})
 
-		req, err := http.NewRequest("GET", apiURL, nil)
+	// GET handler rendering the one-time link of a key as a QR code, as SVG
+	// or, with ?format=png, as PNG. The code is generated locally.
+	app.Get("/qr/:key", func(c *fiber.Ctx) error {
+		code, err := internal.EncodeQR(shareURL(c, c.Params("key")))
 		if err != nil {
-			log.Error("Error creating request", "err", err)
-			return displaySecretPage(c, "Error retrieving secret")
+			return c.Status(fiber.StatusBadRequest).SendString("Link too long for a QR code")
 		}
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		// The code holds the link, which must not be cached.
+		c.Set("Cache-Control", "no-store")
+		var buf bytes.Buffer
+		switch c.Query("format", "svg") {
+		case "svg":
+			c.Set("Content-Type", "image/svg+xml")
+			err = code.WriteSVG(&buf)
+		case "png":
+			c.Set("Content-Type", "image/png")
+			err = code.WritePNG(&buf, qrScale)
+		default:
+			return c.Status(fiber.StatusBadRequest).SendString("Unknown QR code format")
+		}
+		if err != nil {
+			log.Error("Error rendering QR code", "err", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Internal Server Error")
+		}
+		return c.Send(buf.Bytes())
+	})
 
-		resp, err := client.Do(req)
+	// GET handler relaying a secret's status stream from the API as HTML
+	// fragments for the htmx SSE extension on the result page.
+	app.Get("/watch/:token", func(c *fiber.Ctx) error {
+		events, err := apiClient.Watch(c.UserContext(), c.Params("token"), client.WithRequestID(internal.RequestID(c)))
 		if err != nil {
+			var apiErr *client.Error
+			if errors.As(err, &apiErr) {
+				return c.Status(apiErr.StatusCode).SendString("Status unavailable")
+			}
 			log.Error("Error during API call", "err", err)
-			return displaySecretPage(c, "Error retrieving secret")
+			return c.Status(fiber.StatusBadGateway).SendString("Status unavailable")
 		}
-		defer resp.Body.Close()
 
-		// If the API returns a non-200 status, display a message in the readonly textbox.
-		if resp.StatusCode != http.StatusOK {
-			message := "Secret not found. It may have already been retrieved."
-			return displaySecretPage(c, message)
-		}
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("X-Accel-Buffering", "no")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer events.Close()
+			relayStatusEvents(events, w)
+		})
+		return nil
+	})
+
+	// Stop accepting connections on SIGINT/SIGTERM and drain in-flight requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		var apiResponse struct {
-			Secret string `json:"secret"`
+	log.Info("Starting server on:", "port", cfg.UI.Port)
+	listen := func() error { return app.Listen(fmt.Sprintf(":%d", cfg.UI.Port)) }
+	serveErr := internal.Serve(ctx, app, listen, cfg.UI.ShutdownTimeout)
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Error("Failed to flush traces", "err", err)
+	}
+	if serveErr != nil {
+		log.Fatal(serveErr)
+	}
+}
+
+// shareURL returns the one-time link for key on this UI.
+func shareURL(c *fiber.Ctx, key string) string {
+	return internal.ShareURL(fmt.Sprintf("%s://%s", c.Protocol(), c.Hostname()), key)
+}
+
+// relayStatusEvents reads status events from the API stream r and writes an
+// "opened" or "revoked" event carrying the replacement HTML to w once the
+// secret has been opened or revoked. Keepalive comments are passed through.
+func relayStatusEvents(r io.Reader, w *bufio.Writer) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, ":") {
+			fmt.Fprintf(w, "%s\n\n", line)
+			if err := w.Flush(); err != nil {
+				return
+			}
+			continue
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-			log.Error("Error decoding API response", "err", err)
-			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
 		}
 
-		return displaySecretPage(c, apiResponse.Secret)
-	})
-
-	log.Info("Starting server on:", "port", uiHostPort)
-	log.Fatal(app.Listen(fmt.Sprintf(":%s", uiHostPort)))
+		var status internal.SecretStatus
+		if err := json.Unmarshal([]byte(data), &status); err != nil {
+			log.Error("Error decoding status event", "err", err)
+			return
+		}
+		fragment, err := statusHTML(status)
+		if err != nil {
+			log.Error("Error rendering status event", "err", err)
+			return
+		}
+		if fragment == "" {
+			continue
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", status.Status, fragment)
+		w.Flush()
+		return
+	}
 }
 
-// displaySecretPage renders an HTML page with a read-only textarea containing the provided content.
-func displaySecretPage(c *fiber.Ctx, content string) error {
-	displaySecretHTML, err := os.ReadFile("display_secret.html")
+// checkAPIHealth reports whether the API server's liveness endpoint responds.
+func checkAPIHealth(ctx context.Context, client *http.Client, baseURL string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/healthz", baseURL), nil)
 	if err != nil {
-		log.Error("Error reading display_secret.html", "err", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error loading display template")
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("API unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API health check returned status %d", resp.StatusCode)
 	}
-	html := fmt.Sprintf(string(displaySecretHTML), content)
-	c.Set("Content-Type", "text/html; charset=utf-8")
-	return c.SendString(html)
+	return nil
 }
 
 // createSecureHTTPClient creates an HTTP client with secure TLS configuration
-func createSecureHTTPClient() *http.Client {
+func createSecureHTTPClient(cfg internal.UIConfig) *http.Client {
 	var tr *http.Transport
 
 	// Check if we're in production mode
-	if os.Getenv("GO_ENV") == "production" {
-		// In production, use the default transport with standard TLS verification
+	if cfg.Environment == "production" {
+		// In production, verify the API against the system CAs only
 		tr = &http.Transport{
 			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
+				MinVersion: tls.VersionTLS12,
 			},
 		}
 		log.Info("Using production TLS configuration")
 	} else {
 		// In development, check if a custom CA certificate is provided
-		customCACert := os.Getenv("CUSTOM_CA_CERT")
+		customCACert := cfg.CustomCACert
 		if customCACert != "" {
 			// Use the custom CA certificate
 			rootCAs, _ := x509.SystemCertPool()
@@ -225,10 +354,11 @@ func createSecureHTTPClient() *http.Client {
 			// No custom CA certificate provided, use standard TLS verification
 			// but log a warning
 			log.Warn("No custom CA certificate provided for development environment")
-			log.Info("Using standard TLS verification. Set CUSTOM_CA_CERT environment variable to use a custom CA certificate")
+			log.Info("Using standard TLS verification. Set ui.custom_ca_cert (env CUSTOM_CA_CERT) to use a custom CA certificate")
 			tr = &http.Transport{}
 		}
 	}
 
-	return &http.Client{Transport: tr}
+	// Propagate the W3C trace context to the API on every outbound request
+	return &http.Client{Transport: otelhttp.NewTransport(tr)}
 }
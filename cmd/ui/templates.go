@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/squarehole/disapyr/internal"
+)
+
+// assets holds the templates and static files, so the UI runs from any
+// directory.
+//
+//go:embed templates static
+var assets embed.FS
+
+// Pages are parsed with the shared layout; fragments are swapped into the
+// capture page by htmx.
+var (
+	capturePage = parsePage("templates/capture.html")
+	displayPage = parsePage("templates/display.html")
+	fragments   = template.Must(template.ParseFS(assets, "templates/fragments.html"))
+)
+
+func parsePage(name string) *template.Template {
+	return template.Must(template.ParseFS(assets, "templates/layout.html", name))
+}
+
+// displayData is the secret shown on the display page: the fields of a
+// structured secret, sorted by name, or otherwise Text. Error is shown
+// instead when the secret could not be retrieved.
+type displayData struct {
+	Text   string
+	Fields []field
+	Error  *uiError
+}
+
+type field struct {
+	Name  string
+	Value string
+}
+
+// resultData is the "result" fragment of a new secret.
+type resultData struct {
+	URL       string
+	QRPath    string
+	WatchPath string
+}
+
+// statusData is the "status" fragment of an opened or burned secret.
+type statusData struct {
+	Class   string
+	Message string
+	At      time.Time
+}
+
+// newResultData returns the result fragment for the secret stored under key.
+// The watch path is left out without a watch token.
+func newResultData(c *fiber.Ctx, key, watchToken string) resultData {
+	data := resultData{URL: shareURL(c, key), QRPath: "/qr/" + url.PathEscape(key)}
+	if watchToken != "" {
+		data.WatchPath = "/watch/" + url.PathEscape(watchToken)
+	}
+	return data
+}
+
+// sortedFields returns the fields of a structured secret sorted by name.
+func sortedFields(fields map[string]string) []field {
+	sorted := make([]field, 0, len(fields))
+	for name, value := range fields {
+		sorted = append(sorted, field{Name: name, Value: value})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// render executes the named template into a buffer, so a failure sends an
+// error rather than half a page, and sends it as HTML.
+func render(c *fiber.Ctx, t *template.Template, name string, data any) error {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Error("Error rendering template", "template", name, "err", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal Server Error")
+	}
+	c.Set("Content-Type", "text/html; charset=utf-8")
+	return c.Send(buf.Bytes())
+}
+
+// displaySecretPage renders the display page with text in a read-only
+// textarea.
+func displaySecretPage(c *fiber.Ctx, text string) error {
+	return render(c, displayPage, "layout", displayData{Text: text})
+}
+
+// displayErrorPage renders the display page with e in place of the secret.
+func displayErrorPage(c *fiber.Ctx, e uiError) error {
+	c.Status(e.Status)
+	return render(c, displayPage, "layout", displayData{Error: &e})
+}
+
+// displayFieldsPage renders the fields of a structured secret as a table,
+// sorted by name, with a copy button for each value.
+func displayFieldsPage(c *fiber.Ctx, fields map[string]string) error {
+	return render(c, displayPage, "layout", displayData{Fields: sortedFields(fields)})
+}
+
+// statusHTML renders the status fragment that replaces the live status
+// element once a secret is opened or revoked. It returns "" for other
+// statuses.
+func statusHTML(status internal.SecretStatus) (string, error) {
+	var data statusData
+	switch {
+	case status.Status == internal.SecretStatusOpened && status.OpenedAt != nil:
+		data = statusData{Class: "text-success", Message: "Opened", At: *status.OpenedAt}
+	case status.Status == internal.SecretStatusRevoked && status.RevokedAt != nil:
+		data = statusData{Class: "text-danger", Message: "Burned unread", At: *status.RevokedAt}
+	default:
+		return "", nil
+	}
+	var buf bytes.Buffer
+	err := fragments.ExecuteTemplate(&buf, "status", data)
+	return buf.String(), err
+}
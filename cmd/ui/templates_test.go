@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Payloads that break out of a textarea and of an attribute value.
+const (
+	textareaBreakout  = `</textarea><script>alert(1)</script>`
+	attributeBreakout = `"><img src=x onerror=alert(1)>`
+)
+
+func assertEscaped(t *testing.T, html string) {
+	t.Helper()
+	assert.NotContains(t, html, "<script>alert")
+	assert.NotContains(t, html, "<img src=x")
+	assert.NotContains(t, html, `"><img`)
+	assert.Contains(t, html, "&lt;/textarea&gt;&lt;script&gt;")
+	assert.Contains(t, html, "&lt;img src=x onerror=alert(1)&gt;")
+}
+
+func renderPage(t *testing.T, handler fiber.Handler) string {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/", handler)
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestDisplayPageEscapesSecrets(t *testing.T) {
+	t.Run("text", func(t *testing.T) {
+		html := renderPage(t, func(c *fiber.Ctx) error {
+			return displaySecretPage(c, textareaBreakout+attributeBreakout)
+		})
+		assertEscaped(t, html)
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		html := renderPage(t, func(c *fiber.Ctx) error {
+			return displayFieldsPage(c, map[string]string{
+				textareaBreakout:  attributeBreakout,
+				attributeBreakout: textareaBreakout,
+			})
+		})
+		assertEscaped(t, html)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		html := renderPage(t, func(c *fiber.Ctx) error {
+			return displayErrorPage(c, uiError{Status: fiber.StatusBadRequest, Class: alertValidation, Message: textareaBreakout + attributeBreakout})
+		})
+		assertEscaped(t, html)
+	})
+}
+
+func TestFragmentsEscapeSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, fragments.ExecuteTemplate(&buf, "error", uiError{Class: alertValidation, Message: textareaBreakout + attributeBreakout}))
+	assertEscaped(t, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, fragments.ExecuteTemplate(&buf, "result", resultData{URL: "https://disapyr.link/secret/" + textareaBreakout + attributeBreakout}))
+	assertEscaped(t, buf.String())
+}
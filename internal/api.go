@@ -4,15 +4,13 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/rsa"
 	"database/sql"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math/big"
-	"net/http"
-	"os"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,12 +24,27 @@ import (
 	"golang.org/x/time/rate"
 )
 
-var handler = func(c *fiber.Ctx) error {
+// Locals keys set by the auth middleware
+const (
+	principalLocal = "principal"
+	claimsLocal    = "claims"
+)
+
+// newAuthMiddleware returns a handler that validates the bearer token on each
+// request against the configured Auth0 tenant.
+func newAuthMiddleware(auth AuthConfig, jwks *JWKSCache) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return authenticate(c, auth, jwks)
+	}
+}
+
+func authenticate(c *fiber.Ctx, auth AuthConfig, jwks *JWKSCache) error {
 	log.Info("Handler called")
 
 	// Get the token from the Authorization header.
 	tokenString := c.Get("Authorization")
 	if tokenString == "" {
+		authFailuresTotal.WithLabelValues(authReasonMissingToken).Inc()
 		return HandleAuthError(c, "Missing authentication token", nil)
 	}
 
@@ -42,19 +55,18 @@ var handler = func(c *fiber.Ctx) error {
 	}
 
 	// Validate the token with Auth0.
-	auth0Domain := os.Getenv("URL")
+	auth0Domain := auth.Domain
 	if auth0Domain == "" {
 		return HandleServerError(c, "Auth0 domain not configured", nil)
 	}
 
-	// Get the audience from environment variable
-	audience := os.Getenv("AUDIENCE")
+	// Get the audience from the configuration
+	audience := auth.Audience
 	if audience == "" {
 		return HandleServerError(c, "Audience not configured", nil)
 	}
 
-	log.Infof("Auth0 domain: %s, Audience: %s", auth0Domain, audience)
-	jwksURL := fmt.Sprintf("https://%s/.well-known/jwks.json", auth0Domain)
+	log.Debugf("Auth0 domain: %s, Audience: %s", auth0Domain, audience)
 
 	// Parse with audience and issuer validation
 	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -64,78 +76,27 @@ var handler = func(c *fiber.Ctx) error {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		// Fetch the JWKS.
-		resp, err := http.Get(jwksURL)
-		if err != nil {
-			log.Errorf("failed to fetch JWKS: %v", err)
-			return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
-		}
-		defer resp.Body.Close()
-
-		// Check if the status code is OK.
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			log.Errorf("failed to fetch JWKS, status %d: %s", resp.StatusCode, string(body))
-			return nil, fmt.Errorf("failed to fetch JWKS, status %d", resp.StatusCode)
-		}
-
-		var jwks struct {
-			Keys []json.RawMessage `json:"keys"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
-			log.Errorf("failed to decode JWKS: %v", err)
-			return nil, fmt.Errorf("failed to decode JWKS: %w", err)
-		}
-
-		// Look for a key that matches the token's "kid" header.
-		for _, key := range jwks.Keys {
-			var k struct {
-				Kid string `json:"kid"`
-				N   string `json:"n"`
-				E   string `json:"e"`
-			}
-			if err := json.Unmarshal(key, &k); err != nil {
-				continue
-			}
-			if k.Kid == token.Header["kid"] {
-				nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
-				if err != nil {
-					log.Errorf("failed to decode N: %v", err)
-					return nil, fmt.Errorf("failed to decode N: %w", err)
-				}
-				eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
-				if err != nil {
-					log.Errorf("failed to decode E: %v", err)
-					return nil, fmt.Errorf("failed to decode E: %w", err)
-				}
-				e := 0
-				for _, b := range eBytes {
-					e = e*256 + int(b)
-				}
-				return &rsa.PublicKey{
-					N: new(big.Int).SetBytes(nBytes),
-					E: e,
-				}, nil
-			}
-		}
-		log.Warn("no matching key found")
-		return nil, fmt.Errorf("no matching key found")
+		// Look up the key that matches the token's "kid" header.
+		kid, _ := token.Header["kid"].(string)
+		return jwks.Key(c.UserContext(), kid)
 	})
 
 	if err != nil {
+		authFailuresTotal.WithLabelValues(authReasonInvalidToken).Inc()
 		return HandleAuthError(c, "Invalid token", err)
 	}
 
 	// In jwt/v5 we need to explicitly check claims validity
 	if !parsedToken.Valid {
+		authFailuresTotal.WithLabelValues(authReasonInvalidClaims).Inc()
 		return HandleAuthError(c, "Token validation failed", nil)
 	}
 
 	// Validate audience and issuer claims
 	claims := parsedToken.Claims.(jwt.MapClaims)
 
-	// Log the claims for debugging
-	log.Debugf("Token claims: %v", claims)
+	// Log only the token subject; the full claims are never logged.
+	log.Debug("Token parsed", "sub", claims["sub"], "request_id", RequestID(c))
 
 	// Verify audience claim
 	tokenAud, ok := claims["aud"].(string)
@@ -143,6 +104,7 @@ var handler = func(c *fiber.Ctx) error {
 		// Handle case where aud is an array of strings
 		tokenAudArray, ok := claims["aud"].([]interface{})
 		if !ok {
+			authFailuresTotal.WithLabelValues(authReasonAudience).Inc()
 			return HandleAuthError(c, fmt.Sprintf("Invalid audience format: %v", claims["aud"]), nil)
 		}
 
@@ -156,9 +118,11 @@ var handler = func(c *fiber.Ctx) error {
 		}
 
 		if !audFound {
+			authFailuresTotal.WithLabelValues(authReasonAudience).Inc()
 			return HandleAuthError(c, fmt.Sprintf("Invalid audience: %v, expected: %s", tokenAudArray, audience), nil)
 		}
 	} else if tokenAud != audience {
+		authFailuresTotal.WithLabelValues(authReasonAudience).Inc()
 		return HandleAuthError(c, fmt.Sprintf("Invalid audience: %v, expected: %s", tokenAud, audience), nil)
 	}
 
@@ -166,13 +130,47 @@ var handler = func(c *fiber.Ctx) error {
 	expectedIssuer := fmt.Sprintf("https://%s/", auth0Domain)
 	tokenIss, ok := claims["iss"].(string)
 	if !ok || tokenIss != expectedIssuer {
+		authFailuresTotal.WithLabelValues(authReasonIssuer).Inc()
 		return HandleAuthError(c, fmt.Sprintf("Invalid issuer: %v, expected: %s", claims["iss"], expectedIssuer), nil)
 	}
 
+	// Remember who is calling for authorization checks and the audit log.
+	c.Locals(principalLocal, fmt.Sprint(claims["sub"]))
+	c.Locals(claimsLocal, claims)
+
 	// Token is valid; proceed to the next handler.
 	return c.Next()
 }
 
+// Principal returns the subject of the validated token for the current request.
+func Principal(c *fiber.Ctx) string {
+	sub, _ := c.Locals(principalLocal).(string)
+	return sub
+}
+
+// requireScope rejects requests whose token does not grant scope, either in
+// the space-separated "scope" claim or in the Auth0 "permissions" array.
+func requireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, _ := c.Locals(claimsLocal).(jwt.MapClaims)
+		if scopes, ok := claims["scope"].(string); ok {
+			for _, s := range strings.Fields(scopes) {
+				if s == scope {
+					return c.Next()
+				}
+			}
+		}
+		if perms, ok := claims["permissions"].([]interface{}); ok {
+			for _, p := range perms {
+				if p == scope {
+					return c.Next()
+				}
+			}
+		}
+		return HandleForbiddenError(c, fmt.Sprintf("Principal %s lacks scope %s", Principal(c), scope), nil)
+	}
+}
+
 // HideIdentifier encrypts the provided identifier using AES-GCM,
 // prepends the nonce, and returns a Base58-encoded string.
 func HideIdentifier(id string, key []byte) (string, error) {
@@ -204,28 +202,35 @@ func HideIdentifier(id string, key []byte) (string, error) {
 	return encoded, nil
 }
 
-func NewDatabaseConnection() (*sql.DB, error) {
-	// Retrieve database connection details from environment variables.
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
-	dbUseSSL := os.Getenv("DB_USESSL")
+// ShareURL returns the link a recipient opens to read the secret stored under
+// key, on the UI served at base.
+func ShareURL(base, key string) string {
+	return strings.TrimRight(base, "/") + "/secret/" + url.PathEscape(key)
+}
+
+// databaseURL builds the PostgreSQL connection string for cfg.
+func databaseURL(cfg DatabaseConfig) string {
 	sslMode := "disable"
-	if strings.ToLower(dbUseSSL) == "true" {
+	if cfg.UseSSL {
 		sslMode = "require"
 	}
 
-	// Build the PostgreSQL connection string.
-	var connStr string
-	if dbPassword != "" {
-		connStr = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", dbUser, dbPassword, dbHost, dbPort, dbName, sslMode)
+	connURL := &url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:     "/" + cfg.Name,
+		RawQuery: "sslmode=" + sslMode,
+	}
+	if cfg.Password != "" {
+		connURL.User = url.UserPassword(cfg.User, cfg.Password)
 	} else {
-		connStr = fmt.Sprintf("postgres://%s@%s:%s/%s?sslmode=%s", dbUser, dbHost, dbPort, dbName, sslMode)
+		connURL.User = url.User(cfg.User)
 	}
+	return connURL.String()
+}
 
-	db, err := sql.Open("postgres", connStr)
+func NewDatabaseConnection(cfg DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", databaseURL(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to database: %w", err)
 	}
@@ -236,16 +241,9 @@ func NewDatabaseConnection() (*sql.DB, error) {
 	db.SetConnMaxLifetime(5 * time.Minute)
 	db.SetConnMaxIdleTime(5 * time.Minute)
 
-	// Create the 'secrets' table if it does not exist.
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS secrets (
-		key TEXT PRIMARY KEY,
-		secret TEXT,
-		retrieved_at TIMESTAMP NULL
-	);
-	`
-	if _, err := db.Exec(createTableSQL); err != nil {
-		return nil, fmt.Errorf("error creating table: %w", err)
+	// Bring the schema up to date.
+	if err := Migrate(db); err != nil {
+		return nil, err
 	}
 
 	return db, nil
@@ -255,9 +253,30 @@ func CreateRateLimiter(rateLimit int) *rate.Limiter {
 	return rate.NewLimiter(rate.Limit(rateLimit), 2*rateLimit)
 }
 
-func RegisterRoutes(app *fiber.App, db *sql.DB, limiter *rate.Limiter, encKey string, keyLen int) {
+func RegisterRoutes(app *fiber.App, db *sql.DB, limiter *rate.Limiter, cfg *Config, audit *AuditLog, events *SecretEvents, blobs *Blobs) {
+	encKey := cfg.Security.EncKey
+	keyLen := cfg.Security.KeyLen
+	jwks := NewJWKSCache(cfg.Auth.Domain, cfg.Auth.JWKSCacheTTL)
+	handler := newAuthMiddleware(cfg.Auth, jwks)
 
 	log.Info("registering routes")
+
+	// Make the audit log available to HandleError for auth failures.
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals(auditLocal, audit)
+		return c.Next()
+	})
+
+	// Unauthenticated liveness and readiness probes.
+	RegisterHealthRoutes(app, apiHealthChecks(db, jwks, cfg))
+
+	// Unauthenticated size limits, so clients can check before uploading.
+	limits := NewLimits(cfg.Limits)
+	registerLimitsRoute(app, limits)
+
+	// Admin endpoints for the audit log.
+	registerAuditRoutes(app, audit, handler, cfg.Auth.AdminScope)
+
 	// Endpoint to store a secret.
 	app.Post("/secret", handler, func(c *fiber.Ctx) error {
 		log.Infof("New secret request from %s", c.IP())
@@ -266,15 +285,74 @@ func RegisterRoutes(app *fiber.App, db *sql.DB, limiter *rate.Limiter, encKey st
 			return HandleRateLimitError(c, fmt.Sprintf("Too many requests from %v, limit: %v", c.IP(), limiter.Limit()), nil)
 		}
 
-		type RequestBody struct {
-			Secret string `json:"secret"`
+		// Files arrive as multipart/form-data; text secrets as JSON.
+		var body createRequest
+		var err error
+		if isMultipart(c) {
+			body, err = parseMultipartSecret(c, limits, []byte(encKey))
+			if body.file != nil {
+				defer body.file.Close()
+			}
+		} else {
+			body, err = parseJSONSecret(c, limits)
+		}
+		if errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrSecretTooLarge) {
+			// The rest of the body is left unread.
+			c.Context().SetConnectionClose()
 		}
-		var body RequestBody
-		if err := c.BodyParser(&body); err != nil {
-			return HandleValidationError(c, "Cannot parse JSON request body", err)
+		switch {
+		case errors.Is(err, ErrBodyTooLarge):
+			return HandlePayloadTooLargeError(c, fmt.Sprintf("Request body exceeds the maximum size of %d bytes", limits.MaxBodySize), nil)
+		case errors.Is(err, ErrFileTooLarge):
+			return HandlePayloadTooLargeError(c, fmt.Sprintf("File exceeds the maximum size of %d bytes", limits.MaxFileSize), nil)
+		case errors.Is(err, ErrSecretTooLarge):
+			return HandlePayloadTooLargeError(c, fmt.Sprintf("Secret exceeds the maximum size of %d bytes", limits.MaxSecretSize), nil)
+		case errors.Is(err, ErrSecretNotUTF8):
+			return HandleValidationError(c, "Secret is not valid UTF-8", nil)
+		case errors.Is(err, ErrFileEmpty):
+			return HandleValidationError(c, "File is empty", nil)
+		case errors.Is(err, ErrSecretAndFile):
+			return HandleValidationError(c, "Provide either a secret or a file, not both", nil)
+		case err != nil:
+			return HandleValidationError(c, "Cannot parse request body", err)
 		}
-		if body.Secret == "" {
-			return HandleValidationError(c, "Secret is required", nil)
+		// Key/value and dotenv secrets are validated and encrypted as a whole.
+		var structured []byte
+		switch body.Type {
+		case "", SecretTypeText:
+			if len(body.Fields) > 0 {
+				return HandleValidationError(c, "Fields are only accepted for kv secrets", nil)
+			}
+			if body.Secret == "" && body.file == nil {
+				return HandleValidationError(c, "Secret or file is required", nil)
+			}
+		default:
+			structured, err = structuredSecret(body, limits)
+			switch {
+			case errors.Is(err, ErrSecretTooLarge):
+				return HandlePayloadTooLargeError(c, fmt.Sprintf("Secret exceeds the maximum size of %d bytes", limits.MaxSecretSize), nil)
+			case err != nil:
+				return HandleValidationError(c, fmt.Sprintf("Invalid %s secret", body.Type), err)
+			}
+		}
+		if body.NotifyURL != "" {
+			if !cfg.Notify.Enabled {
+				return HandleValidationError(c, "Notifications are not enabled", nil)
+			}
+			if err := ValidateNotifyURL(body.NotifyURL, cfg.Notify); err != nil {
+				return HandleValidationError(c, "Invalid notify_url", err)
+			}
+		}
+		for _, email := range []string{body.RecipientEmail, body.NotifyEmail} {
+			if email == "" {
+				continue
+			}
+			if !cfg.Mail.Enabled {
+				return HandleValidationError(c, "Email delivery is not enabled", nil)
+			}
+			if err := ValidateEmail(email); err != nil {
+				return HandleValidationError(c, "Invalid email address", err)
+			}
 		}
 
 		// Generate a unique key.
@@ -287,13 +365,88 @@ func RegisterRoutes(app *fiber.App, db *sql.DB, limiter *rate.Limiter, encKey st
 			return HandleServerError(c, "Failed to generate key", err)
 		}
 
-		// Insert the secret and key into the database.
-		_, err = db.Exec("INSERT INTO secrets(key, secret) VALUES($1, $2)", key, body.Secret)
+		// Email the one-time link to the recipient if one was given.
+		var mails []OutgoingMail
+		if body.RecipientEmail != "" {
+			mails = append(mails, OutgoingMail{
+				To:       body.RecipientEmail,
+				Template: MailSecretLink,
+				Data:     MailData{Link: ShareURL(cfg.Mail.LinkBaseURL, key)},
+			})
+		}
+
+		// The watch token lets only the creator follow the secret's status.
+		watchToken, err := NewWatchToken()
 		if err != nil {
+			return HandleServerError(c, "Failed to generate watch token", err)
+		}
+
+		// Notifications are signed with a key of their own, so a receiver
+		// cannot forge notifications to other receivers.
+		var notifySecret string
+		if body.NotifyURL != "" {
+			if notifySecret, err = NewNotifySecret(); err != nil {
+				return HandleServerError(c, "Failed to generate notification signing secret", err)
+			}
+		}
+
+		// Insert the secret and key into the database.
+		rec := secretRecord{
+			Key:            key,
+			Kind:           secretKindText,
+			NotifyURL:      body.NotifyURL,
+			NotifySecret:   notifySecret,
+			NotifyEmail:    body.NotifyEmail,
+			WatchTokenHash: HashKey(watchToken),
+		}
+		// Text is sealed like everything else, so that no plaintext reaches
+		// the database.
+		content := []byte(body.Secret)
+		if structured != nil {
+			rec.Kind = body.Type
+			content = structured
+		}
+		if body.file == nil {
+			rec.Size = int64(len(content))
+			rec.Payload, err = sealSecret(content, []byte(encKey))
+			if err != nil {
+				return HandleServerError(c, "Failed to encrypt secret", err)
+			}
+		}
+		if f := body.file; f != nil {
+			rec.Kind = secretKindFile
+			rec.Filename = f.Filename
+			rec.ContentType = f.ContentType
+			rec.Size = f.Size
+
+			// Large files are kept in the blob store rather than the row.
+			rec.BlobKey, err = blobs.offload(c.UserContext(), f)
+			if err != nil {
+				return HandleServerError(c, "Failed to store file in blob store", err)
+			}
+			if rec.BlobKey == "" {
+				rec.Payload, err = f.Ciphertext()
+				if err != nil {
+					return HandleServerError(c, "Failed to read encrypted file", err)
+				}
+			}
+		}
+		if err := storeSecret(c.UserContext(), db, []byte(encKey), rec, mails...); err != nil {
+			if rec.BlobKey != "" {
+				blobs.discard(rec.BlobKey)
+			}
 			return HandleDatabaseError(c, "Failed to store secret in database", err)
 		}
 
-		return c.JSON(fiber.Map{"key": key})
+		secretsCreatedTotal.Inc()
+		secretPayloadBytes.Observe(float64(rec.Size))
+		audit.Record(c.UserContext(), NewAuditEvent(c, AuditCreate, key, ""))
+
+		resp := fiber.Map{"key": key, "watch_token": watchToken}
+		if notifySecret != "" {
+			resp["notify_secret"] = notifySecret
+		}
+		return c.JSON(resp)
 	})
 
 	// Endpoint to retrieve a secret exactly once.
@@ -305,44 +458,88 @@ func RegisterRoutes(app *fiber.App, db *sql.DB, limiter *rate.Limiter, encKey st
 
 		key := c.Params("key")
 
-		// Start a transaction to ensure atomic read-update.
-		tx, err := db.Begin()
+		rec, err := consumeSecret(c.UserContext(), db, []byte(encKey), key)
+		switch {
+		case errors.Is(err, ErrSecretNotFound):
+			secretLookupFailuresTotal.WithLabelValues("not_found").Inc()
+			audit.Record(c.UserContext(), NewAuditEvent(c, AuditFailedRetrieve, key, "not_found"))
+			return HandleNotFoundError(c, fmt.Sprintf("Secret with key fingerprint %s not found", KeyFingerprint(key)), nil)
+		case errors.Is(err, ErrSecretAlreadyRetrieved):
+			secretLookupFailuresTotal.WithLabelValues("already_retrieved").Inc()
+			audit.Record(c.UserContext(), NewAuditEvent(c, AuditFailedRetrieve, key, "already_retrieved"))
+			return HandleNotFoundError(c, "Secret already retrieved", nil)
+		case err != nil:
+			return HandleDatabaseError(c, "Failed to retrieve secret from database", err)
+		}
+
+		secretsRetrievedTotal.Inc()
+		audit.Record(c.UserContext(), NewAuditEvent(c, AuditRetrieve, key, ""))
+
+		// Return the original secret along with its type.
+		if rec.Kind == secretKindFile {
+			return sendFileSecret(c, rec, []byte(encKey), blobs)
+		}
+		resp, err := openSecret(rec, []byte(encKey))
 		if err != nil {
-			return HandleDatabaseError(c, "Failed to start database transaction", err)
+			return HandleServerError(c, "Failed to decrypt secret", err)
 		}
-		defer func() {
-			if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
-				log.Error("Failed to rollback transaction", "error", err)
-			}
-		}()
+		return c.JSON(resp)
+	})
 
-		var secret string
-		var retrievedAt *time.Time
-		err = tx.QueryRow("SELECT secret, retrieved_at FROM secrets WHERE key = $1 FOR UPDATE", key).Scan(&secret, &retrievedAt)
-		if err == sql.ErrNoRows {
-			return HandleNotFoundError(c, fmt.Sprintf("Secret with key %s not found", key), nil)
-		} else if err != nil {
-			return HandleDatabaseError(c, "Failed to query secret from database", err)
+	// Endpoint to burn a secret without reading it.
+	app.Delete("/secret/:key", handler, func(c *fiber.Ctx) error {
+		if !limiter.Allow() {
+			return HandleRateLimitError(c, "Too many requests", nil)
 		}
 
-		// Check if the secret has already been retrieved.
-		if retrievedAt != nil || secret == "" {
+		key := c.Params("key")
+		err := revokeSecret(c.UserContext(), db, key)
+		switch {
+		case errors.Is(err, ErrSecretNotFound):
+			return HandleNotFoundError(c, fmt.Sprintf("Secret with key fingerprint %s not found", KeyFingerprint(key)), nil)
+		case errors.Is(err, ErrSecretAlreadyRetrieved):
 			return HandleNotFoundError(c, "Secret already retrieved", nil)
+		case err != nil:
+			return HandleDatabaseError(c, "Failed to revoke secret", err)
 		}
 
-		// Clear the secret and record the retrieval time.
-		now := time.Now()
-		_, err = tx.Exec("UPDATE secrets SET secret = '', retrieved_at = $1 WHERE key = $2", now, key)
-		if err != nil {
-			return HandleDatabaseError(c, "Failed to update secret in database", err)
+		secretsRevokedTotal.Inc()
+		audit.Record(c.UserContext(), NewAuditEvent(c, AuditRevoke, key, ""))
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	// Current status of a secret, for its creator.
+	app.Get("/status/:token", handler, func(c *fiber.Ctx) error {
+		if !limiter.Allow() {
+			return HandleRateLimitError(c, "Too many requests", nil)
+		}
+
+		status, err := lookupSecretStatus(c.UserContext(), db, HashKey(c.Params("token")))
+		switch {
+		case errors.Is(err, ErrSecretNotFound):
+			return HandleNotFoundError(c, "No secret for watch token", nil)
+		case err != nil:
+			return HandleDatabaseError(c, "Failed to look up secret status", err)
+		}
+		return c.JSON(status)
+	})
+
+	// Server-Sent Events stream of a secret's status, for its creator.
+	app.Get("/watch/:token", handler, func(c *fiber.Ctx) error {
+		if !limiter.Allow() {
+			return HandleRateLimitError(c, "Too many requests", nil)
 		}
 
-		// Commit the transaction.
-		if err = tx.Commit(); err != nil {
-			return HandleDatabaseError(c, "Failed to commit transaction", err)
+		tokenHash := HashKey(c.Params("token"))
+		status, err := lookupSecretStatus(c.UserContext(), db, tokenHash)
+		switch {
+		case errors.Is(err, ErrSecretNotFound):
+			return HandleNotFoundError(c, "No secret for watch token", nil)
+		case err != nil:
+			return HandleDatabaseError(c, "Failed to look up secret status", err)
 		}
 
-		// Return the original secret.
-		return c.JSON(fiber.Map{"secret": secret})
+		streamSecretStatus(c, db, events, tokenHash, status)
+		return nil
 	})
 }
@@ -1,16 +1,24 @@
 package internal
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"database/sql/driver"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/mr-tron/base58"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestShareURL(t *testing.T) {
+	assert.Equal(t, "https://disapyr.link/secret/abc", ShareURL("https://disapyr.link", "abc"))
+	assert.Equal(t, "https://example.com/ui/secret/abc", ShareURL("https://example.com/ui/", "abc"))
+}
+
 func TestHideIdentifier(t *testing.T) {
 	key := []byte("example key 1234") // 16 bytes key for AES-128
 
@@ -83,6 +91,88 @@ func TestHideIdentifier(t *testing.T) {
 	})
 }
 
+func TestRevokeSecret(t *testing.T) {
+	revokeColumns := []string{"blob_key", "retrieved_at", "revoked_at", "watch_token_hash"}
+
+	t.Run("pending", func(t *testing.T) {
+		db, f := newFakeDB(t,
+			fakeResult{match: "FOR UPDATE", columns: revokeColumns, rows: [][]driver.Value{{"blob1", nil, nil, "watch"}}},
+			fakeResult{match: "revoked_at = $1"},
+			fakeResult{match: "INSERT INTO blob_deletions"},
+			fakeResult{match: "pg_notify"},
+		)
+		assert.NoError(t, revokeSecret(context.Background(), db, "key"))
+		assert.Equal(t, "COMMIT", f.Statements()[len(f.Statements())-1])
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		db, _ := newFakeDB(t, fakeResult{match: "FOR UPDATE", columns: revokeColumns})
+		assert.ErrorIs(t, revokeSecret(context.Background(), db, "key"), ErrSecretNotFound)
+	})
+
+	t.Run("already retrieved", func(t *testing.T) {
+		db, f := newFakeDB(t, fakeResult{match: "FOR UPDATE", columns: revokeColumns, rows: [][]driver.Value{{"", time.Now(), nil, ""}}})
+		assert.ErrorIs(t, revokeSecret(context.Background(), db, "key"), ErrSecretAlreadyRetrieved)
+		assert.NotContains(t, f.Statements(), "COMMIT")
+	})
+
+	t.Run("already revoked", func(t *testing.T) {
+		db, _ := newFakeDB(t, fakeResult{match: "FOR UPDATE", columns: revokeColumns, rows: [][]driver.Value{{"", nil, time.Now(), ""}}})
+		assert.ErrorIs(t, revokeSecret(context.Background(), db, "key"), ErrSecretAlreadyRetrieved)
+	})
+}
+
+func TestLookupSecretStatus(t *testing.T) {
+	columns := []string{"retrieved_at", "revoked_at"}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		row  []driver.Value
+		want SecretStatus
+	}{
+		{"pending", []driver.Value{nil, nil}, SecretStatus{Status: SecretStatusPending}},
+		{"opened", []driver.Value{at, nil}, SecretStatus{Status: SecretStatusOpened, OpenedAt: &at}},
+		{"revoked", []driver.Value{nil, at}, SecretStatus{Status: SecretStatusRevoked, RevokedAt: &at}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newFakeDB(t, fakeResult{match: "watch_token_hash = $1", columns: columns, rows: [][]driver.Value{tt.row}})
+			status, err := lookupSecretStatus(context.Background(), db, "hash")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, status)
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		db, _ := newFakeDB(t, fakeResult{match: "watch_token_hash = $1", columns: columns})
+		_, err := lookupSecretStatus(context.Background(), db, "hash")
+		assert.ErrorIs(t, err, ErrSecretNotFound)
+	})
+}
+
+func TestRevokedAtMigration(t *testing.T) {
+	m := migrations[7]
+	assert.Equal(t, 8, m.version)
+
+	db, f := newFakeDB(t,
+		fakeResult{match: "pg_advisory_xact_lock"},
+		fakeResult{match: "FROM schema_migrations", columns: []string{"exists"}, rows: [][]driver.Value{{false}}},
+		fakeResult{match: "ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMPTZ"},
+		fakeResult{match: "INSERT INTO schema_migrations"},
+	)
+	assert.NoError(t, applyMigration(db, m))
+	assert.Equal(t, "COMMIT", f.Statements()[len(f.Statements())-1])
+
+	// An applied migration is skipped.
+	db, f = newFakeDB(t,
+		fakeResult{match: "pg_advisory_xact_lock"},
+		fakeResult{match: "FROM schema_migrations", columns: []string{"exists"}, rows: [][]driver.Value{{true}}},
+	)
+	assert.NoError(t, applyMigration(db, m))
+	assert.NotContains(t, f.Statements(), "COMMIT")
+}
+
 // Wrapper function for aes.NewCipher to allow mocking
 var newCipher = aes.NewCipher
 
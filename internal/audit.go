@@ -0,0 +1,378 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// auditLockID is the advisory lock key serialising appends to the hash chain
+const auditLockID = 7305722
+
+// AuditEventType identifies what happened to a secret
+type AuditEventType string
+
+const (
+	// AuditCreate records a secret being stored
+	AuditCreate AuditEventType = "create"
+	// AuditRetrieve records a secret being read and burned
+	AuditRetrieve AuditEventType = "retrieve"
+	// AuditFailedRetrieve records a lookup for a missing or already burned secret
+	AuditFailedRetrieve AuditEventType = "failed_retrieve"
+	// AuditRevoke records a secret being burned without being read
+	AuditRevoke AuditEventType = "revoke"
+	// AuditAuthFailure records a rejected token. It is exported to sinks only
+	// and is not part of the hash chain.
+	AuditAuthFailure AuditEventType = "auth_failure"
+)
+
+// auditLocal is the fiber.Ctx locals key giving HandleError access to the audit log
+const auditLocal = "audit"
+
+// AuditEvent is a single entry in the audit log. Only a hash of the secret key
+// is kept; neither the raw key nor the payload is ever recorded.
+type AuditEvent struct {
+	ID         int64          `json:"id"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	Type       AuditEventType `json:"event_type"`
+	Principal  string         `json:"principal"`
+	IP         string         `json:"ip"`
+	UserAgent  string         `json:"user_agent"`
+	KeyHash    string         `json:"key_hash"`
+	RequestID  string         `json:"request_id"`
+	Detail     string         `json:"detail"`
+	PrevHash   string         `json:"prev_hash"`
+	Hash       string         `json:"hash"`
+}
+
+// AuditQuery filters the events returned by AuditLog.Query
+type AuditQuery struct {
+	Type      AuditEventType
+	KeyHash   string
+	Principal string
+	Since     time.Time
+	Until     time.Time
+	AfterID   int64
+	Limit     int
+}
+
+// AuditVerification is the result of walking the hash chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Events   int64  `json:"events"`
+	Head     string `json:"head,omitempty"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+var auditWriteFailuresTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "audit_write_failures_total",
+	Help:      "Audit events that could not be written to the database.",
+})
+
+// AuditLog appends events to the audit_events table. Each row stores the hash
+// of the previous row, so editing or removing any row breaks the chain. Every
+// event is also exported to the configured sinks.
+type AuditLog struct {
+	db    *sql.DB
+	sinks []AuditSink
+}
+
+// NewAuditLog creates an audit log backed by db that exports to sinks
+func NewAuditLog(db *sql.DB, sinks ...AuditSink) *AuditLog {
+	return &AuditLog{db: db, sinks: sinks}
+}
+
+// HashKey returns the hex SHA-256 of a secret key, as stored in the audit log
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ComputeAuditHash returns the chain hash for e, covering every field except
+// the row ID and the hash itself.
+func ComputeAuditHash(e AuditEvent) string {
+	canonical, _ := json.Marshal(struct {
+		OccurredAt string         `json:"occurred_at"`
+		Type       AuditEventType `json:"event_type"`
+		Principal  string         `json:"principal"`
+		IP         string         `json:"ip"`
+		UserAgent  string         `json:"user_agent"`
+		KeyHash    string         `json:"key_hash"`
+		RequestID  string         `json:"request_id"`
+		Detail     string         `json:"detail"`
+		PrevHash   string         `json:"prev_hash"`
+	}{
+		OccurredAt: e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		Type:       e.Type,
+		Principal:  e.Principal,
+		IP:         e.IP,
+		UserAgent:  e.UserAgent,
+		KeyHash:    e.KeyHash,
+		RequestID:  e.RequestID,
+		Detail:     e.Detail,
+		PrevHash:   e.PrevHash,
+	})
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAuditEvent builds an event describing the current request.
+func NewAuditEvent(c *fiber.Ctx, eventType AuditEventType, key, detail string) AuditEvent {
+	e := AuditEvent{
+		Type:      eventType,
+		Principal: Principal(c),
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		RequestID: RequestID(c),
+		Detail:    detail,
+	}
+	if key != "" {
+		e.KeyHash = HashKey(key)
+	}
+	return e
+}
+
+// Record appends e to the chain. Failures are logged and counted rather than
+// returned, so that an audit outage does not take secret sharing down with it.
+func (a *AuditLog) Record(ctx context.Context, e AuditEvent) {
+	e, err := a.append(ctx, e)
+	if err != nil {
+		auditWriteFailuresTotal.Inc()
+		log.Error("Failed to write audit event", "error", err, "event_type", e.Type, "request_id", e.RequestID)
+	}
+	a.Emit(ctx, e)
+}
+
+// Emit sends e to the sinks without adding it to the hash chain.
+func (a *AuditLog) Emit(ctx context.Context, e AuditEvent) {
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now().UTC()
+	}
+	for _, s := range a.sinks {
+		if err := s.Write(ctx, e); err != nil {
+			auditSinkErrorsTotal.WithLabelValues(s.Name()).Inc()
+			log.Error("Failed to export audit event", "sink", s.Name(), "error", err, "request_id", e.RequestID)
+		}
+	}
+}
+
+// auditFromContext returns the audit log attached to the request, if any.
+func auditFromContext(c *fiber.Ctx) *AuditLog {
+	a, _ := c.Locals(auditLocal).(*AuditLog)
+	return a
+}
+
+func (a *AuditLog) append(ctx context.Context, e AuditEvent) (AuditEvent, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return e, fmt.Errorf("failed to start audit transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Error("Failed to rollback audit transaction", "error", err)
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditLockID); err != nil {
+		return e, fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&e.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return e, fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+
+	// Postgres stores microseconds; truncate so the hash survives a round trip.
+	e.OccurredAt = time.Now().UTC().Truncate(time.Microsecond)
+	e.Hash = ComputeAuditHash(e)
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO audit_events(occurred_at, event_type, principal, ip, user_agent, key_hash, request_id, detail, prev_hash, hash)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		e.OccurredAt, e.Type, e.Principal, e.IP, e.UserAgent, e.KeyHash, e.RequestID, e.Detail, e.PrevHash, e.Hash).Scan(&e.ID)
+	if err != nil {
+		return e, fmt.Errorf("failed to insert audit event: %w", err)
+	}
+	return e, tx.Commit()
+}
+
+const auditColumns = "id, occurred_at, event_type, principal, ip, user_agent, key_hash, request_id, detail, prev_hash, hash"
+
+func scanAuditEvent(rows *sql.Rows) (AuditEvent, error) {
+	var e AuditEvent
+	err := rows.Scan(&e.ID, &e.OccurredAt, &e.Type, &e.Principal, &e.IP, &e.UserAgent, &e.KeyHash, &e.RequestID, &e.Detail, &e.PrevHash, &e.Hash)
+	e.OccurredAt = e.OccurredAt.UTC()
+	return e, err
+}
+
+// Query returns events matching q in chain order.
+func (a *AuditLog) Query(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	var where []string
+	var args []interface{}
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+	if q.Type != "" {
+		add("event_type = $%d", q.Type)
+	}
+	if q.KeyHash != "" {
+		add("key_hash = $%d", q.KeyHash)
+	}
+	if q.Principal != "" {
+		add("principal = $%d", q.Principal)
+	}
+	if !q.Since.IsZero() {
+		add("occurred_at >= $%d", q.Since)
+	}
+	if !q.Until.IsZero() {
+		add("occurred_at < $%d", q.Until)
+	}
+	if q.AfterID > 0 {
+		add("id > $%d", q.AfterID)
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, q.Limit)
+	query += fmt.Sprintf(" ORDER BY id LIMIT $%d", len(args))
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Verify walks the whole chain and reports the first event whose hash or link
+// to its predecessor does not match.
+func (a *AuditLog) Verify(ctx context.Context) (AuditVerification, error) {
+	rows, err := a.db.QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_events ORDER BY id")
+	if err != nil {
+		return AuditVerification{}, fmt.Errorf("failed to read audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var v auditChainVerifier
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return AuditVerification{}, fmt.Errorf("failed to read audit event: %w", err)
+		}
+		if !v.add(e) {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return AuditVerification{}, err
+	}
+	return v.result(), nil
+}
+
+// auditChainVerifier checks events one at a time in chain order.
+type auditChainVerifier struct {
+	count    int64
+	head     string
+	brokenAt int64
+	reason   string
+}
+
+// add checks e against the chain so far and reports whether to continue.
+func (v *auditChainVerifier) add(e AuditEvent) bool {
+	switch {
+	case e.PrevHash != v.head:
+		v.brokenAt, v.reason = e.ID, "prev_hash does not match the preceding event"
+		return false
+	case ComputeAuditHash(e) != e.Hash:
+		v.brokenAt, v.reason = e.ID, "hash does not match event contents"
+		return false
+	}
+	v.count++
+	v.head = e.Hash
+	return true
+}
+
+func (v *auditChainVerifier) result() AuditVerification {
+	return AuditVerification{
+		Valid:    v.brokenAt == 0,
+		Events:   v.count,
+		Head:     v.head,
+		BrokenAt: v.brokenAt,
+		Reason:   v.reason,
+	}
+}
+
+// registerAuditRoutes adds the admin endpoints for querying and verifying the
+// audit log. Callers must hold the configured admin scope.
+func registerAuditRoutes(app *fiber.App, audit *AuditLog, auth fiber.Handler, adminScope string) {
+	admin := app.Group("/admin", auth, requireScope(adminScope))
+
+	admin.Get("/audit", func(c *fiber.Ctx) error {
+		q := AuditQuery{
+			Type:      AuditEventType(c.Query("event_type")),
+			KeyHash:   c.Query("key_hash"),
+			Principal: c.Query("principal"),
+			Limit:     c.QueryInt("limit", 100),
+		}
+		if q.Limit <= 0 || q.Limit > 1000 {
+			return HandleValidationError(c, fmt.Sprintf("Invalid audit limit %d", q.Limit), nil)
+		}
+		if afterID := c.Query("after_id"); afterID != "" {
+			id, err := strconv.ParseInt(afterID, 10, 64)
+			if err != nil {
+				return HandleValidationError(c, "Invalid after_id", err)
+			}
+			q.AfterID = id
+		}
+		for param, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			if v := c.Query(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					return HandleValidationError(c, "Invalid "+param+" timestamp", err)
+				}
+				*dst = t
+			}
+		}
+
+		events, err := audit.Query(c.UserContext(), q)
+		if err != nil {
+			return HandleDatabaseError(c, "Failed to query audit events", err)
+		}
+		return c.JSON(fiber.Map{"events": events})
+	})
+
+	admin.Get("/audit/verify", func(c *fiber.Ctx) error {
+		result, err := audit.Verify(c.UserContext())
+		if err != nil {
+			return HandleDatabaseError(c, "Failed to verify audit chain", err)
+		}
+		if !result.Valid {
+			log.Error("Audit chain verification failed", "broken_at", result.BrokenAt, "reason", result.Reason, "request_id", RequestID(c))
+		}
+		return c.JSON(result)
+	})
+}
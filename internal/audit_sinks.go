@@ -0,0 +1,561 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AuditSink receives a copy of every audit event for export to an external
+// system such as a SIEM. Write must not block for long; sinks that talk to
+// slow destinations queue internally.
+type AuditSink interface {
+	Name() string
+	Write(ctx context.Context, e AuditEvent) error
+	Close() error
+}
+
+var (
+	auditSinkErrorsTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_sink_errors_total",
+		Help:      "Audit events a sink failed to deliver, by sink.",
+	}, []string{"sink"})
+
+	auditSinkDroppedTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_sink_dropped_total",
+		Help:      "Audit events dropped because a sink's queue was full, by sink.",
+	}, []string{"sink"})
+)
+
+// NewAuditSinks creates every sink enabled in cfg.
+func NewAuditSinks(cfg AuditConfig) ([]AuditSink, error) {
+	var sinks []AuditSink
+	if cfg.SyslogAddress != "" {
+		s, err := NewSyslogSink(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.FilePath != "" {
+		s, err := NewFileSink(cfg.FilePath, cfg.FileMaxSizeMB*1024*1024, cfg.FileMaxBackups)
+		if err != nil {
+			CloseAuditSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, NewWebhookSink(cfg))
+	}
+	return sinks, nil
+}
+
+// CloseAuditSinks flushes and closes every sink, logging any failures.
+func CloseAuditSinks(sinks []AuditSink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			log.Error("Failed to close audit sink", "sink", s.Name(), "error", err)
+		}
+	}
+}
+
+// auditSeverity maps an event to its syslog severity.
+func auditSeverity(t AuditEventType) int {
+	switch t {
+	case AuditFailedRetrieve, AuditAuthFailure:
+		return 4 // warning
+	default:
+		return 6 // informational
+	}
+}
+
+// syslogTimeout bounds each dial and write to the syslog server.
+const syslogTimeout = 5 * time.Second
+
+// SyslogSink sends events as RFC 5424 messages over UDP, TCP or TLS. Stream
+// transports use octet-counting framing (RFC 6587). Like WebhookSink, events
+// are queued and sent by a goroutine; when the queue is full new events are
+// dropped and counted rather than blocking request handlers.
+type SyslogSink struct {
+	network  string
+	address  string
+	facility int
+	tlsCfg   *tls.Config
+	hostname string
+
+	// conn is only used by the delivery goroutine.
+	conn net.Conn
+
+	queue chan []byte
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewSyslogSink creates a syslog sink from the audit configuration and
+// starts its delivery goroutine
+func NewSyslogSink(cfg AuditConfig) (*SyslogSink, error) {
+	s := &SyslogSink{
+		network:  cfg.SyslogNetwork,
+		address:  cfg.SyslogAddress,
+		facility: cfg.SyslogFacility,
+		queue:    make(chan []byte, max(cfg.SyslogQueueSize, 1)),
+		done:     make(chan struct{}),
+	}
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+
+	switch s.network {
+	case "udp", "tcp":
+	case "tls":
+		s.tlsCfg = &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.SyslogCACert != "" {
+			pem, err := os.ReadFile(cfg.SyslogCACert)
+			if err != nil {
+				return nil, fmt.Errorf("error reading syslog CA certificate: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.SyslogCACert)
+			}
+			s.tlsCfg.RootCAs = pool
+		}
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q: use udp, tcp or tls", s.network)
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// Name identifies the sink in logs and metrics
+func (s *SyslogSink) Name() string { return "syslog" }
+
+// Write queues e for delivery without blocking.
+func (s *SyslogSink) Write(ctx context.Context, e AuditEvent) error {
+	msg, err := s.format(e)
+	if err != nil {
+		return err
+	}
+	if s.network != "udp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	select {
+	case s.queue <- msg:
+		return nil
+	default:
+		auditSinkDroppedTotal.WithLabelValues(s.Name()).Inc()
+		return errors.New("audit syslog queue full")
+	}
+}
+
+func (s *SyslogSink) run() {
+	defer s.wg.Done()
+	for {
+		select {
+		case msg := <-s.queue:
+			s.deliver(msg)
+		case <-s.done:
+			// Drain whatever is still queued before exiting.
+			for {
+				select {
+				case msg := <-s.queue:
+					s.deliver(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver sends a message, logging and counting failures.
+func (s *SyslogSink) deliver(msg []byte) {
+	if err := s.send(msg); err != nil {
+		auditSinkErrorsTotal.WithLabelValues(s.Name()).Inc()
+		log.Error("Failed to deliver audit event to syslog", "error", err)
+	}
+}
+
+// send writes msg, reconnecting once if the connection has gone away.
+func (s *SyslogSink) send(msg []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err = s.dial(); err != nil {
+				continue
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return fmt.Errorf("error writing to syslog %s: %w", s.address, err)
+}
+
+func (s *SyslogSink) dial() error {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	var err error
+	if s.tlsCfg != nil {
+		td := &tls.Dialer{NetDialer: dialer, Config: s.tlsCfg}
+		s.conn, err = td.DialContext(context.Background(), "tcp", s.address)
+	} else {
+		s.conn, err = dialer.Dial(s.network, s.address)
+	}
+	return err
+}
+
+// format renders e as an RFC 5424 message with the event in structured data
+// and as a JSON body.
+func (s *SyslogSink) format(e AuditEvent) ([]byte, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding audit event: %w", err)
+	}
+	pri := s.facility*8 + auditSeverity(e.Type)
+	sd := fmt.Sprintf(`[disapyr@32473 event_type="%s" principal="%s" ip="%s" key_hash="%s" request_id="%s"]`,
+		sdEscape(string(e.Type)), sdEscape(e.Principal), sdEscape(e.IP), sdEscape(e.KeyHash), sdEscape(e.RequestID))
+	return []byte(fmt.Sprintf("<%d>1 %s %s disapyr %d %s %s %s",
+		pri, e.OccurredAt.UTC().Format(time.RFC3339Nano), s.hostname, os.Getpid(), e.Type, sd, body)), nil
+}
+
+// sdEscape escapes a structured data parameter value per RFC 5424 section 6.3.3.
+func sdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+// Close stops accepting events, sends what is still queued and closes the
+// connection to the syslog server.
+func (s *SyslogSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// FileSink appends events as JSON lines, rotating the file when it reaches
+// maxBytes and keeping up to maxBackups old files (path.1 is the newest).
+type FileSink struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens or creates the audit file at path
+func NewFileSink(path string, maxBytes int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("error creating audit file directory: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening audit file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error reading audit file size: %w", err)
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+// Name identifies the sink in logs and metrics
+func (s *FileSink) Name() string { return "file" }
+
+// Write appends e as a single JSON line.
+func (s *FileSink) Write(ctx context.Context, e AuditEvent) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding audit event: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.size+int64(len(line)) > s.maxBytes && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing audit file: %w", err)
+	}
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, moves the current file to path.1 and
+// starts a new one. The oldest backup beyond maxBackups is removed.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("error closing audit file: %w", err)
+	}
+	if s.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("error rotating audit file: %w", err)
+		}
+	} else if err := os.Truncate(s.path, 0); err != nil {
+		return fmt.Errorf("error truncating audit file: %w", err)
+	}
+	return s.open()
+}
+
+// Close closes the audit file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// WebhookSink posts batches of events as a JSON array to an HTTPS collector.
+// Events are queued in memory; when the queue is full new events are dropped
+// and counted rather than blocking request handlers.
+type WebhookSink struct {
+	url           string
+	token         string
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	client        *http.Client
+	closeTimeout  time.Duration
+
+	queue chan AuditEvent
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	// ctx is cancelled when Close gives up on delivering what is queued.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// webhookCloseTimeout bounds how long Close keeps delivering queued events,
+// so that a dead collector cannot hold up shutdown.
+const webhookCloseTimeout = 10 * time.Second
+
+// NewWebhookSink creates a webhook sink and starts its delivery goroutine
+func NewWebhookSink(cfg AuditConfig) *WebhookSink {
+	s := &WebhookSink{
+		url:           cfg.WebhookURL,
+		token:         cfg.WebhookToken,
+		batchSize:     cfg.WebhookBatchSize,
+		flushInterval: cfg.WebhookFlushInterval,
+		maxRetries:    cfg.WebhookMaxRetries,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		closeTimeout:  webhookCloseTimeout,
+		queue:         make(chan AuditEvent, cfg.WebhookQueueSize),
+		done:          make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Name identifies the sink in logs and metrics
+func (s *WebhookSink) Name() string { return "webhook" }
+
+// Write queues e for delivery without blocking.
+func (s *WebhookSink) Write(ctx context.Context, e AuditEvent) error {
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		auditSinkDroppedTotal.WithLabelValues(s.Name()).Inc()
+		return errors.New("audit webhook queue full")
+	}
+}
+
+func (s *WebhookSink) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, s.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if s.ctx.Err() != nil {
+			auditSinkDroppedTotal.WithLabelValues(s.Name()).Add(float64(len(batch)))
+			batch = batch[:0]
+			return
+		}
+		if err := s.send(batch); err != nil {
+			auditSinkErrorsTotal.WithLabelValues(s.Name()).Add(float64(len(batch)))
+			log.Error("Failed to deliver audit events to webhook", "events", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.done:
+			// Drain whatever is still queued before exiting.
+			for {
+				select {
+				case e := <-s.queue:
+					batch = append(batch, e)
+					if len(batch) >= s.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// webhookMaxRetryWait caps the delay between webhook attempts, so a large
+// Retry-After cannot park the delivery goroutine for hours.
+const webhookMaxRetryWait = time.Minute
+
+// send posts a batch, retrying network errors, 429 and 5xx responses with
+// exponential backoff. A Retry-After header is honoured up to
+// webhookMaxRetryWait.
+func (s *WebhookSink) send(batch []AuditEvent) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("error encoding audit batch: %w", err)
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		wait, err := s.post(body)
+		if err == nil {
+			return nil
+		}
+		if wait < 0 || attempt >= s.maxRetries {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		timer := time.NewTimer(min(wait, webhookMaxRetryWait))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return errors.Join(s.ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// post makes a single delivery attempt. The returned duration is negative if
+// the failure is permanent, or the server-requested delay if it sent one.
+func (s *WebhookSink) post(body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	default:
+		return -1, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date, returning zero if it is absent or unparseable.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := time.ParseDuration(v + "s"); err == nil && secs >= 0 {
+		return secs
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Close stops accepting events and delivers what is still queued. Events
+// not delivered within the close timeout are dropped.
+func (s *WebhookSink) Close() error {
+	close(s.done)
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(s.closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		s.cancel()
+		return nil
+	case <-timer.C:
+	}
+	s.cancel()
+	<-stopped
+	return errors.New("audit webhook did not accept queued events before the close timeout")
+}
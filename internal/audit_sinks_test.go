@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testAuditEvent = AuditEvent{
+	ID:         7,
+	OccurredAt: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	Type:       AuditFailedRetrieve,
+	IP:         "10.0.0.1",
+	KeyHash:    HashKey("k"),
+	RequestID:  `req"]1`,
+	Detail:     "not_found",
+}
+
+func TestSyslogSink(t *testing.T) {
+	t.Run("udp message is RFC 5424", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sink, err := NewSyslogSink(AuditConfig{SyslogAddress: conn.LocalAddr().String(), SyslogNetwork: "udp", SyslogFacility: 10})
+		assert.NoError(t, err)
+		defer sink.Close()
+		assert.NoError(t, sink.Write(context.Background(), testAuditEvent))
+
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		assert.NoError(t, err)
+		msg := string(buf[:n])
+
+		// authpriv (10) * 8 + warning (4)
+		assert.True(t, strings.HasPrefix(msg, "<84>1 2025-01-01T12:00:00Z "), msg)
+		assert.Contains(t, msg, " disapyr ")
+		assert.Contains(t, msg, ` failed_retrieve [disapyr@32473 event_type="failed_retrieve"`)
+		assert.Contains(t, msg, `request_id="req\"\]1"`)
+	})
+
+	t.Run("tcp uses octet counting", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer ln.Close()
+
+		received := make(chan string, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			length, _ := r.ReadString(' ')
+			rest := make([]byte, 4096)
+			n, _ := r.Read(rest)
+			received <- length + string(rest[:n])
+		}()
+
+		sink, err := NewSyslogSink(AuditConfig{SyslogAddress: ln.Addr().String(), SyslogNetwork: "tcp", SyslogFacility: 10})
+		assert.NoError(t, err)
+		defer sink.Close()
+		assert.NoError(t, sink.Write(context.Background(), testAuditEvent))
+
+		frame := <-received
+		length, msg, _ := strings.Cut(frame, " ")
+		assert.Equal(t, length, strings.TrimSpace(length))
+		assert.Equal(t, len(msg), mustAtoi(t, length))
+	})
+
+	t.Run("full queue drops events", func(t *testing.T) {
+		blocked := &SyslogSink{network: "tcp", queue: make(chan []byte, 1)}
+		assert.NoError(t, blocked.Write(context.Background(), testAuditEvent))
+		assert.Error(t, blocked.Write(context.Background(), testAuditEvent))
+	})
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "events.jsonl")
+	line, _ := json.Marshal(testAuditEvent)
+
+	// Room for two events per file.
+	sink, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	assert.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, sink.Write(context.Background(), testAuditEvent))
+	}
+	assert.NoError(t, sink.Close())
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(p)
+		assert.NoError(t, err, p)
+		assert.NotEmpty(t, data)
+		info, _ := os.Stat(p)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "only two backups are kept")
+
+	data, _ := os.ReadFile(path)
+	var e AuditEvent
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(string(data))), &e))
+	assert.Equal(t, testAuditEvent.KeyHash, e.KeyHash)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]AuditEvent
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer collector-token", r.Header.Get("Authorization"))
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []AuditEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(AuditConfig{
+		WebhookURL:           server.URL,
+		WebhookToken:         "collector-token",
+		WebhookBatchSize:     3,
+		WebhookFlushInterval: time.Hour,
+		WebhookQueueSize:     10,
+		WebhookMaxRetries:    3,
+	})
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, sink.Write(context.Background(), testAuditEvent))
+	}
+	assert.NoError(t, sink.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, batches, 2, "a full batch and the remainder flushed on close")
+	assert.Len(t, batches[0], 3)
+	assert.Len(t, batches[1], 2)
+	assert.Equal(t, int32(3), attempts.Load(), "the first 503 is retried")
+
+	t.Run("close gives up on a dead collector", func(t *testing.T) {
+		dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer dead.Close()
+
+		sink := NewWebhookSink(AuditConfig{
+			WebhookURL:           dead.URL,
+			WebhookBatchSize:     1,
+			WebhookFlushInterval: time.Hour,
+			WebhookQueueSize:     10,
+			WebhookMaxRetries:    5,
+		})
+		sink.closeTimeout = 50 * time.Millisecond
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, sink.Write(context.Background(), testAuditEvent))
+		}
+		start := time.Now()
+		assert.Error(t, sink.Close())
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("full queue drops events", func(t *testing.T) {
+		blocked := &WebhookSink{queue: make(chan AuditEvent, 1)}
+		assert.NoError(t, blocked.Write(context.Background(), testAuditEvent))
+		assert.Error(t, blocked.Write(context.Background(), testAuditEvent))
+	})
+}
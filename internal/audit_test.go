@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildAuditChain links events the same way AuditLog.append does.
+func buildAuditChain(events ...AuditEvent) []AuditEvent {
+	prev := ""
+	for i := range events {
+		events[i].ID = int64(i + 1)
+		events[i].OccurredAt = time.Date(2025, 1, 1, 0, 0, i, 0, time.UTC)
+		events[i].PrevHash = prev
+		events[i].Hash = ComputeAuditHash(events[i])
+		prev = events[i].Hash
+	}
+	return events
+}
+
+func verifyEvents(events []AuditEvent) AuditVerification {
+	var v auditChainVerifier
+	for _, e := range events {
+		if !v.add(e) {
+			break
+		}
+	}
+	return v.result()
+}
+
+func TestAuditChain(t *testing.T) {
+	newChain := func() []AuditEvent {
+		return buildAuditChain(
+			AuditEvent{Type: AuditCreate, Principal: "client@clients", KeyHash: HashKey("k1")},
+			AuditEvent{Type: AuditFailedRetrieve, IP: "10.0.0.1", KeyHash: HashKey("k2"), Detail: "not_found"},
+			AuditEvent{Type: AuditRetrieve, Principal: "client@clients", KeyHash: HashKey("k1")},
+		)
+	}
+
+	t.Run("intact chain verifies", func(t *testing.T) {
+		chain := newChain()
+		result := verifyEvents(chain)
+		assert.True(t, result.Valid)
+		assert.Equal(t, int64(3), result.Events)
+		assert.Equal(t, chain[2].Hash, result.Head)
+	})
+
+	t.Run("edited event is detected", func(t *testing.T) {
+		chain := newChain()
+		chain[1].Principal = "someone-else"
+		result := verifyEvents(chain)
+		assert.False(t, result.Valid)
+		assert.Equal(t, int64(2), result.BrokenAt)
+	})
+
+	t.Run("removed event is detected", func(t *testing.T) {
+		chain := newChain()
+		chain = append(chain[:1], chain[2:]...)
+		result := verifyEvents(chain)
+		assert.False(t, result.Valid)
+		assert.Equal(t, int64(3), result.BrokenAt)
+	})
+
+	t.Run("raw key is never stored", func(t *testing.T) {
+		app := fiber.New()
+		var event AuditEvent
+		app.Get("/secret/:key", func(c *fiber.Ctx) error {
+			event = NewAuditEvent(c, AuditRetrieve, c.Params("key"), "")
+			return nil
+		})
+		_, err := app.Test(httptest.NewRequest("GET", "/secret/rawkey123", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, HashKey("rawkey123"), event.KeyHash)
+		assert.NotContains(t, event.KeyHash, "rawkey123")
+	})
+}
+
+func TestRequireScope(t *testing.T) {
+	app := fiber.New()
+	withClaims := func(claims jwt.MapClaims) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			c.Locals(claimsLocal, claims)
+			return c.Next()
+		}
+	}
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+	app.Get("/scope", withClaims(jwt.MapClaims{"scope": "openid read:audit"}), requireScope("read:audit"), ok)
+	app.Get("/permissions", withClaims(jwt.MapClaims{"permissions": []interface{}{"read:audit"}}), requireScope("read:audit"), ok)
+	app.Get("/none", withClaims(jwt.MapClaims{"scope": "openid"}), requireScope("read:audit"), ok)
+
+	for path, status := range map[string]int{"/scope": fiber.StatusOK, "/permissions": fiber.StatusOK, "/none": fiber.StatusForbidden} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		assert.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode, path)
+	}
+}
@@ -0,0 +1,422 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Blob store backends
+const (
+	BlobBackendNone  = "none"
+	BlobBackendLocal = "local"
+	BlobBackendS3    = "s3"
+)
+
+// blobSweepBatchSize bounds how many blobs are checked against the database
+// in a single query while sweeping.
+const blobSweepBatchSize = 500
+
+// Reasons a blob is deleted
+const (
+	blobDeletedConsumed = "consumed"
+	blobDeletedOrphaned = "orphaned"
+)
+
+// ErrBlobNotFound is returned when a blob does not exist
+var ErrBlobNotFound = errors.New("blob not found")
+
+var blobsDeletedTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "blobs_deleted_total",
+	Help:      "Payload blobs deleted, by reason.",
+}, []string{"reason"})
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Name    string
+	ModTime time.Time
+}
+
+// BlobStore holds encrypted file payloads that are too large to keep in the
+// secrets table. Blob names are generated by NewBlobName.
+type BlobStore interface {
+	// Put stores size bytes read from r under name.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	// Open returns the contents of a blob, or ErrBlobNotFound.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, name string) error
+	// List calls fn for every blob in the store.
+	List(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// NewBlobName returns a random name for a new blob. Names are unrelated to
+// secret keys, so the store never learns them.
+func NewBlobName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate blob name: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validBlobName reports whether name could have come from NewBlobName.
+func validBlobName(name string) bool {
+	if len(name) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// NewBlobStore returns the store selected by cfg.Backend, or nil if large
+// payloads are kept in the database.
+func NewBlobStore(cfg BlobConfig) (BlobStore, error) {
+	switch cfg.Backend {
+	case "", BlobBackendNone:
+		return nil, nil
+	case BlobBackendLocal:
+		return NewLocalBlobStore(cfg.Dir)
+	case BlobBackendS3:
+		return NewS3BlobStore(cfg)
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.Backend)
+	}
+}
+
+// LocalBlobStore keeps blobs as files in a single directory.
+type LocalBlobStore struct {
+	dir string
+}
+
+// NewLocalBlobStore creates dir if needed and returns a store backed by it.
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &LocalBlobStore{dir: dir}, nil
+}
+
+func (s *LocalBlobStore) path(name string) (string, error) {
+	if !validBlobName(name) {
+		return "", fmt.Errorf("invalid blob name %q", name)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// Put writes the blob to a temporary file and renames it into place, so a
+// partially written blob is never visible under its name.
+func (s *LocalBlobStore) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("wrote %d bytes, expected %d", n, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	return nil
+}
+
+// Open returns the blob's file.
+func (s *LocalBlobStore) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return f, nil
+}
+
+// Delete removes the blob's file.
+func (s *LocalBlobStore) Delete(ctx context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+// List reports every blob file in the directory, ignoring anything else.
+func (s *LocalBlobStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !validBlobName(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to list blobs: %w", err)
+		}
+		if err := fn(BlobInfo{Name: e.Name(), ModTime: info.ModTime()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Blobs moves large payloads to a BlobStore and makes sure they are deleted
+// once their secret is consumed. A nil *Blobs keeps every payload in the
+// database.
+type Blobs struct {
+	store     BlobStore
+	db        *sql.DB
+	threshold int64
+	timeout   time.Duration
+	interval  time.Duration
+	orphanAge time.Duration
+}
+
+// NewBlobs returns nil if cfg selects no blob store.
+func NewBlobs(db *sql.DB, cfg BlobConfig) (*Blobs, error) {
+	store, err := NewBlobStore(cfg)
+	if err != nil || store == nil {
+		return nil, err
+	}
+	return &Blobs{
+		store:     store,
+		db:        db,
+		threshold: cfg.Threshold,
+		timeout:   cfg.Timeout,
+		interval:  cfg.SweepInterval,
+		orphanAge: cfg.OrphanAge,
+	}, nil
+}
+
+// offload streams an encrypted upload into the blob store if the file is
+// above the threshold, returning the blob name or "" if the payload should
+// stay in the database.
+func (b *Blobs) offload(ctx context.Context, f *fileUpload) (string, error) {
+	if b == nil || f.Size <= b.threshold {
+		return "", nil
+	}
+	name, err := NewBlobName()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	if err := b.store.Put(ctx, name, f.Reader(), f.ciphertextSize); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// discard deletes a blob whose secret was never stored. If that fails the
+// sweeper removes it once it is older than the orphan age.
+func (b *Blobs) discard(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	if err := b.store.Delete(ctx, name); err != nil {
+		log.Error("Failed to delete unused blob", "error", err)
+	}
+}
+
+// open returns the payload of a consumed secret. Closing it deletes the blob
+// and clears its pending deletion; if that fails, the sweeper retries later.
+func (b *Blobs) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if b == nil {
+		return nil, errors.New("secret payload is in a blob store but none is configured")
+	}
+	rc, err := b.store.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &consumedBlob{ReadCloser: rc, blobs: b, name: name}, nil
+}
+
+// consumedBlob deletes its blob when closed.
+type consumedBlob struct {
+	io.ReadCloser
+	blobs *Blobs
+	name  string
+}
+
+func (c *consumedBlob) Close() error {
+	err := c.ReadCloser.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), c.blobs.timeout)
+	defer cancel()
+	if derr := c.blobs.release(ctx, c.name); derr != nil {
+		log.Error("Failed to delete consumed blob; it will be swept later", "error", derr)
+	}
+	return err
+}
+
+// release deletes a consumed blob and its pending deletion record.
+func (b *Blobs) release(ctx context.Context, name string) error {
+	if err := b.store.Delete(ctx, name); err != nil {
+		return err
+	}
+	if _, err := b.db.ExecContext(ctx, "DELETE FROM blob_deletions WHERE blob_key = $1", name); err != nil {
+		return fmt.Errorf("failed to clear blob deletion: %w", err)
+	}
+	blobsDeletedTotal.WithLabelValues(blobDeletedConsumed).Inc()
+	return nil
+}
+
+// queueBlobDeletion records that a consumed secret's blob must be deleted, in
+// the same transaction that burns the secret, so the deletion survives a crash
+// before the blob is streamed.
+func queueBlobDeletion(ctx context.Context, tx *sql.Tx, name string) error {
+	if _, err := tx.ExecContext(ctx, "INSERT INTO blob_deletions(blob_key) VALUES($1) ON CONFLICT DO NOTHING", name); err != nil {
+		return fmt.Errorf("failed to queue blob deletion: %w", err)
+	}
+	return nil
+}
+
+// RunSweeper deletes leftover blobs every sweep interval until ctx is
+// cancelled.
+func (b *Blobs) RunSweeper(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		if err := b.sweep(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Failed to sweep blobs", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep deletes consumed blobs that were not deleted after streaming, and
+// blobs older than the orphan age that no secret refers to, such as those left
+// by an upload whose secret was never stored. Younger blobs are left alone as
+// they may still be in use.
+func (b *Blobs) sweep(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "blob.sweep")
+	defer func() { endSpan(span, err) }()
+
+	cutoff := time.Now().Add(-b.orphanAge)
+
+	rows, err := b.db.QueryContext(ctx, "SELECT blob_key FROM blob_deletions WHERE queued_at < $1", cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to query blob deletions: %w", err)
+	}
+	var pending []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan blob deletion: %w", err)
+		}
+		pending = append(pending, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query blob deletions: %w", err)
+	}
+	for _, name := range pending {
+		if err := b.release(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	var candidates []string
+	err = b.store.List(ctx, func(info BlobInfo) error {
+		if info.ModTime.Before(cutoff) {
+			candidates = append(candidates, info.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for len(candidates) > 0 {
+		batch := candidates[:min(len(candidates), blobSweepBatchSize)]
+		candidates = candidates[len(batch):]
+		orphans, err := b.unreferenced(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, name := range orphans {
+			if err := b.store.Delete(ctx, name); err != nil {
+				return err
+			}
+			blobsDeletedTotal.WithLabelValues(blobDeletedOrphaned).Inc()
+		}
+		if len(orphans) > 0 {
+			log.Info("Swept orphaned blobs", "count", len(orphans))
+		}
+	}
+	return nil
+}
+
+// unreferenced returns the names that neither a secret nor a pending deletion
+// refers to.
+func (b *Blobs) unreferenced(ctx context.Context, names []string) ([]string, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT blob_key FROM secrets WHERE blob_key = ANY($1)
+		UNION SELECT blob_key FROM blob_deletions WHERE blob_key = ANY($1)`, pq.Array(names))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blob references: %w", err)
+	}
+	defer rows.Close()
+	referenced := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan blob reference: %w", err)
+		}
+		referenced[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query blob references: %w", err)
+	}
+	var orphans []string
+	for _, name := range names {
+		if !referenced[name] {
+			orphans = append(orphans, name)
+		}
+	}
+	return orphans, nil
+}
@@ -0,0 +1,658 @@
+package internal
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Component identifies which binary is loading the configuration so that only
+// the settings it actually uses are validated.
+type Component int
+
+const (
+	// APIComponent is the API server started from main.go
+	APIComponent Component = iota
+	// UIComponent is the web UI server started from cmd/ui
+	UIComponent
+)
+
+// ConfigEnvVar names the environment variable that may point at a config file
+const ConfigEnvVar = "DISAPYR_CONFIG"
+
+// redacted replaces secret values when the configuration is printed
+const redacted = "REDACTED"
+
+// ErrConfigPrinted is returned by LoadConfig when --print-config was requested.
+// The caller should exit successfully without starting the server.
+var ErrConfigPrinted = errors.New("configuration printed")
+
+// Config holds every setting used by the API and UI servers.
+//
+// Values are resolved in order of increasing precedence: built-in defaults,
+// the YAML or TOML config file, environment variables (including an optional
+// .env file) and finally command-line flags. Each leaf field carries an env tag
+// naming its environment variable; its flag name is the dotted yaml path, for
+// example --database.host. Fields tagged secret:"true" are redacted when printed.
+type Config struct {
+	Server   ServerConfig   `yaml:"server" toml:"server"`
+	Security SecurityConfig `yaml:"security" toml:"security"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Auth     AuthConfig     `yaml:"auth" toml:"auth"`
+	UI       UIConfig       `yaml:"ui" toml:"ui"`
+	Log      LogConfig      `yaml:"log" toml:"log"`
+	Metrics  MetricsConfig  `yaml:"metrics" toml:"metrics"`
+	Tracing  TracingConfig  `yaml:"tracing" toml:"tracing"`
+	Audit    AuditConfig    `yaml:"audit" toml:"audit"`
+	Notify   NotifyConfig   `yaml:"notify" toml:"notify"`
+	Mail     MailConfig     `yaml:"mail" toml:"mail"`
+	Limits   LimitsConfig   `yaml:"limits" toml:"limits"`
+	Blob     BlobConfig     `yaml:"blob" toml:"blob"`
+}
+
+// ServerConfig configures the API listener
+type ServerConfig struct {
+	Port            int           `yaml:"port" toml:"port" env:"PORT" desc:"API server listen port"`
+	HTTPSEnabled    bool          `yaml:"https_enabled" toml:"https_enabled" env:"HTTPS_ENABLED" desc:"serve the API over TLS"`
+	CertPath        string        `yaml:"cert_path" toml:"cert_path" env:"CERT_PATH" desc:"TLS certificate path"`
+	KeyPath         string        `yaml:"key_path" toml:"key_path" env:"KEY_PATH" desc:"TLS private key path"`
+	RateLimit       int           `yaml:"rate_limit" toml:"rate_limit" env:"RATE_LIMIT" desc:"requests per second allowed by the rate limiter"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" desc:"time allowed for in-flight requests to finish on shutdown"`
+}
+
+// SecurityConfig configures key generation for stored secrets
+type SecurityConfig struct {
+	EncKey string `yaml:"enc_key" toml:"enc_key" env:"ENC_KEY" secret:"true" desc:"AES key used to derive secret keys (16, 24 or 32 bytes)"`
+	KeyLen int    `yaml:"key_len" toml:"key_len" env:"KEY_LEN" desc:"length of generated secret keys"`
+}
+
+// DatabaseConfig configures the PostgreSQL connection
+type DatabaseConfig struct {
+	User     string `yaml:"user" toml:"user" env:"DB_USER" desc:"database user"`
+	Password string `yaml:"password" toml:"password" env:"DB_PASSWORD" secret:"true" desc:"database password"`
+	Host     string `yaml:"host" toml:"host" env:"DB_HOST" desc:"database host"`
+	Port     int    `yaml:"port" toml:"port" env:"DB_PORT" desc:"database port"`
+	Name     string `yaml:"name" toml:"name" env:"DB_NAME" desc:"database name"`
+	UseSSL   bool   `yaml:"use_ssl" toml:"use_ssl" env:"DB_USESSL" desc:"require SSL for the database connection"`
+}
+
+// AuthConfig configures the Auth0 tenant used to issue and validate tokens
+type AuthConfig struct {
+	Domain       string        `yaml:"domain" toml:"domain" env:"URL" desc:"Auth0 domain"`
+	Audience     string        `yaml:"audience" toml:"audience" env:"AUDIENCE" desc:"expected token audience"`
+	ClientID     string        `yaml:"client_id" toml:"client_id" env:"CLIENT_ID" desc:"client ID used by the UI to obtain tokens"`
+	ClientSecret string        `yaml:"client_secret" toml:"client_secret" env:"CLIENT_SECRET" secret:"true" desc:"client secret used by the UI to obtain tokens"`
+	GrantType    string        `yaml:"grant_type" toml:"grant_type" env:"GRANT_TYPE" desc:"OAuth grant type used by the UI"`
+	JWKSCacheTTL time.Duration `yaml:"jwks_cache_ttl" toml:"jwks_cache_ttl" env:"JWKS_CACHE_TTL" desc:"how long fetched signing keys are cached"`
+	AdminScope   string        `yaml:"admin_scope" toml:"admin_scope" env:"ADMIN_SCOPE" desc:"token scope or permission required for admin endpoints"`
+}
+
+// UIConfig configures the web UI server
+type UIConfig struct {
+	Port            int           `yaml:"port" toml:"port" env:"UI_HOST_PORT" desc:"UI server listen port"`
+	APIBaseURL      string        `yaml:"api_base_url" toml:"api_base_url" env:"BASE_URL" desc:"base URL of the API server"`
+	CustomCACert    string        `yaml:"custom_ca_cert" toml:"custom_ca_cert" env:"CUSTOM_CA_CERT" desc:"CA certificate used to verify the API server"`
+	Environment     string        `yaml:"environment" toml:"environment" env:"GO_ENV" desc:"deployment environment (production verifies the API against the system CAs only, ignoring custom_ca_cert)"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"UI_SHUTDOWN_TIMEOUT" desc:"time allowed for in-flight requests to finish on shutdown"`
+}
+
+// LogConfig configures the charmbracelet logger
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL" desc:"log level (debug, info, warn, error)"`
+}
+
+// MetricsConfig configures the Prometheus endpoint
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled" env:"METRICS_ENABLED" desc:"expose Prometheus metrics"`
+	Path    string `yaml:"path" toml:"path" env:"METRICS_PATH" desc:"path the metrics are served on"`
+	Token   string `yaml:"token" toml:"token" env:"METRICS_TOKEN" secret:"true" desc:"bearer token required to scrape metrics"`
+}
+
+// TracingConfig configures OpenTelemetry trace export
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled" toml:"enabled" env:"TRACING_ENABLED" desc:"export OpenTelemetry traces"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT" desc:"OTLP/HTTP traces endpoint URL"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" desc:"fraction of new traces sampled (0 to 1)"`
+}
+
+// AuditConfig configures export of audit events to external sinks. Each sink
+// is enabled by setting its address, path or URL.
+type AuditConfig struct {
+	SyslogAddress        string        `yaml:"syslog_address" toml:"syslog_address" env:"AUDIT_SYSLOG_ADDRESS" desc:"syslog server host:port"`
+	SyslogNetwork        string        `yaml:"syslog_network" toml:"syslog_network" env:"AUDIT_SYSLOG_NETWORK" desc:"syslog transport (udp, tcp or tls)"`
+	SyslogFacility       int           `yaml:"syslog_facility" toml:"syslog_facility" env:"AUDIT_SYSLOG_FACILITY" desc:"syslog facility code"`
+	SyslogCACert         string        `yaml:"syslog_ca_cert" toml:"syslog_ca_cert" env:"AUDIT_SYSLOG_CA_CERT" desc:"CA certificate used to verify a TLS syslog server"`
+	SyslogQueueSize      int           `yaml:"syslog_queue_size" toml:"syslog_queue_size" env:"AUDIT_SYSLOG_QUEUE_SIZE" desc:"events buffered for syslog before new ones are dropped"`
+	FilePath             string        `yaml:"file_path" toml:"file_path" env:"AUDIT_FILE_PATH" desc:"JSON-lines audit file"`
+	FileMaxSizeMB        int64         `yaml:"file_max_size_mb" toml:"file_max_size_mb" env:"AUDIT_FILE_MAX_SIZE_MB" desc:"size at which the audit file is rotated"`
+	FileMaxBackups       int           `yaml:"file_max_backups" toml:"file_max_backups" env:"AUDIT_FILE_MAX_BACKUPS" desc:"rotated audit files to keep"`
+	WebhookURL           string        `yaml:"webhook_url" toml:"webhook_url" env:"AUDIT_WEBHOOK_URL" desc:"HTTPS collector audit batches are posted to"`
+	WebhookToken         string        `yaml:"webhook_token" toml:"webhook_token" env:"AUDIT_WEBHOOK_TOKEN" secret:"true" desc:"bearer token sent to the audit collector"`
+	WebhookBatchSize     int           `yaml:"webhook_batch_size" toml:"webhook_batch_size" env:"AUDIT_WEBHOOK_BATCH_SIZE" desc:"maximum events per webhook request"`
+	WebhookFlushInterval time.Duration `yaml:"webhook_flush_interval" toml:"webhook_flush_interval" env:"AUDIT_WEBHOOK_FLUSH_INTERVAL" desc:"maximum time events wait before being sent"`
+	WebhookQueueSize     int           `yaml:"webhook_queue_size" toml:"webhook_queue_size" env:"AUDIT_WEBHOOK_QUEUE_SIZE" desc:"events buffered before new ones are dropped"`
+	WebhookMaxRetries    int           `yaml:"webhook_max_retries" toml:"webhook_max_retries" env:"AUDIT_WEBHOOK_MAX_RETRIES" desc:"delivery retries per batch"`
+}
+
+// NotifyConfig configures webhooks sent to creators when their secret is read
+type NotifyConfig struct {
+	Enabled      bool          `yaml:"enabled" toml:"enabled" env:"NOTIFY_ENABLED" desc:"accept notify_url and deliver creator notifications"`
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"NOTIFY_POLL_INTERVAL" desc:"how often the outbox is checked for due notifications"`
+	BatchSize    int           `yaml:"batch_size" toml:"batch_size" env:"NOTIFY_BATCH_SIZE" desc:"notifications sent per poll"`
+	MaxAttempts  int           `yaml:"max_attempts" toml:"max_attempts" env:"NOTIFY_MAX_ATTEMPTS" desc:"delivery attempts before a notification is abandoned"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout" env:"NOTIFY_TIMEOUT" desc:"timeout for each delivery attempt"`
+	AllowHTTP    bool          `yaml:"allow_http" toml:"allow_http" env:"NOTIFY_ALLOW_HTTP" desc:"accept plain http notify URLs (development only)"`
+	AllowPrivate bool          `yaml:"allow_private" toml:"allow_private" env:"NOTIFY_ALLOW_PRIVATE" desc:"send notifications to loopback and private addresses (development only)"`
+}
+
+// MailConfig configures email delivery of secret links and open notifications
+type MailConfig struct {
+	Enabled        bool          `yaml:"enabled" toml:"enabled" env:"MAIL_ENABLED" desc:"accept recipient_email and notify_email and send mail"`
+	SMTPHost       string        `yaml:"smtp_host" toml:"smtp_host" env:"MAIL_SMTP_HOST" desc:"SMTP relay host"`
+	SMTPPort       int           `yaml:"smtp_port" toml:"smtp_port" env:"MAIL_SMTP_PORT" desc:"SMTP relay port"`
+	Username       string        `yaml:"username" toml:"username" env:"MAIL_SMTP_USERNAME" desc:"SMTP username (PLAIN auth)"`
+	Password       string        `yaml:"password" toml:"password" env:"MAIL_SMTP_PASSWORD" secret:"true" desc:"SMTP password"`
+	AllowPlaintext bool          `yaml:"allow_plaintext" toml:"allow_plaintext" env:"MAIL_SMTP_ALLOW_PLAINTEXT" desc:"send mail without TLS to servers that do not offer STARTTLS"`
+	From           string        `yaml:"from" toml:"from" env:"MAIL_FROM" desc:"From address, e.g. Disapyr <noreply@example.com>"`
+	LinkBaseURL    string        `yaml:"link_base_url" toml:"link_base_url" env:"MAIL_LINK_BASE_URL" desc:"UI base URL used to build emailed secret links"`
+	DevDir         string        `yaml:"dev_dir" toml:"dev_dir" env:"MAIL_DEV_DIR" desc:"write mail as .eml files to this directory instead of sending it"`
+	PollInterval   time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"MAIL_POLL_INTERVAL" desc:"how often the mail outbox is checked"`
+	BatchSize      int           `yaml:"batch_size" toml:"batch_size" env:"MAIL_BATCH_SIZE" desc:"mails sent per poll"`
+	MaxAttempts    int           `yaml:"max_attempts" toml:"max_attempts" env:"MAIL_MAX_ATTEMPTS" desc:"delivery attempts before a mail is abandoned"`
+	Timeout        time.Duration `yaml:"timeout" toml:"timeout" env:"MAIL_TIMEOUT" desc:"timeout for each SMTP session"`
+}
+
+// BlobConfig configures the store that holds large file payloads outside the
+// database
+type BlobConfig struct {
+	Backend       string        `yaml:"backend" toml:"backend" env:"BLOB_BACKEND" desc:"where payloads above the threshold are stored: none, local or s3"`
+	Threshold     int64         `yaml:"threshold" toml:"threshold" env:"BLOB_THRESHOLD" desc:"files larger than this many bytes are stored in the blob store"`
+	Dir           string        `yaml:"dir" toml:"dir" env:"BLOB_DIR" desc:"directory used by the local backend"`
+	S3Endpoint    string        `yaml:"s3_endpoint" toml:"s3_endpoint" env:"BLOB_S3_ENDPOINT" desc:"S3-compatible endpoint URL, e.g. http://localhost:9000 for MinIO"`
+	S3Region      string        `yaml:"s3_region" toml:"s3_region" env:"BLOB_S3_REGION" desc:"S3 region used for request signing"`
+	S3Bucket      string        `yaml:"s3_bucket" toml:"s3_bucket" env:"BLOB_S3_BUCKET" desc:"S3 bucket holding payloads"`
+	S3Prefix      string        `yaml:"s3_prefix" toml:"s3_prefix" env:"BLOB_S3_PREFIX" desc:"key prefix for payloads in the bucket"`
+	S3AccessKey   string        `yaml:"s3_access_key" toml:"s3_access_key" env:"BLOB_S3_ACCESS_KEY" desc:"S3 access key ID"`
+	S3SecretKey   string        `yaml:"s3_secret_key" toml:"s3_secret_key" env:"BLOB_S3_SECRET_KEY" secret:"true" desc:"S3 secret access key"`
+	S3PathStyle   bool          `yaml:"s3_path_style" toml:"s3_path_style" env:"BLOB_S3_PATH_STYLE" desc:"address the bucket in the path rather than the host name"`
+	Timeout       time.Duration `yaml:"timeout" toml:"timeout" env:"BLOB_TIMEOUT" desc:"timeout for each blob store request"`
+	SweepInterval time.Duration `yaml:"sweep_interval" toml:"sweep_interval" env:"BLOB_SWEEP_INTERVAL" desc:"how often orphaned blobs are swept"`
+	OrphanAge     time.Duration `yaml:"orphan_age" toml:"orphan_age" env:"BLOB_ORPHAN_AGE" desc:"age after which an unreferenced blob is deleted"`
+}
+
+// LimitsConfig bounds the size of stored secrets
+type LimitsConfig struct {
+	MaxSecretSize int64 `yaml:"max_secret_size" toml:"max_secret_size" env:"MAX_SECRET_SIZE" desc:"largest text secret accepted, in bytes"`
+	MaxFileSize   int64 `yaml:"max_file_size" toml:"max_file_size" env:"MAX_FILE_SIZE" desc:"largest file secret accepted, in bytes"`
+	MaxBodySize   int64 `yaml:"max_body_size" toml:"max_body_size" env:"MAX_BODY_SIZE" desc:"largest request body accepted, in bytes"`
+}
+
+// DefaultConfig returns the configuration used when nothing else is set
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            8080,
+			HTTPSEnabled:    true,
+			RateLimit:       10,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Security: SecurityConfig{
+			KeyLen: 32,
+		},
+		Database: DatabaseConfig{
+			Host: "localhost",
+			Port: 5432,
+		},
+		Auth: AuthConfig{
+			GrantType:    "client_credentials",
+			JWKSCacheTTL: 10 * time.Minute,
+			AdminScope:   "read:audit",
+		},
+		UI: UIConfig{
+			Port:            3000,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Log: LogConfig{
+			Level: "debug",
+		},
+		Metrics: MetricsConfig{
+			Path: "/metrics",
+		},
+		Tracing: TracingConfig{
+			Endpoint:    "http://localhost:4318/v1/traces",
+			SampleRatio: 1,
+		},
+		Audit: AuditConfig{
+			SyslogNetwork:        "udp",
+			SyslogFacility:       10,
+			SyslogQueueSize:      10000,
+			FileMaxSizeMB:        100,
+			FileMaxBackups:       5,
+			WebhookBatchSize:     100,
+			WebhookFlushInterval: 5 * time.Second,
+			WebhookQueueSize:     10000,
+			WebhookMaxRetries:    5,
+		},
+		Notify: NotifyConfig{
+			PollInterval: 5 * time.Second,
+			BatchSize:    20,
+			MaxAttempts:  10,
+			Timeout:      10 * time.Second,
+		},
+		Mail: MailConfig{
+			SMTPPort:     587,
+			PollInterval: 5 * time.Second,
+			BatchSize:    20,
+			MaxAttempts:  8,
+			Timeout:      30 * time.Second,
+		},
+		Limits: LimitsConfig{
+			MaxSecretSize: 1024 * 1024,
+			MaxFileSize:   10 * 1024 * 1024,
+			MaxBodySize:   11 * 1024 * 1024,
+		},
+		Blob: BlobConfig{
+			Backend:       BlobBackendNone,
+			Threshold:     256 * 1024,
+			S3Region:      "us-east-1",
+			S3PathStyle:   true,
+			Timeout:       time.Minute,
+			SweepInterval: time.Hour,
+			OrphanAge:     time.Hour,
+		},
+	}
+}
+
+// LoadConfig resolves the configuration for the given component from defaults,
+// config file, environment and the supplied command-line arguments, then
+// validates it. A missing .env file is not an error.
+func LoadConfig(component Component, args []string) (*Config, error) {
+	return loadConfig(component, args, os.Stdout)
+}
+
+func loadConfig(component Component, args []string, out io.Writer) (*Config, error) {
+	cfg := DefaultConfig()
+	fields := configFields(cfg)
+
+	// Flags are collected first so that --config can select the file, but
+	// they are applied last so that they take precedence over everything else.
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a YAML or TOML config file (env "+ConfigEnvVar+")")
+	printConfig := fs.Bool("print-config", false, "print the resolved configuration with secrets redacted and exit")
+	flagValues := map[string]string{}
+	for _, f := range fields {
+		name := f.path
+		fs.Func(name, fmt.Sprintf("%s (env %s)", f.desc, f.env), func(s string) error {
+			flagValues[name] = s
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := loadDotEnv(); err != nil {
+		return nil, err
+	}
+
+	if *configPath == "" {
+		*configPath = os.Getenv(ConfigEnvVar)
+	}
+	if *configPath != "" {
+		if err := loadConfigFile(cfg, *configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := setField(f.value, v); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if v, ok := flagValues[f.path]; ok {
+			if err := setField(f.value, v); err != nil {
+				return nil, fmt.Errorf("invalid value for --%s: %w", f.path, err)
+			}
+		}
+	}
+
+	if *printConfig {
+		if err := cfg.Print(out); err != nil {
+			return nil, err
+		}
+		return nil, ErrConfigPrinted
+	}
+
+	if err := cfg.Validate(component); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadDotEnv loads a .env file from the working directory if one exists.
+func loadDotEnv() error {
+	if _, err := os.Stat(".env"); os.IsNotExist(err) {
+		return nil
+	}
+	if err := godotenv.Load(); err != nil {
+		return fmt.Errorf("error loading .env file: %w", err)
+	}
+	return nil
+}
+
+// loadConfigFile decodes a YAML or TOML file, chosen by extension, into cfg.
+func loadConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config file format %q: use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the settings required by the given component and reports
+// every problem found rather than just the first.
+func (c *Config) Validate(component Component) error {
+	var errs []error
+	require := func(value, path, env string) {
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Errorf("%s (env %s) is required", path, env))
+		}
+	}
+	checkPort := func(port int, path, env string) {
+		if port <= 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s (env %s) must be between 1 and 65535, got %d", path, env, port))
+		}
+	}
+	checkPositive := func(d time.Duration, path, env string) {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s (env %s) must be positive, got %s", path, env, d))
+		}
+	}
+
+	if _, err := ParseLogLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level (env LOG_LEVEL): %w", err))
+	}
+
+	if c.Metrics.Enabled && !strings.HasPrefix(c.Metrics.Path, "/") {
+		errs = append(errs, fmt.Errorf("metrics.path (env METRICS_PATH) must start with /, got %q", c.Metrics.Path))
+	}
+
+	if c.Tracing.Enabled {
+		require(c.Tracing.Endpoint, "tracing.endpoint", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			errs = append(errs, fmt.Errorf("tracing.sample_ratio (env TRACING_SAMPLE_RATIO) must be between 0 and 1, got %v", c.Tracing.SampleRatio))
+		}
+	}
+
+	for _, l := range []struct {
+		value     int64
+		path, env string
+	}{
+		{c.Limits.MaxSecretSize, "limits.max_secret_size", "MAX_SECRET_SIZE"},
+		{c.Limits.MaxFileSize, "limits.max_file_size", "MAX_FILE_SIZE"},
+		{c.Limits.MaxBodySize, "limits.max_body_size", "MAX_BODY_SIZE"},
+	} {
+		if l.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s (env %s) must be positive, got %d", l.path, l.env, l.value))
+		}
+	}
+	if c.Limits.MaxBodySize < c.Limits.MaxFileSize || c.Limits.MaxBodySize < c.Limits.MaxSecretSize {
+		errs = append(errs, fmt.Errorf("limits.max_body_size (env MAX_BODY_SIZE) must be at least limits.max_file_size and limits.max_secret_size"))
+	}
+
+	switch component {
+	case APIComponent:
+		checkPort(c.Server.Port, "server.port", "PORT")
+		checkPositive(c.Server.ShutdownTimeout, "server.shutdown_timeout", "SHUTDOWN_TIMEOUT")
+		if c.Server.RateLimit <= 0 {
+			errs = append(errs, fmt.Errorf("server.rate_limit (env RATE_LIMIT) must be positive, got %d", c.Server.RateLimit))
+		}
+		if c.Server.HTTPSEnabled {
+			require(c.Server.CertPath, "server.cert_path", "CERT_PATH")
+			require(c.Server.KeyPath, "server.key_path", "KEY_PATH")
+			for _, p := range []struct{ path, name, env string }{
+				{c.Server.CertPath, "server.cert_path", "CERT_PATH"},
+				{c.Server.KeyPath, "server.key_path", "KEY_PATH"},
+			} {
+				if p.path == "" {
+					continue
+				}
+				if _, err := os.Stat(p.path); err != nil {
+					errs = append(errs, fmt.Errorf("%s (env %s) file does not exist: %s", p.name, p.env, p.path))
+				}
+			}
+		}
+		switch len(c.Security.EncKey) {
+		case 16, 24, 32:
+		case 0:
+			require(c.Security.EncKey, "security.enc_key", "ENC_KEY")
+		default:
+			errs = append(errs, fmt.Errorf("security.enc_key (env ENC_KEY) must be 16, 24 or 32 bytes, got %d", len(c.Security.EncKey)))
+		}
+		if c.Security.KeyLen <= 0 {
+			errs = append(errs, fmt.Errorf("security.key_len (env KEY_LEN) must be positive, got %d", c.Security.KeyLen))
+		}
+		require(c.Database.User, "database.user", "DB_USER")
+		require(c.Database.Host, "database.host", "DB_HOST")
+		require(c.Database.Name, "database.name", "DB_NAME")
+		checkPort(c.Database.Port, "database.port", "DB_PORT")
+		require(c.Auth.Domain, "auth.domain", "URL")
+		require(c.Auth.Audience, "auth.audience", "AUDIENCE")
+		checkPositive(c.Auth.JWKSCacheTTL, "auth.jwks_cache_ttl", "JWKS_CACHE_TTL")
+		require(c.Auth.AdminScope, "auth.admin_scope", "ADMIN_SCOPE")
+		if c.Audit.SyslogAddress != "" {
+			switch c.Audit.SyslogNetwork {
+			case "udp", "tcp", "tls":
+			default:
+				errs = append(errs, fmt.Errorf("audit.syslog_network (env AUDIT_SYSLOG_NETWORK) must be udp, tcp or tls, got %q", c.Audit.SyslogNetwork))
+			}
+			if c.Audit.SyslogFacility < 0 || c.Audit.SyslogFacility > 23 {
+				errs = append(errs, fmt.Errorf("audit.syslog_facility (env AUDIT_SYSLOG_FACILITY) must be between 0 and 23, got %d", c.Audit.SyslogFacility))
+			}
+			if c.Audit.SyslogQueueSize <= 0 {
+				errs = append(errs, fmt.Errorf("audit.syslog_queue_size (env AUDIT_SYSLOG_QUEUE_SIZE) must be positive"))
+			}
+		}
+		if c.Audit.WebhookURL != "" {
+			if !strings.HasPrefix(c.Audit.WebhookURL, "https://") {
+				errs = append(errs, fmt.Errorf("audit.webhook_url (env AUDIT_WEBHOOK_URL) must use https"))
+			}
+			if c.Audit.WebhookBatchSize <= 0 || c.Audit.WebhookQueueSize <= 0 {
+				errs = append(errs, fmt.Errorf("audit webhook batch and queue sizes must be positive"))
+			}
+			checkPositive(c.Audit.WebhookFlushInterval, "audit.webhook_flush_interval", "AUDIT_WEBHOOK_FLUSH_INTERVAL")
+		}
+		if c.Notify.Enabled {
+			checkPositive(c.Notify.PollInterval, "notify.poll_interval", "NOTIFY_POLL_INTERVAL")
+			checkPositive(c.Notify.Timeout, "notify.timeout", "NOTIFY_TIMEOUT")
+			if c.Notify.BatchSize <= 0 || c.Notify.MaxAttempts <= 0 {
+				errs = append(errs, fmt.Errorf("notify batch size and max attempts must be positive"))
+			}
+		}
+		if c.Mail.Enabled {
+			require(c.Mail.From, "mail.from", "MAIL_FROM")
+			require(c.Mail.LinkBaseURL, "mail.link_base_url", "MAIL_LINK_BASE_URL")
+			if c.Mail.DevDir == "" {
+				require(c.Mail.SMTPHost, "mail.smtp_host", "MAIL_SMTP_HOST")
+				checkPort(c.Mail.SMTPPort, "mail.smtp_port", "MAIL_SMTP_PORT")
+			}
+			checkPositive(c.Mail.PollInterval, "mail.poll_interval", "MAIL_POLL_INTERVAL")
+			checkPositive(c.Mail.Timeout, "mail.timeout", "MAIL_TIMEOUT")
+			if c.Mail.BatchSize <= 0 || c.Mail.MaxAttempts <= 0 {
+				errs = append(errs, fmt.Errorf("mail batch size and max attempts must be positive"))
+			}
+		}
+		switch c.Blob.Backend {
+		case BlobBackendNone:
+		case BlobBackendLocal:
+			require(c.Blob.Dir, "blob.dir", "BLOB_DIR")
+		case BlobBackendS3:
+			require(c.Blob.S3Endpoint, "blob.s3_endpoint", "BLOB_S3_ENDPOINT")
+			require(c.Blob.S3Region, "blob.s3_region", "BLOB_S3_REGION")
+			require(c.Blob.S3Bucket, "blob.s3_bucket", "BLOB_S3_BUCKET")
+			require(c.Blob.S3AccessKey, "blob.s3_access_key", "BLOB_S3_ACCESS_KEY")
+			require(c.Blob.S3SecretKey, "blob.s3_secret_key", "BLOB_S3_SECRET_KEY")
+		default:
+			errs = append(errs, fmt.Errorf("blob.backend (env BLOB_BACKEND) must be none, local or s3, got %q", c.Blob.Backend))
+		}
+		if c.Blob.Backend != BlobBackendNone {
+			if c.Blob.Threshold < 0 {
+				errs = append(errs, fmt.Errorf("blob.threshold (env BLOB_THRESHOLD) must not be negative, got %d", c.Blob.Threshold))
+			}
+			checkPositive(c.Blob.Timeout, "blob.timeout", "BLOB_TIMEOUT")
+			checkPositive(c.Blob.SweepInterval, "blob.sweep_interval", "BLOB_SWEEP_INTERVAL")
+			checkPositive(c.Blob.OrphanAge, "blob.orphan_age", "BLOB_ORPHAN_AGE")
+		}
+	case UIComponent:
+		checkPort(c.UI.Port, "ui.port", "UI_HOST_PORT")
+		checkPositive(c.UI.ShutdownTimeout, "ui.shutdown_timeout", "UI_SHUTDOWN_TIMEOUT")
+		require(c.UI.APIBaseURL, "ui.api_base_url", "BASE_URL")
+		require(c.Auth.Domain, "auth.domain", "URL")
+		require(c.Auth.Audience, "auth.audience", "AUDIENCE")
+		require(c.Auth.ClientID, "auth.client_id", "CLIENT_ID")
+		require(c.Auth.ClientSecret, "auth.client_secret", "CLIENT_SECRET")
+		require(c.Auth.GrantType, "auth.grant_type", "GRANT_TYPE")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with every secret field masked.
+func (c *Config) Redacted() *Config {
+	cp := *c
+	for _, f := range configFields(&cp) {
+		if f.secret && !f.value.IsZero() {
+			f.value.SetString(redacted)
+		}
+	}
+	return &cp
+}
+
+// Print writes the configuration as YAML with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return fmt.Errorf("error encoding configuration: %w", err)
+	}
+	return enc.Close()
+}
+
+// configField describes a single leaf setting of Config.
+type configField struct {
+	path   string
+	env    string
+	desc   string
+	secret bool
+	value  reflect.Value
+}
+
+// configFields walks cfg and returns every leaf setting in declaration order.
+func configFields(cfg *Config) []configField {
+	var fields []configField
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			fv := v.Field(i)
+			if sf.Type.Kind() == reflect.Struct {
+				walk(fv, name)
+				continue
+			}
+			fields = append(fields, configField{
+				path:   name,
+				env:    sf.Tag.Get("env"),
+				desc:   sf.Tag.Get("desc"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  fv,
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return fields
+}
+
+// setField parses s according to the kind of v and stores it.
+func setField(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	t.Run("defaults without a .env file", func(t *testing.T) {
+		cfg, err := loadConfig(UIComponent, []string{
+			"--ui.api_base_url=https://api.example.com",
+			"--auth.domain=tenant.auth0.com",
+			"--auth.audience=https://disapyr.link",
+			"--auth.client_id=id",
+			"--auth.client_secret=secret",
+		}, &bytes.Buffer{})
+		assert.NoError(t, err)
+		assert.Equal(t, 3000, cfg.UI.Port)
+		assert.Equal(t, "client_credentials", cfg.Auth.GrantType)
+	})
+
+	t.Run("flags override env which overrides file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "disapyr.yaml")
+		err := os.WriteFile(path, []byte("server:\n  port: 9000\n  rate_limit: 3\ndatabase:\n  name: fromfile\n"), 0o600)
+		assert.NoError(t, err)
+
+		t.Setenv("RATE_LIMIT", "7")
+		t.Setenv("DB_NAME", "fromenv")
+
+		cfg := DefaultConfig()
+		assert.NoError(t, loadConfigFile(cfg, path))
+		assert.Equal(t, 9000, cfg.Server.Port)
+
+		cfg, err = loadConfig(APIComponent, []string{
+			"--config", path,
+			"--database.name=fromflag",
+			"--server.https_enabled=false",
+			"--security.enc_key=0123456789abcdef",
+			"--database.user=disapyr",
+			"--auth.domain=tenant.auth0.com",
+			"--auth.audience=https://disapyr.link",
+		}, &bytes.Buffer{})
+		assert.NoError(t, err)
+		assert.Equal(t, 9000, cfg.Server.Port)
+		assert.Equal(t, 7, cfg.Server.RateLimit)
+		assert.Equal(t, "fromflag", cfg.Database.Name)
+	})
+
+	t.Run("toml file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "disapyr.toml")
+		err := os.WriteFile(path, []byte("[ui]\nport = 4000\napi_base_url = \"https://api\"\n"), 0o600)
+		assert.NoError(t, err)
+
+		cfg := DefaultConfig()
+		assert.NoError(t, loadConfigFile(cfg, path))
+		assert.Equal(t, 4000, cfg.UI.Port)
+		assert.Equal(t, "https://api", cfg.UI.APIBaseURL)
+	})
+
+	t.Run("print config redacts secrets", func(t *testing.T) {
+		var out bytes.Buffer
+		_, err := loadConfig(APIComponent, []string{
+			"--print-config",
+			"--security.enc_key=0123456789abcdef",
+			"--database.password=hunter2",
+		}, &out)
+		assert.ErrorIs(t, err, ErrConfigPrinted)
+		assert.NotContains(t, out.String(), "hunter2")
+		assert.NotContains(t, out.String(), "0123456789abcdef")
+		assert.Contains(t, out.String(), redacted)
+	})
+
+	t.Run("validation reports every problem", func(t *testing.T) {
+		_, err := loadConfig(APIComponent, []string{"--security.enc_key=short", "--server.https_enabled=true"}, &bytes.Buffer{})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "security.enc_key (env ENC_KEY) must be 16, 24 or 32 bytes")
+		assert.Contains(t, err.Error(), "server.cert_path (env CERT_PATH) is required")
+		assert.Contains(t, err.Error(), "database.user (env DB_USER) is required")
+	})
+
+	t.Run("invalid env value", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT", "lots")
+		_, err := loadConfig(APIComponent, nil, &bytes.Buffer{})
+		assert.ErrorContains(t, err, "invalid value for RATE_LIMIT")
+	})
+}
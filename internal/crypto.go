@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Payloads are encrypted in fixed-size chunks so that they can be encrypted and
+// decrypted as a stream. Each chunk is sealed with AES-256-GCM under a nonce
+// made of a random per-payload prefix and the chunk counter, and the final
+// chunk is marked in its additional data so truncation is detected.
+const (
+	payloadChunkSize   = 64 * 1024
+	payloadPrefixSize  = 8
+	payloadKeyInfo     = "disapyr payload encryption v1"
+	payloadFinalChunk  = 1
+	payloadMiddleChunk = 0
+)
+
+// ErrPayloadCorrupt is returned when an encrypted payload fails to decrypt
+var ErrPayloadCorrupt = errors.New("encrypted payload is corrupt or truncated")
+
+// derivePayloadKey derives the payload encryption key from the configured
+// ENC_KEY, keeping it separate from the key used by HideIdentifier.
+func derivePayloadKey(encKey []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, encKey, nil, payloadKeyInfo, 32)
+}
+
+func newPayloadAEAD(encKey []byte) (cipher.AEAD, error) {
+	key, err := derivePayloadKey(encKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive payload key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func payloadNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[payloadPrefixSize:], counter)
+	return nonce
+}
+
+// payloadEncrypter is returned by NewPayloadEncrypter
+type payloadEncrypter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// NewPayloadEncrypter returns a writer that encrypts everything written to it
+// into w. Close must be called to write the final chunk.
+func NewPayloadEncrypter(w io.Writer, encKey []byte) (io.WriteCloser, error) {
+	aead, err := newPayloadAEAD(encKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, payloadPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &payloadEncrypter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, payloadChunkSize)}, nil
+}
+
+func (e *payloadEncrypter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed payload encrypter")
+	}
+	n := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, so that the last
+		// chunk is always sealed as final by Close.
+		if len(e.buf) == payloadChunkSize {
+			if err := e.seal(payloadMiddleChunk); err != nil {
+				return n, err
+			}
+		}
+		c := copy(e.buf[len(e.buf):payloadChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (e *payloadEncrypter) seal(final byte) error {
+	sealed := e.aead.Seal(nil, payloadNonce(e.prefix, e.counter), e.buf, []byte{final})
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close seals the final chunk.
+func (e *payloadEncrypter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(payloadFinalChunk)
+}
+
+// payloadDecrypter is returned by NewPayloadDecrypter
+type payloadDecrypter struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+// NewPayloadDecrypter returns a reader that decrypts a payload written by a
+// payload encrypter. Reads fail with ErrPayloadCorrupt if the payload has been
+// tampered with or truncated.
+func NewPayloadDecrypter(r io.Reader, encKey []byte) (io.Reader, error) {
+	aead, err := newPayloadAEAD(encKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, payloadPrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, ErrPayloadCorrupt
+	}
+	return &payloadDecrypter{
+		r:      bufio.NewReaderSize(r, payloadChunkSize+aead.Overhead()+1),
+		aead:   aead,
+		prefix: prefix,
+		chunk:  make([]byte, payloadChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *payloadDecrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *payloadDecrypter) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ErrPayloadCorrupt
+	}
+	final := byte(payloadMiddleChunk)
+	if n < len(d.chunk) {
+		final = payloadFinalChunk
+	} else if _, err := d.r.Peek(1); err == io.EOF {
+		final = payloadFinalChunk
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], payloadNonce(d.prefix, d.counter), d.chunk[:n], []byte{final})
+	if err != nil {
+		return ErrPayloadCorrupt
+	}
+	d.counter++
+	d.plain = plain
+	d.done = final == payloadFinalChunk
+	return nil
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testEncKey = []byte("0123456789abcdef0123456789abcdef")
+
+func encryptPayload(t *testing.T, plaintext []byte) []byte {
+	var buf bytes.Buffer
+	enc, err := NewPayloadEncrypter(&buf, testEncKey)
+	assert.NoError(t, err)
+	_, err = enc.Write(plaintext)
+	assert.NoError(t, err)
+	assert.NoError(t, enc.Close())
+	return buf.Bytes()
+}
+
+func decryptPayload(ciphertext, key []byte) ([]byte, error) {
+	dec, err := NewPayloadDecrypter(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dec)
+}
+
+func TestPayloadEncryptionRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, payloadChunkSize - 1, payloadChunkSize, payloadChunkSize + 1, 3*payloadChunkSize + 17} {
+		plaintext := make([]byte, size)
+		_, _ = rand.Read(plaintext)
+
+		ciphertext := encryptPayload(t, plaintext)
+		if size >= 16 {
+			assert.NotContains(t, string(ciphertext), string(plaintext[:min(size, 32)]))
+		}
+
+		got, err := decryptPayload(ciphertext, testEncKey)
+		assert.NoError(t, err, "size %d", size)
+		assert.True(t, bytes.Equal(plaintext, got), "size %d", size)
+	}
+}
+
+func TestPayloadEncryptionDetectsTampering(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("s3cr3t"), payloadChunkSize/2)
+	ciphertext := encryptPayload(t, plaintext)
+
+	t.Run("flipped bit", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(tampered)/2] ^= 1
+		_, err := decryptPayload(tampered, testEncKey)
+		assert.ErrorIs(t, err, ErrPayloadCorrupt)
+	})
+
+	t.Run("truncated at a chunk boundary", func(t *testing.T) {
+		overhead := 16
+		truncated := ciphertext[:payloadPrefixSize+payloadChunkSize+overhead]
+		_, err := decryptPayload(truncated, testEncKey)
+		assert.ErrorIs(t, err, ErrPayloadCorrupt)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, err := decryptPayload(ciphertext, []byte("fedcba9876543210fedcba9876543210"))
+		assert.ErrorIs(t, err, ErrPayloadCorrupt)
+	})
+}
@@ -7,7 +7,8 @@ import (
 
 // ErrorResponse represents a standardized error response
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error    string        `json:"error"`
+	Category ErrorCategory `json:"category,omitempty"`
 }
 
 // ErrorCategory defines the general category of an error
@@ -16,6 +17,8 @@ type ErrorCategory string
 const (
 	// AuthError represents authentication and authorization errors
 	AuthError ErrorCategory = "auth"
+	// ForbiddenError represents authenticated callers lacking a required permission
+	ForbiddenError ErrorCategory = "forbidden"
 	// ValidationError represents input validation errors
 	ValidationError ErrorCategory = "validation"
 	// DatabaseError represents database-related errors
@@ -26,26 +29,32 @@ const (
 	RateLimitError ErrorCategory = "rate_limit"
 	// NotFoundError represents resource not found errors
 	NotFoundError ErrorCategory = "not_found"
+	// PayloadTooLargeError represents request bodies or secrets above the size limits
+	PayloadTooLargeError ErrorCategory = "payload_too_large"
 )
 
 // ErrorStatusMap maps error categories to HTTP status codes
 var ErrorStatusMap = map[ErrorCategory]int{
-	AuthError:       fiber.StatusUnauthorized,
-	ValidationError: fiber.StatusBadRequest,
-	DatabaseError:   fiber.StatusInternalServerError,
-	ServerError:     fiber.StatusInternalServerError,
-	RateLimitError:  fiber.StatusTooManyRequests,
-	NotFoundError:   fiber.StatusNotFound,
+	AuthError:            fiber.StatusUnauthorized,
+	ForbiddenError:       fiber.StatusForbidden,
+	ValidationError:      fiber.StatusBadRequest,
+	DatabaseError:        fiber.StatusInternalServerError,
+	ServerError:          fiber.StatusInternalServerError,
+	RateLimitError:       fiber.StatusTooManyRequests,
+	NotFoundError:        fiber.StatusNotFound,
+	PayloadTooLargeError: fiber.StatusRequestEntityTooLarge,
 }
 
 // ErrorMessageMap maps error categories to user-friendly error messages
 var ErrorMessageMap = map[ErrorCategory]string{
-	AuthError:       "Authentication failed",
-	ValidationError: "Invalid request data",
-	DatabaseError:   "Database operation failed",
-	ServerError:     "Internal server error",
-	RateLimitError:  "Too many requests",
-	NotFoundError:   "Resource not found",
+	AuthError:            "Authentication failed",
+	ForbiddenError:       "Permission denied",
+	ValidationError:      "Invalid request data",
+	DatabaseError:        "Database operation failed",
+	ServerError:          "Internal server error",
+	RateLimitError:       "Too many requests",
+	NotFoundError:        "Resource not found",
+	PayloadTooLargeError: "Payload too large",
 }
 
 // HandleError logs an error with detailed information and returns a standardized error response
@@ -53,9 +62,18 @@ var ErrorMessageMap = map[ErrorCategory]string{
 func HandleError(c *fiber.Ctx, category ErrorCategory, logMessage string, err error) error {
 	// Log the detailed error for debugging
 	if err != nil {
-		log.Error(logMessage, "error", err, "category", category)
+		log.Error(logMessage, "error", err, "category", category, "request_id", RequestID(c))
 	} else {
-		log.Error(logMessage, "category", category)
+		log.Error(logMessage, "category", category, "request_id", RequestID(c))
+	}
+
+	recordErrorMetrics(c, category)
+
+	// Export rejected credentials to the audit sinks.
+	if category == AuthError || category == ForbiddenError {
+		if audit := auditFromContext(c); audit != nil {
+			audit.Emit(c.UserContext(), NewAuditEvent(c, AuditAuthFailure, c.Params("key"), logMessage))
+		}
 	}
 
 	// Get the appropriate status code and user-friendly message
@@ -63,7 +81,7 @@ func HandleError(c *fiber.Ctx, category ErrorCategory, logMessage string, err er
 	message := ErrorMessageMap[category]
 
 	// Return a standardized error response
-	return c.Status(statusCode).JSON(ErrorResponse{Error: message})
+	return c.Status(statusCode).JSON(ErrorResponse{Error: message, Category: category})
 }
 
 // HandleAuthError is a convenience function for handling authentication errors
@@ -71,6 +89,11 @@ func HandleAuthError(c *fiber.Ctx, logMessage string, err error) error {
 	return HandleError(c, AuthError, logMessage, err)
 }
 
+// HandleForbiddenError is a convenience function for handling permission errors
+func HandleForbiddenError(c *fiber.Ctx, logMessage string, err error) error {
+	return HandleError(c, ForbiddenError, logMessage, err)
+}
+
 // HandleValidationError is a convenience function for handling validation errors
 func HandleValidationError(c *fiber.Ctx, logMessage string, err error) error {
 	return HandleError(c, ValidationError, logMessage, err)
@@ -95,3 +118,8 @@ func HandleRateLimitError(c *fiber.Ctx, logMessage string, err error) error {
 func HandleNotFoundError(c *fiber.Ctx, logMessage string, err error) error {
 	return HandleError(c, NotFoundError, logMessage, err)
 }
+
+// HandlePayloadTooLargeError is a convenience function for handling oversized payloads
+func HandlePayloadTooLargeError(c *fiber.Ctx, logMessage string, err error) error {
+	return HandleError(c, PayloadTooLargeError, logMessage, err)
+}
@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"github.com/mr-tron/base58"
+)
+
+// secretEventsChannel is the Postgres NOTIFY channel carrying the watch token
+// hash of each secret as it is opened or revoked.
+const secretEventsChannel = "secret_events"
+
+const (
+	// watchHeartbeat is how often an idle status stream sends a comment so
+	// that proxies do not time it out.
+	watchHeartbeat = 15 * time.Second
+	// watchMaxDuration bounds a single status stream; EventSource clients
+	// reconnect on their own.
+	watchMaxDuration = 30 * time.Minute
+	// watchLookupTimeout bounds each status re-check against the database.
+	watchLookupTimeout = 2 * time.Second
+)
+
+// Secret statuses reported on the watch stream
+const (
+	SecretStatusPending = "pending"
+	SecretStatusOpened  = "opened"
+	SecretStatusRevoked = "revoked"
+)
+
+// SecretStatus is the data of each event on the watch stream and the body of
+// GET /status/:token.
+type SecretStatus struct {
+	Status    string     `json:"status"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Final reports whether the status can no longer change.
+func (s SecretStatus) Final() bool {
+	return s.Status == SecretStatusOpened || s.Status == SecretStatusRevoked
+}
+
+// NewWatchToken returns a random token that lets a secret's creator follow its
+// status. Only its hash is stored.
+func NewWatchToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate watch token: %w", err)
+	}
+	return base58.Encode(b), nil
+}
+
+// SecretEvents fans out secret status changes received over Postgres
+// LISTEN/NOTIFY to the status streams open on this replica, so a stream is
+// told about a secret opened through any replica.
+type SecretEvents struct {
+	listener *pq.Listener
+
+	mu     sync.Mutex
+	subs   map[string]map[chan struct{}]struct{}
+	closed bool
+}
+
+// NewSecretEvents creates a listener on its own database connection. Call Run
+// to start receiving events.
+func NewSecretEvents(cfg DatabaseConfig) *SecretEvents {
+	listener := pq.NewListener(databaseURL(cfg), time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("Secret events listener connection problem", "event", ev, "error", err)
+		}
+	})
+	return &SecretEvents{listener: listener, subs: map[string]map[chan struct{}]struct{}{}}
+}
+
+// Run dispatches notifications until ctx is cancelled, then closes every
+// subscription so open streams end and do not hold up shutdown.
+func (e *SecretEvents) Run(ctx context.Context) {
+	defer e.close()
+	// Listen blocks until the database is reachable; closing the listener
+	// on shutdown ends it.
+	listening := make(chan error, 1)
+	go func() { listening <- e.listener.Listen(secretEventsChannel) }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-listening:
+			if err != nil {
+				log.Error("Failed to listen for secret events", "error", err)
+			}
+		case n := <-e.listener.Notify:
+			e.dispatch(n)
+		}
+	}
+}
+
+// Subscribe returns a channel that is signalled whenever the secret with the
+// given watch token hash may have changed, and a function to unsubscribe. The
+// channel is closed when the event stream shuts down.
+func (e *SecretEvents) Subscribe(tokenHash string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if e.subs[tokenHash] == nil {
+		e.subs[tokenHash] = map[chan struct{}]struct{}{}
+	}
+	e.subs[tokenHash][ch] = struct{}{}
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subs[tokenHash][ch]; ok {
+			delete(e.subs[tokenHash], ch)
+			if len(e.subs[tokenHash]) == 0 {
+				delete(e.subs, tokenHash)
+			}
+		}
+	}
+}
+
+// dispatch signals the subscribers of a notification. A nil notification
+// means the listener reconnected and may have missed events, so every
+// subscriber is signalled to re-check.
+func (e *SecretEvents) dispatch(n *pq.Notification) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for hash, subs := range e.subs {
+		if n != nil && n.Extra != hash {
+			continue
+		}
+		for ch := range subs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (e *SecretEvents) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for _, subs := range e.subs {
+		for ch := range subs {
+			close(ch)
+		}
+	}
+	e.subs = map[string]map[chan struct{}]struct{}{}
+	if e.listener != nil {
+		if err := e.listener.Close(); err != nil {
+			log.Error("Failed to close secret events listener", "error", err)
+		}
+	}
+}
+
+// notifySecretChanged publishes the watch token hash of an opened or revoked
+// secret. The notification is only delivered if tx commits.
+func notifySecretChanged(ctx context.Context, tx *sql.Tx, tokenHash string) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", secretEventsChannel, tokenHash); err != nil {
+		return fmt.Errorf("failed to publish secret event: %w", err)
+	}
+	return nil
+}
+
+// lookupSecretStatus returns the status of the secret with the given watch
+// token hash, or ErrSecretNotFound.
+func lookupSecretStatus(ctx context.Context, db *sql.DB, tokenHash string) (SecretStatus, error) {
+	var openedAt, revokedAt *time.Time
+	err := db.QueryRowContext(ctx, "SELECT retrieved_at, revoked_at FROM secrets WHERE watch_token_hash = $1", tokenHash).
+		Scan(&openedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return SecretStatus{}, ErrSecretNotFound
+	} else if err != nil {
+		return SecretStatus{}, fmt.Errorf("failed to query secret status: %w", err)
+	}
+	if openedAt != nil {
+		t := openedAt.UTC()
+		return SecretStatus{Status: SecretStatusOpened, OpenedAt: &t}, nil
+	}
+	if revokedAt != nil {
+		t := revokedAt.UTC()
+		return SecretStatus{Status: SecretStatusRevoked, RevokedAt: &t}, nil
+	}
+	return SecretStatus{Status: SecretStatusPending}, nil
+}
+
+// writeSSE writes a single Server-Sent Event and flushes it to the client.
+func writeSSE(w *bufio.Writer, event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// streamSecretStatus sends the current status of the watched secret and then
+// follows it until it is opened or revoked, the client goes away, the stream reaches
+// watchMaxDuration or the server shuts down.
+func streamSecretStatus(c *fiber.Ctx, db *sql.DB, events *SecretEvents, tokenHash string, initial SecretStatus) {
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	changed, unsubscribe := events.Subscribe(tokenHash)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		status := initial
+		if err := writeSSE(w, "status", status); err != nil || status.Final() {
+			return
+		}
+
+		heartbeat := time.NewTicker(watchHeartbeat)
+		defer heartbeat.Stop()
+		deadline := time.NewTimer(watchMaxDuration)
+		defer deadline.Stop()
+
+		// The secret may have been opened between the initial lookup and
+		// subscribing, so always check once more.
+		recheck := true
+		for {
+			if recheck {
+				ctx, cancel := context.WithTimeout(context.Background(), watchLookupTimeout)
+				latest, err := lookupSecretStatus(ctx, db, tokenHash)
+				cancel()
+				if err != nil {
+					log.Error("Failed to refresh secret status", "error", err)
+				} else if latest.Status != status.Status {
+					status = latest
+					if err := writeSSE(w, "status", status); err != nil || status.Final() {
+						return
+					}
+				}
+				recheck = false
+			}
+
+			select {
+			case _, ok := <-changed:
+				if !ok {
+					return
+				}
+				recheck = true
+			case <-heartbeat.C:
+				if _, err := w.WriteString(": keepalive\n\n"); err != nil {
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			case <-deadline.C:
+				return
+			}
+		}
+	})
+}
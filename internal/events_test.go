@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSecretEvents() *SecretEvents {
+	return &SecretEvents{subs: map[string]map[chan struct{}]struct{}{}}
+}
+
+func signalled(ch <-chan struct{}) bool {
+	select {
+	case _, ok := <-ch:
+		return ok
+	default:
+		return false
+	}
+}
+
+func TestSecretEventsDispatch(t *testing.T) {
+	e := newTestSecretEvents()
+	a, unsubscribeA := e.Subscribe("hash-a")
+	b, _ := e.Subscribe("hash-b")
+
+	e.dispatch(&pq.Notification{Channel: secretEventsChannel, Extra: "hash-a"})
+	assert.True(t, signalled(a))
+	assert.False(t, signalled(b))
+
+	// Repeated notifications coalesce rather than block.
+	e.dispatch(&pq.Notification{Extra: "hash-b"})
+	e.dispatch(&pq.Notification{Extra: "hash-b"})
+	assert.True(t, signalled(b))
+	assert.False(t, signalled(b))
+
+	// A reconnect signals everyone to re-check.
+	e.dispatch(nil)
+	assert.True(t, signalled(a))
+	assert.True(t, signalled(b))
+
+	unsubscribeA()
+	e.dispatch(&pq.Notification{Extra: "hash-a"})
+	assert.False(t, signalled(a))
+	assert.NotContains(t, e.subs, "hash-a")
+}
+
+func TestSecretEventsClose(t *testing.T) {
+	e := newTestSecretEvents()
+	ch, unsubscribe := e.Subscribe("hash")
+	e.close()
+
+	_, ok := <-ch
+	assert.False(t, ok, "subscriptions are closed on shutdown")
+	unsubscribe()
+
+	late, _ := e.Subscribe("hash")
+	_, ok = <-late
+	assert.False(t, ok, "subscribing after shutdown returns a closed channel")
+}
+
+func TestWriteSSE(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	assert.NoError(t, writeSSE(w, "status", SecretStatus{Status: SecretStatusPending}))
+	assert.Equal(t, "event: status\ndata: {\"status\":\"pending\"}\n\n", buf.String())
+}
+
+func TestNewWatchToken(t *testing.T) {
+	a, err := NewWatchToken()
+	assert.NoError(t, err)
+	b, _ := NewWatchToken()
+	assert.NotEqual(t, a, b)
+	assert.GreaterOrEqual(t, len(a), 32)
+}
+
+func TestSecretEventsRunStopsWithoutDatabase(t *testing.T) {
+	// Nothing listens on port 1, so the listener never connects.
+	e := NewSecretEvents(DatabaseConfig{User: "disapyr", Host: "127.0.0.1", Port: 1, Name: "disapyr"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after ctx was cancelled")
+	}
+}
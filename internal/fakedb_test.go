@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver for tests that answers statements from a
+// script, in order, and records what was run. Transactions are recorded as
+// "BEGIN", "COMMIT" and "ROLLBACK".
+type fakeDB struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	script   []fakeResult
+	executed []string
+}
+
+// fakeResult answers the next statement, which must contain match.
+type fakeResult struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+// newFakeDB returns a database answering with script. The test fails if a
+// statement does not match or part of the script is left over.
+func newFakeDB(t *testing.T, script ...fakeResult) (*sql.DB, *fakeDB) {
+	f := &fakeDB{t: t, script: script}
+	db := sql.OpenDB(f)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, r := range f.script {
+			t.Errorf("statement not run: %s", r.match)
+		}
+	})
+	return db, f
+}
+
+// Statements returns what was run so far.
+func (f *fakeDB) Statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.executed...)
+}
+
+func (f *fakeDB) next(query string) (fakeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.executed = append(f.executed, query)
+	if len(f.script) == 0 {
+		f.t.Errorf("unexpected statement: %s", query)
+		return fakeResult{}, errors.New("unexpected statement")
+	}
+	r := f.script[0]
+	f.script = f.script[1:]
+	if !strings.Contains(query, r.match) {
+		f.t.Errorf("statement %q does not contain %q", query, r.match)
+		return fakeResult{}, errors.New("unexpected statement")
+	}
+	return r, r.err
+}
+
+func (f *fakeDB) record(stmt string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.executed = append(f.executed, stmt)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{f} }
+
+type fakeDriver struct{ f *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return fakeConn(d), nil }
+
+type fakeConn struct{ f *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fakeDB does not prepare statements")
+}
+func (c fakeConn) Close() error { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.f.record("BEGIN")
+	return fakeTx(c), nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	r, err := c.f.next(query)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(r.rows)), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	r, err := c.f.next(query)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: r.columns, rows: r.rows}, nil
+}
+
+type fakeTx struct{ f *fakeDB }
+
+func (tx fakeTx) Commit() error   { tx.f.record("COMMIT"); return nil }
+func (tx fakeTx) Rollback() error { tx.f.record("ROLLBACK"); return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SecretKindHeader is set on GET /secret/:key responses that carry a file
+// rather than a JSON text secret.
+const SecretKindHeader = "X-Disapyr-Secret-Kind"
+
+const (
+	// maxFormFieldSize bounds each non-file field of a multipart upload.
+	maxFormFieldSize = 1 << 20
+	// maxFilenameLength bounds the stored filename, in bytes.
+	maxFilenameLength = 255
+	// defaultFilename is used when an upload has no usable filename.
+	defaultFilename = "secret.bin"
+	// defaultContentType is used when an upload has no usable content type.
+	defaultContentType = "application/octet-stream"
+)
+
+var (
+	// ErrFileTooLarge is returned when an uploaded file exceeds the size limit
+	ErrFileTooLarge = errors.New("file too large")
+	// ErrFileEmpty is returned when an uploaded file has no content
+	ErrFileEmpty = errors.New("file is empty")
+	// ErrSecretAndFile is returned when an upload has both a text secret and
+	// a file, so that neither is silently dropped
+	ErrSecretAndFile = errors.New("both a secret and a file were sent")
+)
+
+// createRequest is the body of POST /secret, sent either as JSON or as
+// multipart/form-data carrying a file.
+type createRequest struct {
+	Type           string            `json:"type"`
+	Secret         string            `json:"secret"`
+	Fields         map[string]string `json:"fields"`
+	NotifyURL      string            `json:"notify_url"`
+	RecipientEmail string            `json:"recipient_email"`
+	NotifyEmail    string            `json:"notify_email"`
+
+	file *fileUpload
+}
+
+// fileUpload is an uploaded file, already encrypted into a temporary file
+// that Close removes.
+type fileUpload struct {
+	Filename    string
+	ContentType string
+	Size        int64
+
+	ciphertext     *os.File
+	ciphertextSize int64
+}
+
+// Reader returns a reader of the encrypted file from its start.
+func (f *fileUpload) Reader() io.Reader {
+	return io.NewSectionReader(f.ciphertext, 0, f.ciphertextSize)
+}
+
+// Ciphertext reads the whole encrypted file, for storing it in the database.
+func (f *fileUpload) Ciphertext() ([]byte, error) {
+	payload := make([]byte, f.ciphertextSize)
+	if _, err := io.ReadFull(f.Reader(), payload); err != nil {
+		return nil, fmt.Errorf("error reading encrypted file: %w", err)
+	}
+	return payload, nil
+}
+
+// Close removes the temporary file holding the ciphertext.
+func (f *fileUpload) Close() error {
+	f.ciphertext.Close()
+	return os.Remove(f.ciphertext.Name())
+}
+
+// isMultipart reports whether the request body is multipart/form-data.
+func isMultipart(c *fiber.Ctx) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	return mediaType == fiber.MIMEMultipartForm
+}
+
+// parseMultipartSecret reads a multipart POST /secret body part by part, so
+// the file is encrypted as it streams in and never held in plaintext. The
+// caller must Close the returned file, if any.
+func parseMultipartSecret(c *fiber.Ctx, limits Limits, encKey []byte) (req createRequest, err error) {
+	defer func() {
+		if err != nil && req.file != nil {
+			req.file.Close()
+			req.file = nil
+		}
+	}()
+	_, params, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	if err != nil || params["boundary"] == "" {
+		return req, errors.New("missing multipart boundary")
+	}
+
+	body, err := requestBody(c, limits.MaxBodySize)
+	if err != nil {
+		return req, err
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			if req.Secret != "" && req.file != nil {
+				return req, ErrSecretAndFile
+			}
+			return req, nil
+		}
+		if err != nil {
+			return req, fmt.Errorf("error reading multipart body: %w", err)
+		}
+
+		switch name := part.FormName(); name {
+		case "file":
+			// Browsers send an empty, unnamed part when no file was chosen.
+			if part.FileName() == "" {
+				break
+			}
+			if req.file != nil {
+				return req, errors.New("only one file may be uploaded")
+			}
+			req.file, err = encryptUpload(part, limits.MaxFileSize, encKey)
+			if err != nil {
+				return req, err
+			}
+		case "secret":
+			value, err := io.ReadAll(io.LimitReader(part, limits.MaxSecretSize+1))
+			if err != nil {
+				return req, fmt.Errorf("error reading field %s: %w", name, err)
+			}
+			if err := limits.CheckSecret(value); err != nil {
+				return req, err
+			}
+			req.Secret = string(value)
+		case "notify_url", "recipient_email", "notify_email":
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			if err != nil {
+				return req, fmt.Errorf("error reading field %s: %w", name, err)
+			}
+			if len(value) > maxFormFieldSize {
+				return req, fmt.Errorf("field %s is too large", name)
+			}
+			switch name {
+			case "notify_url":
+				req.NotifyURL = string(value)
+			case "recipient_email":
+				req.RecipientEmail = string(value)
+			case "notify_email":
+				req.NotifyEmail = string(value)
+			}
+		}
+		if _, err := io.Copy(io.Discard, part); err != nil {
+			return req, fmt.Errorf("error reading multipart body: %w", err)
+		}
+	}
+}
+
+// encryptUpload encrypts a file part into a temporary file, failing with
+// ErrFileTooLarge as soon as more than maxSize bytes have been read.
+func encryptUpload(part *multipart.Part, maxSize int64, encKey []byte) (_ *fileUpload, err error) {
+	tmp, err := os.CreateTemp("", "disapyr-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	f := &fileUpload{
+		Filename:    sanitizeFilename(part.FileName()),
+		ContentType: sanitizeContentType(part.Header.Get(fiber.HeaderContentType)),
+		ciphertext:  tmp,
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+
+	enc, err := NewPayloadEncrypter(tmp, encKey)
+	if err != nil {
+		return nil, err
+	}
+	f.Size, err = io.Copy(enc, io.LimitReader(part, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	if f.Size > maxSize {
+		return nil, ErrFileTooLarge
+	}
+	if f.Size == 0 {
+		return nil, ErrFileEmpty
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	f.ciphertextSize, err = tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// sanitizeFilename reduces an uploaded filename to a safe base name.
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == ".." {
+		return defaultFilename
+	}
+	return name
+}
+
+// sanitizeContentType normalises an uploaded content type, falling back to
+// application/octet-stream.
+func sanitizeContentType(ct string) string {
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil || !strings.Contains(mediaType, "/") {
+		return defaultContentType
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// sendFileSecret streams a consumed file secret to the client, decrypting it
+// chunk by chunk. A payload held in the blob store is deleted once it has been
+// sent.
+func sendFileSecret(c *fiber.Ctx, rec secretRecord, encKey []byte, blobs *Blobs) error {
+	var ciphertext io.ReadCloser = io.NopCloser(bytes.NewReader(rec.Payload))
+	if rec.BlobKey != "" {
+		var err error
+		ciphertext, err = blobs.open(c.UserContext(), rec.BlobKey)
+		if err != nil {
+			return HandleServerError(c, "Failed to read file secret from blob store", err)
+		}
+	}
+	plaintext, err := NewPayloadDecrypter(ciphertext, encKey)
+	if err != nil {
+		ciphertext.Close()
+		return HandleServerError(c, "Failed to decrypt file secret", err)
+	}
+	c.Set(fiber.HeaderContentType, rec.ContentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": rec.Filename}))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(SecretKindHeader, secretKindFile)
+	// fasthttp closes the body stream once it has been written, or the
+	// connection has failed.
+	c.Context().SetBodyStream(struct {
+		io.Reader
+		io.Closer
+	}{plaintext, ciphertext}, int(rec.Size))
+	return nil
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func multipartBody(t *testing.T, fields map[string]string, filename, contentType string, content []byte) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		assert.NoError(t, w.WriteField(k, v))
+	}
+	if content != nil || filename != "" {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+		if contentType != "" {
+			h.Set("Content-Type", contentType)
+		}
+		part, err := w.CreatePart(h)
+		assert.NoError(t, err)
+		_, _ = part.Write(content)
+	}
+	assert.NoError(t, w.Close())
+	return &buf, w.FormDataContentType()
+}
+
+func TestParseMultipartSecret(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
+	app.Post("/", func(c *fiber.Ctx) error {
+		req, err := parseMultipartSecret(c, Limits{MaxSecretSize: 16, MaxFileSize: 1024, MaxBodySize: 4096}, testEncKey)
+		switch {
+		case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrSecretTooLarge), errors.Is(err, ErrBodyTooLarge):
+			return c.Status(fiber.StatusRequestEntityTooLarge).SendString(err.Error())
+		case err != nil:
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		out := fiber.Map{"secret": req.Secret, "notify_email": req.NotifyEmail}
+		if req.file != nil {
+			defer req.file.Close()
+			ciphertext, err := req.file.Ciphertext()
+			assert.NoError(t, err)
+			plain, err := decryptPayload(ciphertext, testEncKey)
+			assert.NoError(t, err)
+			out["filename"] = req.file.Filename
+			out["content_type"] = req.file.ContentType
+			out["size"] = req.file.Size
+			out["content"] = string(plain)
+		}
+		return c.JSON(out)
+	})
+
+	post := func(body io.Reader, contentType string) (int, map[string]any) {
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	t.Run("file with fields", func(t *testing.T) {
+		body, ct := multipartBody(t, map[string]string{"notify_email": "alice@example.com"}, "../../home/me/.kube/config", "application/yaml", []byte("apiVersion: v1\n"))
+		status, out := post(body, ct)
+		assert.Equal(t, fiber.StatusOK, status)
+		assert.Equal(t, "config", out["filename"])
+		assert.Equal(t, "application/yaml", out["content_type"])
+		assert.Equal(t, "apiVersion: v1\n", out["content"])
+		assert.EqualValues(t, 15, out["size"])
+		assert.Equal(t, "alice@example.com", out["notify_email"])
+	})
+
+	t.Run("no file chosen", func(t *testing.T) {
+		body, ct := multipartBody(t, map[string]string{"secret": "text"}, "", "", []byte{})
+		status, out := post(body, ct)
+		assert.Equal(t, fiber.StatusOK, status)
+		assert.Equal(t, "text", out["secret"])
+		assert.Nil(t, out["filename"])
+	})
+
+	t.Run("secret and file", func(t *testing.T) {
+		body, ct := multipartBody(t, map[string]string{"secret": "text"}, "notes.txt", "text/plain", []byte("file"))
+		status, _ := post(body, ct)
+		assert.Equal(t, fiber.StatusBadRequest, status)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		body, ct := multipartBody(t, nil, "big.bin", "", bytes.Repeat([]byte("x"), 1025))
+		status, _ := post(body, ct)
+		assert.Equal(t, fiber.StatusRequestEntityTooLarge, status)
+	})
+
+	t.Run("secret too large", func(t *testing.T) {
+		body, ct := multipartBody(t, map[string]string{"secret": strings.Repeat("x", 17)}, "", "", []byte{})
+		status, _ := post(body, ct)
+		assert.Equal(t, fiber.StatusRequestEntityTooLarge, status)
+	})
+
+	t.Run("secret not UTF-8", func(t *testing.T) {
+		body, ct := multipartBody(t, map[string]string{"secret": "caf\xe9"}, "", "", []byte{})
+		status, _ := post(body, ct)
+		assert.Equal(t, fiber.StatusBadRequest, status)
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		body, ct := multipartBody(t, map[string]string{"notify_email": strings.Repeat("x", 5000)}, "", "", []byte{})
+		status, _ := post(body, ct)
+		assert.Equal(t, fiber.StatusRequestEntityTooLarge, status)
+	})
+
+	// Encrypted uploads are spooled to disk and removed, even on errors.
+	spooled, err := os.ReadDir(tmp)
+	assert.NoError(t, err)
+	assert.Empty(t, spooled)
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "id_ed25519", sanitizeFilename("id_ed25519"))
+	assert.Equal(t, "passwd", sanitizeFilename("../../etc/passwd"))
+	assert.Equal(t, "cert.pem", sanitizeFilename(`C:\Users\me\cert.pem`))
+	assert.Equal(t, "ab", sanitizeFilename("a\r\n\"b"))
+	assert.Equal(t, defaultFilename, sanitizeFilename(".."))
+	assert.Equal(t, maxFilenameLength-1, len(sanitizeFilename(strings.Repeat("é", 200))))
+}
+
+func TestSanitizeContentType(t *testing.T) {
+	assert.Equal(t, "application/x-pem-file", sanitizeContentType("application/x-pem-file"))
+	assert.Equal(t, "text/plain; charset=utf-8", sanitizeContentType("text/plain; charset=utf-8"))
+	assert.Equal(t, defaultContentType, sanitizeContentType(""))
+	assert.Equal(t, defaultContentType, sanitizeContentType("garbage"))
+}
+
+func TestSendFileSecret(t *testing.T) {
+	content := bytes.Repeat([]byte("-----BEGIN KEY-----\n"), 5000)
+	rec := secretRecord{
+		Kind:        secretKindFile,
+		Filename:    "id_rsa",
+		ContentType: "application/x-pem-file",
+		Size:        int64(len(content)),
+		Payload:     encryptPayload(t, content),
+	}
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error { return sendFileSecret(c, rec, testEncKey, nil) })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, secretKindFile, resp.Header.Get(SecretKindHeader))
+	assert.Equal(t, `attachment; filename=id_rsa`, resp.Header.Get("Content-Disposition"))
+	assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+	got, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, content, got)
+}
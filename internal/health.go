@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"crypto/aes"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// healthCheckTimeout bounds each readiness check so a hung dependency cannot
+// hold the probe open past the kubelet's own timeout.
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck is a named readiness probe for a single dependency
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a single HealthCheck
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// HealthResponse is returned by the health endpoints
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// RegisterHealthRoutes adds unauthenticated /healthz and /readyz endpoints.
+// /healthz only reports that the process is serving requests; /readyz runs
+// every check and returns 503 if any of them fail.
+func RegisterHealthRoutes(app *fiber.App, checks []HealthCheck) {
+	app.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.JSON(HealthResponse{Status: "ok"})
+	})
+
+	app.Get("/readyz", func(c *fiber.Ctx) error {
+		resp := RunHealthChecks(c.UserContext(), checks)
+		if resp.Status != "ok" {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(resp)
+		}
+		return c.JSON(resp)
+	})
+}
+
+// RunHealthChecks runs the checks concurrently and collects their results.
+func RunHealthChecks(ctx context.Context, checks []HealthCheck) HealthResponse {
+	type named struct {
+		name   string
+		result CheckResult
+	}
+	results := make(chan named, len(checks))
+	for _, hc := range checks {
+		go func(hc HealthCheck) {
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := hc.Check(checkCtx)
+			result := CheckResult{Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			}
+			results <- named{hc.Name, result}
+		}(hc)
+	}
+
+	resp := HealthResponse{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	for range checks {
+		r := <-results
+		resp.Checks[r.name] = r.result
+		if r.result.Status != "ok" {
+			resp.Status = "unavailable"
+		}
+	}
+	return resp
+}
+
+// apiHealthChecks returns the readiness checks for the API server.
+func apiHealthChecks(db *sql.DB, jwks *JWKSCache, cfg *Config) []HealthCheck {
+	return []HealthCheck{
+		{Name: "database", Check: db.PingContext},
+		{Name: "migrations", Check: func(ctx context.Context) error {
+			version, err := SchemaVersion(ctx, db)
+			if err != nil {
+				return err
+			}
+			if latest := LatestSchemaVersion(); version != latest {
+				return fmt.Errorf("schema version %d, expected %d", version, latest)
+			}
+			return nil
+		}},
+		{Name: "jwks", Check: jwks.Ready},
+		{Name: "encryption_key", Check: func(ctx context.Context) error {
+			if _, err := aes.NewCipher([]byte(cfg.Security.EncKey)); err != nil {
+				return fmt.Errorf("encryption key not usable: %w", err)
+			}
+			return nil
+		}},
+	}
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthRoutes(t *testing.T) {
+	healthy := HealthCheck{Name: "database", Check: func(ctx context.Context) error { return nil }}
+	failing := HealthCheck{Name: "jwks", Check: func(ctx context.Context) error { return errors.New("unreachable") }}
+
+	t.Run("liveness ignores checks", func(t *testing.T) {
+		app := fiber.New()
+		RegisterHealthRoutes(app, []HealthCheck{failing})
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/healthz", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("ready when all checks pass", func(t *testing.T) {
+		app := fiber.New()
+		RegisterHealthRoutes(app, []HealthCheck{healthy})
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/readyz", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		var body HealthResponse
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "ok", body.Status)
+		assert.Equal(t, "ok", body.Checks["database"].Status)
+	})
+
+	t.Run("not ready when a check fails", func(t *testing.T) {
+		app := fiber.New()
+		RegisterHealthRoutes(app, []HealthCheck{healthy, failing})
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/readyz", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+
+		var body HealthResponse
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "unavailable", body.Status)
+		assert.Equal(t, "error", body.Checks["jwks"].Status)
+		assert.Equal(t, "unreachable", body.Checks["jwks"].Error)
+	})
+}
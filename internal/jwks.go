@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// minJWKSRefreshInterval bounds how often an unknown key ID can force a refetch,
+// so that tokens with random kid headers cannot be used to hammer Auth0.
+const minJWKSRefreshInterval = 30 * time.Second
+
+// JWKSCache fetches the Auth0 JSON Web Key Set and keeps the RSA public keys in
+// memory until the TTL expires.
+type JWKSCache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWKSCache creates a cache for the JWKS published by the given Auth0 domain
+func NewJWKSCache(domain string, ttl time.Duration) *JWKSCache {
+	return &JWKSCache{
+		url:    fmt.Sprintf("https://%s/.well-known/jwks.json", domain),
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Key returns the public key matching kid, refreshing the key set if it is
+// stale or does not contain the key.
+func (j *JWKSCache) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	j.mu.RLock()
+	key, ok := j.keys[kid]
+	age := time.Since(j.fetchedAt)
+	j.mu.RUnlock()
+
+	if ok && age < j.ttl {
+		return key, nil
+	}
+	if ok || age >= minJWKSRefreshInterval {
+		if err := j.Refresh(ctx); err != nil {
+			// Keep serving a known key if Auth0 is briefly unreachable.
+			if ok {
+				log.Warn("Using stale JWKS key after refresh failure", "error", err)
+				return key, nil
+			}
+			return nil, err
+		}
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	log.Warn("no matching key found")
+	return nil, fmt.Errorf("no matching key found")
+}
+
+// Ready reports whether usable keys are cached, fetching them if necessary.
+func (j *JWKSCache) Ready(ctx context.Context) error {
+	j.mu.RLock()
+	fresh := len(j.keys) > 0 && time.Since(j.fetchedAt) < j.ttl
+	j.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+	return j.Refresh(ctx)
+}
+
+// Refresh fetches the key set and replaces the cached keys.
+func (j *JWKSCache) Refresh(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "jwks.fetch", attribute.String("jwks.url", j.url))
+	defer func() { endSpan(span, err) }()
+
+	keys, err := j.fetch(ctx)
+	if err != nil {
+		jwksFetchErrorsTotal.Inc()
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *JWKSCache) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+
+	resp, err := j.client.Do(req)
+	if err != nil {
+		log.Errorf("failed to fetch JWKS: %v", err)
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check if the status code is OK.
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		log.Errorf("failed to fetch JWKS, status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("failed to fetch JWKS, status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		log.Errorf("failed to decode JWKS: %v", err)
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		var k struct {
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		}
+		if err := json.Unmarshal(key, &k); err != nil {
+			continue
+		}
+		nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			log.Errorf("failed to decode N: %v", err)
+			continue
+		}
+		eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			log.Errorf("failed to decode E: %v", err)
+			continue
+		}
+		e := 0
+		for _, b := range eBytes {
+			e = e*256 + int(b)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nBytes),
+			E: e,
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS contains no usable keys")
+	}
+	return keys, nil
+}
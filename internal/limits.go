@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LimitsPath is where the API publishes its size limits.
+const LimitsPath = "/v1/limits"
+
+var (
+	// ErrBodyTooLarge is returned when a request body exceeds the body size limit
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrSecretTooLarge is returned when a text secret exceeds the secret size limit
+	ErrSecretTooLarge = errors.New("secret too large")
+	// ErrSecretNotUTF8 is returned when a text secret is not valid UTF-8
+	ErrSecretNotUTF8 = errors.New("secret is not valid UTF-8")
+)
+
+// Limits is the body of GET /v1/limits, letting clients check a secret before
+// uploading it.
+type Limits struct {
+	MaxSecretSize int64  `json:"max_secret_size"`
+	MaxFileSize   int64  `json:"max_file_size"`
+	MaxBodySize   int64  `json:"max_body_size"`
+	TextEncoding  string `json:"text_encoding"`
+}
+
+// NewLimits returns the limits published for cfg.
+func NewLimits(cfg LimitsConfig) Limits {
+	return Limits{
+		MaxSecretSize: cfg.MaxSecretSize,
+		MaxFileSize:   cfg.MaxFileSize,
+		MaxBodySize:   cfg.MaxBodySize,
+		TextEncoding:  "utf-8",
+	}
+}
+
+// CheckSecret reports whether a text secret is within the limits.
+func (l Limits) CheckSecret(secret []byte) error {
+	if int64(len(secret)) > l.MaxSecretSize {
+		return ErrSecretTooLarge
+	}
+	if !utf8.Valid(secret) {
+		return ErrSecretNotUTF8
+	}
+	return nil
+}
+
+// CheckFile reports whether a file of the given size is within the limits.
+func (l Limits) CheckFile(size int64) error {
+	if size > l.MaxFileSize {
+		return ErrFileTooLarge
+	}
+	if size == 0 {
+		return ErrFileEmpty
+	}
+	return nil
+}
+
+// registerLimitsRoute publishes the size limits. They are not secret, so the
+// route is unauthenticated.
+func registerLimitsRoute(app *fiber.App, limits Limits) {
+	app.Get(LimitsPath, func(c *fiber.Ctx) error {
+		return c.JSON(limits)
+	})
+}
+
+// limitedReader fails with ErrBodyTooLarge once more than n bytes have been
+// read.
+type limitedReader struct {
+	r       io.Reader
+	n       int64
+	onLimit func()
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		if l.onLimit != nil {
+			l.onLimit()
+		}
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// requestBody returns the request body, failing with ErrBodyTooLarge as soon
+// as it is known to exceed maxSize. The server streams large bodies instead of
+// rejecting them, so the limit is enforced here. The rest of an oversized body
+// is never read, so the connection is closed after the response.
+func requestBody(c *fiber.Ctx, maxSize int64) (io.Reader, error) {
+	if int64(c.Request().Header.ContentLength()) > maxSize {
+		c.Context().SetConnectionClose()
+		return nil, ErrBodyTooLarge
+	}
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+	return &limitedReader{r: body, n: maxSize, onLimit: c.Context().SetConnectionClose}, nil
+}
+
+// parseJSONSecret reads a JSON POST /secret body. The raw body is checked for
+// UTF-8 before decoding, as encoding/json would silently replace invalid
+// bytes.
+func parseJSONSecret(c *fiber.Ctx, limits Limits) (createRequest, error) {
+	var req createRequest
+	body, err := requestBody(c, limits.MaxBodySize)
+	if err != nil {
+		return req, err
+	}
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return req, err
+	}
+	if !utf8.Valid(raw) {
+		return req, ErrSecretNotUTF8
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return req, fmt.Errorf("error decoding JSON body: %w", err)
+	}
+	if req.Secret != "" {
+		return req, limits.CheckSecret([]byte(req.Secret))
+	}
+	return req, nil
+}
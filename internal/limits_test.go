@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLimits = Limits{MaxSecretSize: 8, MaxFileSize: 64, MaxBodySize: 128, TextEncoding: "utf-8"}
+
+func TestLimitsRoute(t *testing.T) {
+	app := fiber.New()
+	registerLimitsRoute(app, NewLimits(LimitsConfig{MaxSecretSize: 1, MaxFileSize: 2, MaxBodySize: 3}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", LimitsPath, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var limits Limits
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&limits))
+	assert.Equal(t, Limits{MaxSecretSize: 1, MaxFileSize: 2, MaxBodySize: 3, TextEncoding: "utf-8"}, limits)
+}
+
+func TestLimitsCheck(t *testing.T) {
+	assert.NoError(t, testLimits.CheckSecret([]byte("naïve")))
+	assert.ErrorIs(t, testLimits.CheckSecret([]byte("123456789")), ErrSecretTooLarge)
+	assert.ErrorIs(t, testLimits.CheckSecret([]byte{0xff, 0xfe}), ErrSecretNotUTF8)
+
+	assert.NoError(t, testLimits.CheckFile(64))
+	assert.ErrorIs(t, testLimits.CheckFile(65), ErrFileTooLarge)
+	assert.ErrorIs(t, testLimits.CheckFile(0), ErrFileEmpty)
+}
+
+func TestLimitedReader(t *testing.T) {
+	b, err := io.ReadAll(&limitedReader{r: strings.NewReader("12345"), n: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(b))
+
+	_, err = io.ReadAll(&limitedReader{r: strings.NewReader("123456"), n: 5})
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestParseJSONSecret(t *testing.T) {
+	app := fiber.New(fiber.Config{StreamRequestBody: true, BodyLimit: 64})
+	app.Post("/", func(c *fiber.Ctx) error {
+		req, err := parseJSONSecret(c, testLimits)
+		switch {
+		case errors.Is(err, ErrBodyTooLarge), errors.Is(err, ErrSecretTooLarge):
+			return HandlePayloadTooLargeError(c, "too large", err)
+		case err != nil:
+			return HandleValidationError(c, "invalid", err)
+		}
+		return c.SendString(req.Secret)
+	})
+
+	post := func(body string) (int, string) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	status, body := post(`{"secret":"hunter2"}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "hunter2", body)
+
+	status, body = post(`{"secret":"hunter2hunter2"}`)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, status)
+	assert.JSONEq(t, `{"error":"Payload too large","category":"payload_too_large"}`, body)
+
+	// Bodies above the server's buffer limit are streamed, so the limit is
+	// enforced while reading.
+	status, _ = post(`{"secret":"x","notify_url":"` + strings.Repeat("x", 200) + `"}`)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, status)
+
+	status, _ = post("{\"secret\":\"caf\xe9\"}")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	status, _ = post(`not json`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the correlation ID between clients, the UI and the API
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDLocal is the fiber.Ctx locals key holding the request ID
+const requestIDLocal = "requestID"
+
+// requestIDContextKey stores the request ID in the request's user context
+type requestIDContextKey struct{}
+
+// validRequestID limits accepted inbound IDs so that callers cannot inject
+// arbitrary content into logs through the header.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// sensitiveFieldPattern matches JSON log fields whose values must never be
+// written: secret payloads, secret keys, tokens and credentials.
+var sensitiveFieldPattern = regexp.MustCompile(`"(secret|key|token|access_token|id_token|refresh_token|authorization|password|client_secret|enc_key)":"(?:[^"\\]|\\.)*"`)
+
+// sensitiveTextPatterns catch credentials that end up in free-form messages.
+var sensitiveTextPatterns = []*regexp.Regexp{
+	// JSON Web Tokens
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	// Authorization header values
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`),
+}
+
+// ParseLogLevel converts a configured level name into a log.Level
+func ParseLogLevel(level string) (log.Level, error) {
+	l, err := log.ParseLevel(level)
+	if err != nil {
+		return l, fmt.Errorf("unknown log level %q", level)
+	}
+	return l, nil
+}
+
+// NewLogger creates the JSON logger shared by the API and UI servers. All
+// output passes through the redaction layer.
+func NewLogger() *log.Logger {
+	return log.NewWithOptions(NewRedactingWriter(os.Stderr), log.Options{
+		ReportCaller:    true,
+		ReportTimestamp: true,
+		TimeFormat:      time.RFC3339Nano,
+		Level:           log.DebugLevel,
+		Formatter:       log.JSONFormatter,
+	})
+}
+
+// ApplyLogConfig adjusts the logger to match the loaded configuration
+func ApplyLogConfig(logger *log.Logger, cfg LogConfig) {
+	if level, err := ParseLogLevel(cfg.Level); err == nil {
+		logger.SetLevel(level)
+	}
+}
+
+// redactingWriter masks sensitive values in each log entry before it is
+// written. The logger emits one entry per Write call.
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter wraps w so that secret keys, payloads, tokens and
+// credentials are replaced with a placeholder.
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return &redactingWriter{w: w}
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write(Redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Redact returns p with every sensitive field value and credential masked.
+func Redact(p []byte) []byte {
+	out := sensitiveFieldPattern.ReplaceAll(p, []byte(`"$1":"`+redacted+`"`))
+	for _, re := range sensitiveTextPatterns {
+		out = re.ReplaceAll(out, []byte(redacted))
+	}
+	return out
+}
+
+// KeyFingerprint returns a short, non-reversible identifier for a secret key
+// that is safe to log and correlate.
+func KeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// RequestIDMiddleware assigns each request an ID, reusing a well-formed
+// X-Request-ID from the caller, and echoes it on the response.
+func RequestIDMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Locals(requestIDLocal, id)
+		c.SetUserContext(context.WithValue(c.UserContext(), requestIDContextKey{}, id))
+		c.Set(RequestIDHeader, id)
+		return c.Next()
+	}
+}
+
+// RequestID returns the ID assigned to the current request, if any.
+func RequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDLocal).(string)
+	return id
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any, so it
+// can be forwarded on outbound calls.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// AccessLogMiddleware writes one structured line per request. The route
+// pattern is logged instead of the raw path so secret keys are never recorded.
+func AccessLogMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		}
+
+		fields := []interface{}{
+			"request_id", RequestID(c),
+			"method", c.Method(),
+			"route", c.Route().Path,
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"bytes", len(c.Response().Body()),
+			"ip", c.IP(),
+			"user_agent", c.Get(fiber.HeaderUserAgent),
+		}
+		if sc := trace.SpanContextFromContext(c.UserContext()); sc.IsValid() {
+			fields = append(fields, "trace_id", sc.TraceID().String())
+		}
+		log.Info("access", fields...)
+		return err
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		hidden string
+	}{
+		{"secret field", `{"msg":"stored","secret":"hunter2"}`, "hunter2"},
+		{"key field with escapes", `{"key":"abc\"def","level":"info"}`, `abc\"def`},
+		{"jwt in message", `{"msg":"got eyJhbGciOi.eyJzdWIiOiIx.c2lnbmF0dXJl"}`, "eyJzdWIiOiIx"},
+		{"bearer header", `{"msg":"Authorization: Bearer abc.def-123"}`, "abc.def-123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := string(Redact([]byte(tt.input)))
+			assert.NotContains(t, out, tt.hidden)
+			assert.Contains(t, out, redacted)
+		})
+	}
+
+	t.Run("unrelated fields are kept", func(t *testing.T) {
+		in := `{"msg":"access","route":"/secret/:key","status":200}`
+		assert.Equal(t, in, string(Redact([]byte(in))))
+	})
+}
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewWithOptions(NewRedactingWriter(&buf), log.Options{Formatter: log.JSONFormatter})
+	previous := log.Default()
+	log.SetDefault(logger)
+	t.Cleanup(func() { log.SetDefault(previous) })
+
+	app := fiber.New()
+	app.Use(RequestIDMiddleware())
+	app.Use(AccessLogMiddleware())
+	app.Get("/secret/:key", func(c *fiber.Ctx) error {
+		return HandleNotFoundError(c, "Secret not found", nil)
+	})
+
+	t.Run("propagates a caller supplied ID", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/secret/AbCdEf123", nil)
+		req.Header.Set(RequestIDHeader, "req-42")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "req-42", resp.Header.Get(RequestIDHeader))
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		assert.Len(t, lines, 2)
+		for _, line := range lines {
+			assert.Contains(t, line, `"request_id":"req-42"`)
+			assert.NotContains(t, line, "AbCdEf123")
+		}
+		assert.Contains(t, lines[1], `"route":"/secret/:key"`)
+	})
+
+	t.Run("replaces a malformed ID", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/secret/x", nil)
+		req.Header.Set(RequestIDHeader, "bad id\nwith newline")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		id := resp.Header.Get(RequestIDHeader)
+		assert.NotEmpty(t, id)
+		assert.NotContains(t, id, " ")
+	})
+}
@@ -0,0 +1,421 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//go:embed mail_templates/*.tmpl
+var mailTemplateFS embed.FS
+
+// Mail templates. Each has a .txt.tmpl part, which also defines "subject",
+// and a .html.tmpl part under mail_templates.
+const (
+	MailSecretLink   = "secret_link"
+	MailSecretOpened = "secret_opened"
+)
+
+var mailTemplateNames = []string{MailSecretLink, MailSecretOpened}
+
+var mailsTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "mails_total",
+	Help:      "Email delivery attempts, by outcome.",
+}, []string{"outcome"})
+
+// MailData is the data available to mail templates.
+type MailData struct {
+	Link           string    `json:"link,omitempty"`
+	KeyFingerprint string    `json:"key_fingerprint,omitempty"`
+	OpenedAt       time.Time `json:"opened_at,omitempty"`
+}
+
+// OutgoingMail is an email waiting in the outbox
+type OutgoingMail struct {
+	To       string
+	Template string
+	Data     MailData
+}
+
+// ValidateEmail accepts a bare address such as "alice@example.com". Display
+// names are rejected so that nothing but the address reaches mail headers.
+func ValidateEmail(raw string) error {
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Name != "" || addr.Address != raw {
+		return fmt.Errorf("invalid email address %q", raw)
+	}
+	return nil
+}
+
+// enqueueMail adds m to the mail outbox inside tx. The template data holds
+// one-time links, so it is sealed with encKey like a secret payload.
+func enqueueMail(ctx context.Context, tx *sql.Tx, m OutgoingMail, encKey []byte) error {
+	data, err := sealMailData(m.Data, encKey)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO mail_outbox(recipient, template, data) VALUES($1, $2, $3)", m.To, m.Template, data); err != nil {
+		return fmt.Errorf("failed to queue mail: %w", err)
+	}
+	return nil
+}
+
+// sealMailData encrypts data for the outbox.
+func sealMailData(data MailData, encKey []byte) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("error encoding mail data: %w", err)
+	}
+	sealed, err := sealText(string(b), encKey)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting mail data: %w", err)
+	}
+	return sealed, nil
+}
+
+// openMailData reverses sealMailData.
+func openMailData(raw string, encKey []byte) (MailData, error) {
+	var data MailData
+	b, err := openText(raw, encKey)
+	if err != nil {
+		return data, fmt.Errorf("error decrypting mail data: %w", err)
+	}
+	if err := json.Unmarshal([]byte(b), &data); err != nil {
+		return data, fmt.Errorf("error decoding mail data: %w", err)
+	}
+	return data, nil
+}
+
+// Mailer renders and sends queued emails from the mail_outbox table, over SMTP
+// or, in dev mode, by writing .eml files to a directory.
+type Mailer struct {
+	db     *sql.DB
+	cfg    MailConfig
+	encKey []byte
+	lease  time.Duration
+	text   map[string]*texttemplate.Template
+	html   map[string]*htmltemplate.Template
+}
+
+// NewMailer parses the embedded templates and creates a mailer for db. Queued
+// template data is decrypted with encKey.
+func NewMailer(db *sql.DB, cfg MailConfig, encKey []byte) (*Mailer, error) {
+	m := &Mailer{
+		db:     db,
+		cfg:    cfg,
+		encKey: encKey,
+		// As with notifications, a claimed batch is retried if it is not
+		// marked within the time it takes to send it.
+		lease: time.Duration(cfg.BatchSize)*cfg.Timeout + time.Minute,
+		text:  map[string]*texttemplate.Template{},
+		html:  map[string]*htmltemplate.Template{},
+	}
+	for _, name := range mailTemplateNames {
+		text, err := texttemplate.ParseFS(mailTemplateFS, "mail_templates/"+name+".txt.tmpl")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s text template: %w", name, err)
+		}
+		html, err := htmltemplate.ParseFS(mailTemplateFS, "mail_templates/"+name+".html.tmpl")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s HTML template: %w", name, err)
+		}
+		m.text[name], m.html[name] = text, html
+	}
+	if cfg.DevDir != "" {
+		if err := os.MkdirAll(cfg.DevDir, 0o700); err != nil {
+			return nil, fmt.Errorf("error creating mail dev directory: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (m *Mailer) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.deliverDue(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Failed to process mail outbox", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pendingMail is an outbox entry claimed for delivery.
+type pendingMail struct {
+	id       int64
+	mail     OutgoingMail
+	attempts int
+	// dataErr is set if the mail data could not be opened; such mails are
+	// never sent.
+	dataErr error
+}
+
+// deliverDue claims the mails whose retry time has passed, sends them and
+// records the outcome of each. As with the notification outbox, the claim is
+// committed before anything is sent. Sent mails are deleted and abandoned
+// ones have their data cleared, so no link outlives its delivery.
+func (m *Mailer) deliverDue(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "mail.deliver_due")
+	defer func() { endSpan(span, err) }()
+
+	due, err := m.claimDue(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range due {
+		sendErr := p.dataErr
+		if sendErr == nil {
+			sendErr = m.Send(p.mail)
+		}
+		if err := m.markAttempt(ctx, p, sendErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimDue leases up to BatchSize due mails to this replica by pushing their
+// retry time past the lease.
+func (m *Mailer) claimDue(ctx context.Context) ([]pendingMail, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		UPDATE mail_outbox SET next_attempt_at = now() + $2 * interval '1 second'
+		WHERE id IN (
+			SELECT id FROM mail_outbox
+			WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= now()
+			ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, recipient, template, data, attempts`, m.cfg.BatchSize, m.lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim mails: %w", err)
+	}
+	defer rows.Close()
+	var due []pendingMail
+	for rows.Next() {
+		var p pendingMail
+		var data string
+		if err := rows.Scan(&p.id, &p.mail.To, &p.mail.Template, &data, &p.attempts); err != nil {
+			return nil, fmt.Errorf("failed to read mail outbox: %w", err)
+		}
+		if p.mail.Data, err = openMailData(data, m.encKey); err != nil {
+			p.dataErr = permanentMailError{err}
+		}
+		due = append(due, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mail outbox: %w", err)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].id < due[j].id })
+	return due, nil
+}
+
+// markAttempt records the outcome of sending p: deleted once sent, cleared
+// and marked failed when abandoned, or due again after a backoff.
+func (m *Mailer) markAttempt(ctx context.Context, p pendingMail, sendErr error) error {
+	attempts := p.attempts + 1
+	var err error
+	switch {
+	case sendErr == nil:
+		mailsTotal.WithLabelValues(outboxDelivered).Inc()
+		_, err = m.db.ExecContext(ctx, "DELETE FROM mail_outbox WHERE id = $1", p.id)
+	case isPermanentMailError(sendErr) || attempts >= m.cfg.MaxAttempts:
+		mailsTotal.WithLabelValues(outboxFailed).Inc()
+		log.Warn("Giving up on mail", "id", p.id, "template", p.mail.Template, "attempts", attempts, "error", sendErr)
+		_, err = m.db.ExecContext(ctx, "UPDATE mail_outbox SET attempts = $1, failed_at = now(), data = '', last_error = $2 WHERE id = $3", attempts, sendErr.Error(), p.id)
+	default:
+		mailsTotal.WithLabelValues(outboxRetried).Inc()
+		_, err = m.db.ExecContext(ctx, "UPDATE mail_outbox SET attempts = $1, next_attempt_at = $2, last_error = $3 WHERE id = $4", attempts, time.Now().Add(outboxBackoff(attempts)), sendErr.Error(), p.id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update mail %d: %w", p.id, err)
+	}
+	return nil
+}
+
+// Send renders and delivers a single mail immediately.
+func (m *Mailer) Send(om OutgoingMail) error {
+	msg, err := m.message(om, time.Now())
+	if err != nil {
+		return err
+	}
+	if m.cfg.DevDir != "" {
+		return m.writeDevMail(msg)
+	}
+	return m.sendSMTP(om.To, msg)
+}
+
+// message renders om as a multipart/alternative MIME message.
+func (m *Mailer) message(om OutgoingMail, now time.Time) ([]byte, error) {
+	textTmpl, htmlTmpl := m.text[om.Template], m.html[om.Template]
+	if textTmpl == nil || htmlTmpl == nil {
+		return nil, permanentMailError{fmt.Errorf("unknown mail template %q", om.Template)}
+	}
+	var subject, text, html bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", om.Data); err != nil {
+		return nil, permanentMailError{fmt.Errorf("error rendering subject for %s: %w", om.Template, err)}
+	}
+	if err := textTmpl.Execute(&text, om.Data); err != nil {
+		return nil, permanentMailError{fmt.Errorf("error rendering %s text: %w", om.Template, err)}
+	}
+	if err := htmlTmpl.Execute(&html, om.Data); err != nil {
+		return nil, permanentMailError{fmt.Errorf("error rendering %s HTML: %w", om.Template, err)}
+	}
+
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return nil, permanentMailError{fmt.Errorf("invalid from address: %w", err)}
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", om.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", uuid.New(), domain)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", body.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	msg.Write(buf.Bytes())
+	return msg.Bytes(), nil
+}
+
+// errNoSTARTTLS is returned when the relay does not offer STARTTLS and
+// plaintext delivery is not allowed.
+var errNoSTARTTLS = errors.New("SMTP server does not offer STARTTLS")
+
+// sendSMTP delivers msg to the configured relay over STARTTLS. Mail is only
+// sent in plaintext to a server without STARTTLS when AllowPlaintext is set.
+func (m *Mailer) sendSMTP(to string, msg []byte) error {
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return permanentMailError{fmt.Errorf("invalid from address: %w", err)}
+	}
+
+	addr := net.JoinHostPort(m.cfg.SMTPHost, strconv.Itoa(m.cfg.SMTPPort))
+	conn, err := net.DialTimeout("tcp", addr, m.cfg.Timeout)
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(m.cfg.Timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, m.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error starting SMTP session: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.cfg.SMTPHost, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	} else if !m.cfg.AllowPlaintext {
+		return errNoSTARTTLS
+	}
+	if m.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.SMTPHost)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// writeDevMail stores msg as an .eml file instead of sending it.
+func (m *Mailer) writeDevMail(msg []byte) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), uuid.New())
+	if err := os.WriteFile(filepath.Join(m.cfg.DevDir, name), msg, 0o600); err != nil {
+		return fmt.Errorf("error writing dev mail: %w", err)
+	}
+	log.Info("Wrote mail to dev directory", "file", name)
+	return nil
+}
+
+// permanentMailError marks failures that retrying cannot fix
+type permanentMailError struct{ error }
+
+func (e permanentMailError) Unwrap() error { return e.error }
+
+// isPermanentMailError reports whether err should not be retried: rendering
+// failures and 5xx SMTP replies such as an unknown mailbox.
+func isPermanentMailError(err error) bool {
+	if errors.As(err, &permanentMailError{}) {
+		return true
+	}
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr) && tpErr.Code >= 500
+}
@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMailer(t *testing.T, cfg MailConfig) *Mailer {
+	if cfg.From == "" {
+		cfg.From = "Disapyr <noreply@example.com>"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	m, err := NewMailer(nil, cfg, testEncKey)
+	assert.NoError(t, err)
+	return m
+}
+
+func TestValidateEmail(t *testing.T) {
+	assert.NoError(t, ValidateEmail("alice@example.com"))
+	assert.Error(t, ValidateEmail("Alice <alice@example.com>"))
+	assert.Error(t, ValidateEmail("alice@example.com\r\nBcc: eve@example.com"))
+	assert.Error(t, ValidateEmail("not-an-address"))
+}
+
+func TestMailerMessage(t *testing.T) {
+	m := newTestMailer(t, MailConfig{})
+	link := "https://disapyr.example.com/secret/abc?x=1&y=2"
+	raw, err := m.message(OutgoingMail{To: "bob@example.com", Template: MailSecretLink, Data: MailData{Link: link}}, time.Now())
+	assert.NoError(t, err)
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.com", msg.Header.Get("To"))
+	assert.Equal(t, "A secret has been shared with you", msg.Header.Get("Subject"))
+	assert.True(t, strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	parts := map[string]string{}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(quotedprintable.NewReader(p))
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[ct] = string(body)
+	}
+	assert.Contains(t, parts["text/plain"], link)
+	assert.NotContains(t, parts["text/plain"], "subject")
+	assert.Contains(t, parts["text/html"], `href="https://disapyr.example.com/secret/abc?x=1&amp;y=2"`)
+
+	t.Run("opened notification", func(t *testing.T) {
+		opened := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+		raw, err := m.message(OutgoingMail{To: "alice@example.com", Template: MailSecretOpened, Data: MailData{KeyFingerprint: "abcdef012345", OpenedAt: opened}}, time.Now())
+		assert.NoError(t, err)
+		msg, _ := mail.ReadMessage(strings.NewReader(string(raw)))
+		assert.Equal(t, "Your secret has been opened", msg.Header.Get("Subject"))
+		assert.Contains(t, string(raw), "2025-01-02 03:04:05 UTC")
+	})
+
+	t.Run("unknown template is permanent", func(t *testing.T) {
+		_, err := m.message(OutgoingMail{To: "bob@example.com", Template: "nope"}, time.Now())
+		assert.True(t, isPermanentMailError(err))
+	})
+}
+
+func TestMailerDevDir(t *testing.T) {
+	dir := t.TempDir() + "/mail"
+	m := newTestMailer(t, MailConfig{DevDir: dir})
+	assert.NoError(t, m.Send(OutgoingMail{To: "bob@example.com", Template: MailSecretLink, Data: MailData{Link: "https://x/secret/k"}}))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.True(t, strings.HasSuffix(entries[0].Name(), ".eml"))
+	info, _ := entries[0].Info()
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+// fakeSMTP accepts a single SMTP session and returns the DATA it received. If
+// rcptCode is set, RCPT TO is answered with it.
+func fakeSMTP(t *testing.T, rcptCode int) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+			switch {
+			case cmd == "EHLO" || cmd == "HELO":
+				tp.PrintfLine("250 localhost")
+			case cmd == "RCPT" && rcptCode != 0:
+				tp.PrintfLine("%d no such user", rcptCode)
+			case cmd == "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotBytes()
+				received <- string(data)
+				tp.PrintfLine("250 queued")
+			case cmd == "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestMailerSMTP(t *testing.T) {
+	addr, received := fakeSMTP(t, 0)
+	host, port, _ := net.SplitHostPort(addr)
+	m := newTestMailer(t, MailConfig{SMTPHost: host, SMTPPort: mustAtoi(t, port), AllowPlaintext: true})
+
+	assert.NoError(t, m.Send(OutgoingMail{To: "bob@example.com", Template: MailSecretLink, Data: MailData{Link: "https://x/secret/k"}}))
+	data := <-received
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.com", msg.Header.Get("To"))
+
+	t.Run("5xx is permanent", func(t *testing.T) {
+		addr, _ := fakeSMTP(t, 550)
+		host, port, _ := net.SplitHostPort(addr)
+		m := newTestMailer(t, MailConfig{SMTPHost: host, SMTPPort: mustAtoi(t, port), AllowPlaintext: true})
+		err := m.Send(OutgoingMail{To: "nobody@example.com", Template: MailSecretLink})
+		assert.Error(t, err)
+		assert.True(t, isPermanentMailError(err))
+	})
+
+	t.Run("STARTTLS is required", func(t *testing.T) {
+		addr, received := fakeSMTP(t, 0)
+		host, port, _ := net.SplitHostPort(addr)
+		m := newTestMailer(t, MailConfig{SMTPHost: host, SMTPPort: mustAtoi(t, port)})
+		err := m.Send(OutgoingMail{To: "bob@example.com", Template: MailSecretLink, Data: MailData{Link: "https://x/secret/k"}})
+		assert.ErrorIs(t, err, errNoSTARTTLS)
+		select {
+		case <-received:
+			t.Error("mail was sent without TLS")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("connection failure is retried", func(t *testing.T) {
+		m := newTestMailer(t, MailConfig{SMTPHost: "127.0.0.1", SMTPPort: 1, Timeout: time.Second})
+		err := m.Send(OutgoingMail{To: "bob@example.com", Template: MailSecretLink})
+		assert.Error(t, err)
+		assert.False(t, isPermanentMailError(err))
+	})
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	n, err := strconv.Atoi(s)
+	assert.NoError(t, err)
+	return n
+}
+
+func TestMailData(t *testing.T) {
+	data := MailData{Link: "https://disapyr.example.com/secret/abc"}
+	sealed, err := sealMailData(data, testEncKey)
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "abc")
+
+	opened, err := openMailData(sealed, testEncKey)
+	assert.NoError(t, err)
+	assert.Equal(t, data, opened)
+
+	// Plaintext data is rejected rather than trusted.
+	_, err = openMailData(`{"link":"https://x/secret/k"}`, testEncKey)
+	assert.Error(t, err)
+}
+
+func TestMailerDeliverDue(t *testing.T) {
+	sealed, err := sealMailData(MailData{Link: "https://x/secret/k"}, testEncKey)
+	assert.NoError(t, err)
+	claimed := []string{"id", "recipient", "template", "data", "attempts"}
+	db, fake := newFakeDB(t,
+		fakeResult{match: "UPDATE mail_outbox SET next_attempt_at", columns: claimed, rows: [][]driver.Value{
+			{int64(1), "bob@example.com", MailSecretLink, sealed, int64(0)},
+			{int64(2), "bob@example.com", "unknown", sealed, int64(0)},
+			{int64(3), "bob@example.com", MailSecretLink, `{"link":"https://x/secret/k"}`, int64(0)},
+			{int64(4), "bob@example.com", MailSecretLink, sealed[:len(sealed)-4], int64(0)},
+		}},
+		fakeResult{match: "DELETE FROM mail_outbox"},
+		fakeResult{match: "failed_at = now(), data = ''"},
+		fakeResult{match: "failed_at = now(), data = ''"},
+		fakeResult{match: "failed_at = now(), data = ''"},
+	)
+	dir := t.TempDir()
+	m, err := NewMailer(db, MailConfig{From: "noreply@example.com", DevDir: dir, BatchSize: 10, MaxAttempts: 3, Timeout: time.Second}, testEncKey)
+	assert.NoError(t, err)
+	assert.NoError(t, m.deliverDue(context.Background()))
+	assert.NotContains(t, fake.Statements(), "BEGIN")
+
+	// Mails whose data cannot be opened are given up on, never sent.
+	files, _ := os.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "disapyr"
+
+// metricsRegistry holds every disapyr metric alongside the Go runtime and
+// process collectors. A dedicated registry keeps tests and embedders free of
+// the global default registry.
+var metricsRegistry = func() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return r
+}()
+
+var metricsFactory = promauto.With(metricsRegistry)
+
+var (
+	httpRequestsTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = metricsFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	secretsCreatedTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "secrets_created_total",
+		Help:      "Secrets stored.",
+	})
+
+	secretsRetrievedTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "secrets_retrieved_total",
+		Help:      "Secrets successfully retrieved and burned.",
+	})
+
+	secretsRevokedTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "secrets_revoked_total",
+		Help:      "Secrets burned without being read.",
+	})
+
+	secretLookupFailuresTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "secret_lookup_failures_total",
+		Help:      "Secret retrievals that found nothing to return, by reason (not_found, already_retrieved).",
+	}, []string{"reason"})
+
+	secretPayloadBytes = metricsFactory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "secret_payload_bytes",
+		Help:      "Size of stored secret payloads in bytes.",
+		Buckets:   prometheus.ExponentialBuckets(16, 4, 10),
+	})
+
+	rateLimitRejectionsTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter, by route.",
+	}, []string{"route"})
+
+	errorsTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Error responses returned by HandleError, by error category.",
+	}, []string{"category"})
+
+	authFailuresTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_failures_total",
+		Help:      "Rejected authentication attempts, by reason.",
+	}, []string{"reason"})
+
+	jwksFetchErrorsTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jwks_fetch_errors_total",
+		Help:      "Failed attempts to fetch the Auth0 JSON Web Key Set.",
+	})
+)
+
+// Reasons recorded by authFailuresTotal
+const (
+	authReasonMissingToken  = "missing_token"
+	authReasonInvalidToken  = "invalid_token"
+	authReasonInvalidClaims = "invalid_claims"
+	authReasonAudience      = "invalid_audience"
+	authReasonIssuer        = "invalid_issuer"
+)
+
+// RegisterMetrics records HTTP metrics for every request handled by app and
+// serves them in the Prometheus exposition format on the configured path.
+// Metrics are off unless enabled, and with a token set scrapers must send it
+// as a bearer token. It must be called before any routes are registered.
+func RegisterMetrics(app *fiber.App, cfg MetricsConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	app.Use(metricsMiddleware(cfg.Path))
+	app.Get(cfg.Path, metricsAuth(cfg.Token), adaptor.HTTPHandler(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})))
+}
+
+// metricsAuth rejects scrapes without the bearer token, if one is configured.
+func metricsAuth(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token == "" {
+			return c.Next()
+		}
+		got, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+		return c.Next()
+	}
+}
+
+// RegisterDBMetrics exports the connection pool statistics of db.
+func RegisterDBMetrics(db *sql.DB) {
+	metricsRegistry.MustRegister(collectors.NewDBStatsCollector(db, metricsNamespace))
+}
+
+// metricsMiddleware counts and times each request by its route pattern, so
+// that secret keys in the path never become label values.
+func metricsMiddleware(metricsPath string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		route := c.Route().Path
+		if route == metricsPath {
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			} else {
+				status = fiber.StatusInternalServerError
+			}
+		}
+		if status == fiber.StatusNotFound && route == "/" && c.Path() != "/" {
+			route = "unmatched"
+		}
+
+		labels := []string{route, c.Method(), strconv.Itoa(status)}
+		httpRequestsTotal.WithLabelValues(labels...).Inc()
+		httpRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// recordErrorMetrics is called by HandleError for every error response.
+func recordErrorMetrics(c *fiber.Ctx, category ErrorCategory) {
+	errorsTotal.WithLabelValues(string(category)).Inc()
+	if category == RateLimitError {
+		rateLimitRejectionsTotal.WithLabelValues(c.Route().Path).Inc()
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	app := fiber.New()
+	RegisterMetrics(app, MetricsConfig{Enabled: true, Path: "/metrics"})
+	app.Get("/secret/:key", func(c *fiber.Ctx) error {
+		return HandleNotFoundError(c, "Secret not found", nil)
+	})
+	app.Get("/limited", func(c *fiber.Ctx) error {
+		return HandleRateLimitError(c, "Too many requests", nil)
+	})
+
+	t.Run("requests are labelled by route pattern", func(t *testing.T) {
+		before := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/secret/:key", "GET", "404"))
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/secret/abc123", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+		after := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/secret/:key", "GET", "404"))
+		assert.Equal(t, before+1, after)
+	})
+
+	t.Run("rate limit rejections are counted", func(t *testing.T) {
+		before := testutil.ToFloat64(rateLimitRejectionsTotal.WithLabelValues("/limited"))
+
+		_, err := app.Test(httptest.NewRequest("GET", "/limited", nil))
+		assert.NoError(t, err)
+
+		assert.Equal(t, before+1, testutil.ToFloat64(rateLimitRejectionsTotal.WithLabelValues("/limited")))
+	})
+
+	t.Run("metrics endpoint exposes collectors", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), "disapyr_http_requests_total")
+		assert.Contains(t, string(body), "disapyr_errors_total")
+		assert.NotContains(t, string(body), "abc123")
+	})
+}
+
+func TestMetricsToken(t *testing.T) {
+	app := fiber.New()
+	RegisterMetrics(app, MetricsConfig{Enabled: true, Path: "/metrics", Token: "scrape"})
+
+	scrape := func(auth string) int {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, fiber.StatusUnauthorized, scrape(""))
+	assert.Equal(t, fiber.StatusUnauthorized, scrape("Bearer wrong"))
+	assert.Equal(t, fiber.StatusOK, scrape("Bearer scrape"))
+
+	// Disabled metrics register nothing.
+	app = fiber.New()
+	RegisterMetrics(app, MetricsConfig{Path: "/metrics"})
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
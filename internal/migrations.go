@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/charmbracelet/log"
+)
+
+// migrationLockID is the advisory lock key held while applying migrations so
+// that replicas starting together do not race each other.
+const migrationLockID = 7305721
+
+// migration is a single forward-only schema change
+type migration struct {
+	version     int
+	description string
+	sql         string
+}
+
+// migrations lists every schema change in the order it must be applied.
+// Never edit an entry that has shipped; append a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create secrets table",
+		sql: `
+		CREATE TABLE IF NOT EXISTS secrets (
+			key TEXT PRIMARY KEY,
+			secret TEXT,
+			retrieved_at TIMESTAMP NULL
+		);
+		`,
+	},
+	{
+		version:     2,
+		description: "create append-only audit_events table",
+		sql: `
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id BIGSERIAL PRIMARY KEY,
+			occurred_at TIMESTAMPTZ NOT NULL,
+			event_type TEXT NOT NULL,
+			principal TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			key_hash TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			prev_hash TEXT NOT NULL,
+			hash TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS audit_events_key_hash_idx ON audit_events (key_hash);
+		CREATE INDEX IF NOT EXISTS audit_events_occurred_at_idx ON audit_events (occurred_at);
+
+		CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE TRIGGER audit_events_no_modify
+			BEFORE UPDATE OR DELETE ON audit_events
+			FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+		CREATE TRIGGER audit_events_no_truncate
+			BEFORE TRUNCATE ON audit_events
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+		`,
+	},
+	{
+		version:     3,
+		description: "add notify_url and notification_outbox table",
+		sql: `
+		ALTER TABLE secrets ADD COLUMN IF NOT EXISTS notify_url TEXT NOT NULL DEFAULT '';
+
+		CREATE TABLE IF NOT EXISTS notification_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			url TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			delivered_at TIMESTAMPTZ NULL,
+			failed_at TIMESTAMPTZ NULL
+		);
+		CREATE INDEX IF NOT EXISTS notification_outbox_due_idx ON notification_outbox (next_attempt_at)
+			WHERE delivered_at IS NULL AND failed_at IS NULL;
+		`,
+	},
+	{
+		version:     4,
+		description: "add notify_email and mail_outbox table",
+		sql: `
+		ALTER TABLE secrets ADD COLUMN IF NOT EXISTS notify_email TEXT NOT NULL DEFAULT '';
+
+		CREATE TABLE IF NOT EXISTS mail_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			recipient TEXT NOT NULL,
+			template TEXT NOT NULL,
+			data TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			sent_at TIMESTAMPTZ NULL,
+			failed_at TIMESTAMPTZ NULL
+		);
+		CREATE INDEX IF NOT EXISTS mail_outbox_due_idx ON mail_outbox (next_attempt_at)
+			WHERE sent_at IS NULL AND failed_at IS NULL;
+		`,
+	},
+	{
+		version:     5,
+		description: "add watch_token_hash to secrets",
+		sql: `
+		ALTER TABLE secrets ADD COLUMN IF NOT EXISTS watch_token_hash TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS secrets_watch_token_hash_idx ON secrets (watch_token_hash)
+			WHERE watch_token_hash <> '';
+		`,
+	},
+	{
+		version:     6,
+		description: "add encrypted file payloads to secrets",
+		sql: `
+		ALTER TABLE secrets
+			ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'text',
+			ADD COLUMN IF NOT EXISTS filename TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS payload BYTEA NULL;
+		`,
+	},
+	{
+		version:     7,
+		description: "add blob store references and pending blob deletions",
+		sql: `
+		ALTER TABLE secrets ADD COLUMN IF NOT EXISTS blob_key TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS secrets_blob_key_idx ON secrets (blob_key) WHERE blob_key <> '';
+		CREATE TABLE IF NOT EXISTS blob_deletions (
+			blob_key TEXT PRIMARY KEY,
+			queued_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		`,
+	},
+	{
+		version:     8,
+		description: "record secrets burned without being read",
+		sql: `
+		ALTER TABLE secrets ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMPTZ;
+		`,
+	},
+	{
+		version:     9,
+		description: "add per-secret notification signing secrets",
+		sql: `
+		ALTER TABLE secrets ADD COLUMN IF NOT EXISTS notify_secret TEXT NOT NULL DEFAULT '';
+		ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS signing_secret TEXT NOT NULL DEFAULT '';
+		`,
+	},
+}
+
+// LatestSchemaVersion returns the version the schema reaches once every
+// migration has been applied.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Migrate applies any pending migrations, each in its own transaction.
+func Migrate(db *sql.DB) error {
+	if _, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT now()
+	);
+	`); err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	for _, m := range migrations {
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting migration %d: %w", m.version, err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Error("Failed to rollback migration", "version", m.version, "error", err)
+		}
+	}()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("error locking migrations: %w", err)
+	}
+
+	var applied bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&applied); err != nil {
+		return fmt.Errorf("error checking migration %d: %w", m.version, err)
+	}
+	if applied {
+		return nil
+	}
+
+	log.Info("Applying migration", "version", m.version, "description", m.description)
+	if _, err := tx.Exec(m.sql); err != nil {
+		return fmt.Errorf("error applying migration %d (%s): %w", m.version, m.description, err)
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations(version, description) VALUES($1, $2)", m.version, m.description); err != nil {
+		return fmt.Errorf("error recording migration %d: %w", m.version, err)
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the highest migration version applied to the database.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
@@ -0,0 +1,411 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+	"github.com/mr-tron/base58"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Headers sent with every creator notification
+const (
+	NotificationSignatureHeader = "X-Disapyr-Signature"
+	NotificationEventIDHeader   = "X-Disapyr-Event-ID"
+)
+
+// NotificationSecretRetrieved is sent when a secret with a notify_url is read
+const NotificationSecretRetrieved = "secret.retrieved"
+
+// maxNotifyURLLength bounds the notify_url accepted on POST /secret
+const maxNotifyURLLength = 2048
+
+// Outcome labels for outbox delivery metrics
+const (
+	outboxDelivered = "delivered"
+	outboxRetried   = "retried"
+	outboxFailed    = "failed"
+)
+
+var notificationsTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "notifications_total",
+	Help:      "Creator notification delivery attempts, by outcome.",
+}, []string{"outcome"})
+
+// Notification is the JSON body posted to a creator's notify_url.
+type Notification struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	KeyHash    string    `json:"key_hash"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ValidateNotifyURL checks that raw is an absolute https URL, or http when
+// AllowHTTP is set for local development. Hosts that are literal private or
+// loopback addresses are rejected up front unless AllowPrivate is set; names
+// are checked again when the notification is delivered, since they can
+// resolve to anything.
+func ValidateNotifyURL(raw string, cfg NotifyConfig) error {
+	if len(raw) > maxNotifyURLLength {
+		return fmt.Errorf("notify_url must be at most %d characters", maxNotifyURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("notify_url must be an absolute URL")
+	}
+	if u.Scheme != "https" && !(cfg.AllowHTTP && u.Scheme == "http") {
+		return errors.New("notify_url must use https")
+	}
+	if u.User != nil {
+		return errors.New("notify_url must not contain credentials")
+	}
+	if !cfg.AllowPrivate {
+		if strings.EqualFold(u.Hostname(), "localhost") {
+			return errors.New("notify_url must not point at localhost")
+		}
+		if ip, err := netip.ParseAddr(u.Hostname()); err == nil && !publicAddr(ip) {
+			return fmt.Errorf("notify_url must not point at %s", ip)
+		}
+	}
+	return nil
+}
+
+// nonPublicPrefixes are ranges notifications are never sent to on top of
+// the loopback, private, link-local and multicast ranges netip recognises.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// publicAddr reports whether ip is a public unicast address. Loopback,
+// private, link-local (including the 169.254.169.254 metadata service),
+// carrier-grade NAT and reserved addresses are not.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// errForbiddenAddress is returned when a notify_url resolves to an address
+// notifications are not sent to.
+var errForbiddenAddress = errors.New("notify_url resolves to a non-public address")
+
+// notifyClient returns the HTTP client notifications are sent with. The
+// address is checked after DNS resolution, at dial time, so a name cannot be
+// pointed at an internal service after it was submitted, and redirects are
+// not followed. Proxies from the environment are ignored for the same reason.
+func notifyClient(cfg NotifyConfig) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if cfg.AllowPrivate {
+				return nil
+			}
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !publicAddr(ap.Addr()) {
+				return fmt.Errorf("%w: %s", errForbiddenAddress, address)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: cfg.Timeout,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// NewNotifySecret returns a random key for signing the notifications of one
+// secret. It is returned to the creator once, when the secret is stored.
+func NewNotifySecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate notification signing secret: %w", err)
+	}
+	return "whsec_" + base58.Encode(b), nil
+}
+
+// SignNotification returns the signature header value for body sent at ts:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">".
+func SignNotification(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(notificationMAC(secret, t, body))
+}
+
+func notificationMAC(secret, t string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// VerifyNotification checks a signature header produced by SignNotification
+// and rejects it if it is older than tolerance. Receivers can use it directly.
+func VerifyNotification(secret, header string, body []byte, tolerance time.Duration) error {
+	var t string
+	var sig []byte
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			sig, _ = hex.DecodeString(v)
+		}
+	}
+	ts, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || sig == nil {
+		return errors.New("malformed notification signature")
+	}
+	if tolerance > 0 && time.Since(time.Unix(ts, 0)) > tolerance {
+		return errors.New("notification signature expired")
+	}
+	if !hmac.Equal(sig, notificationMAC(secret, t, body)) {
+		return errors.New("notification signature mismatch")
+	}
+	return nil
+}
+
+// enqueueNotification adds a notification, signed with the secret's own
+// signing secret, to the outbox inside tx, so it is committed if and only if
+// the secret is consumed. signingSecret is sealed as stored with the secret.
+func enqueueNotification(ctx context.Context, tx *sql.Tx, notifyURL, signingSecret, key string) error {
+	body, err := json.Marshal(Notification{
+		ID:         uuid.New().String(),
+		Type:       NotificationSecretRetrieved,
+		KeyHash:    HashKey(key),
+		OccurredAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding notification: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO notification_outbox(url, signing_secret, payload) VALUES($1, $2, $3)",
+		notifyURL, signingSecret, string(body)); err != nil {
+		return fmt.Errorf("failed to queue notification: %w", err)
+	}
+	return nil
+}
+
+// Notifier delivers queued creator notifications from the outbox table.
+// Delivery is at-least-once: receivers should de-duplicate on the event ID.
+type Notifier struct {
+	db          *sql.DB
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	lease       time.Duration
+	client      *http.Client
+	encKey      []byte
+}
+
+// NewNotifier creates a notifier for the outbox in db. Signing secrets are
+// opened with encKey.
+func NewNotifier(db *sql.DB, cfg NotifyConfig, encKey []byte) *Notifier {
+	return &Notifier{
+		db:          db,
+		encKey:      encKey,
+		interval:    cfg.PollInterval,
+		batchSize:   cfg.BatchSize,
+		maxAttempts: cfg.MaxAttempts,
+		// A claimed batch is retried by any replica if it is not marked
+		// within the time it takes to send it, say after a crash.
+		lease:  time.Duration(cfg.BatchSize)*cfg.Timeout + time.Minute,
+		client: notifyClient(cfg),
+	}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		if err := n.deliverDue(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Failed to process notification outbox", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pendingNotification is an outbox entry claimed for delivery.
+type pendingNotification struct {
+	id            int64
+	url           string
+	signingSecret string
+	payload       string
+	attempts      int
+}
+
+// deliverDue claims the notifications whose retry time has passed, sends
+// them and records the outcome of each. The claim is committed before
+// anything is sent, so no transaction is held open across the HTTP calls.
+func (n *Notifier) deliverDue(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "notify.deliver_due")
+	defer func() { endSpan(span, err) }()
+
+	due, err := n.claimDue(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range due {
+		wait, err := n.attempt(ctx, p)
+		if err := n.markAttempt(ctx, p, wait, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimDue leases up to batchSize due notifications to this replica by
+// pushing their retry time past the lease, so that other replicas skip them
+// while they are sent.
+func (n *Notifier) claimDue(ctx context.Context) ([]pendingNotification, error) {
+	rows, err := n.db.QueryContext(ctx, `
+		UPDATE notification_outbox SET next_attempt_at = now() + $2 * interval '1 second'
+		WHERE id IN (
+			SELECT id FROM notification_outbox
+			WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= now()
+			ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, url, signing_secret, payload, attempts`, n.batchSize, n.lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim notifications: %w", err)
+	}
+	defer rows.Close()
+	var due []pendingNotification
+	for rows.Next() {
+		var p pendingNotification
+		if err := rows.Scan(&p.id, &p.url, &p.signingSecret, &p.payload, &p.attempts); err != nil {
+			return nil, fmt.Errorf("failed to read notification outbox: %w", err)
+		}
+		due = append(due, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notification outbox: %w", err)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].id < due[j].id })
+	return due, nil
+}
+
+// attempt sends p once, returning the result of deliver. Entries whose
+// signing secret is missing or cannot be decrypted fail permanently.
+func (n *Notifier) attempt(ctx context.Context, p pendingNotification) (time.Duration, error) {
+	if p.signingSecret == "" {
+		return -1, errors.New("notification has no signing secret")
+	}
+	secret, err := openText(p.signingSecret, n.encKey)
+	if err != nil {
+		return -1, fmt.Errorf("failed to decrypt notification signing secret: %w", err)
+	}
+	return n.deliver(ctx, p.url, secret, []byte(p.payload))
+}
+
+// markAttempt records the outcome of a delivery attempt: delivered, given up
+// on, or due again after wait or a backoff.
+func (n *Notifier) markAttempt(ctx context.Context, p pendingNotification, wait time.Duration, deliverErr error) error {
+	attempts := p.attempts + 1
+	var err error
+	switch {
+	case deliverErr == nil:
+		notificationsTotal.WithLabelValues(outboxDelivered).Inc()
+		_, err = n.db.ExecContext(ctx, "UPDATE notification_outbox SET attempts = $1, delivered_at = now(), last_error = '' WHERE id = $2", attempts, p.id)
+	case wait < 0 || attempts >= n.maxAttempts:
+		notificationsTotal.WithLabelValues(outboxFailed).Inc()
+		log.Warn("Giving up on notification", "id", p.id, "attempts", attempts, "error", deliverErr)
+		_, err = n.db.ExecContext(ctx, "UPDATE notification_outbox SET attempts = $1, failed_at = now(), last_error = $2 WHERE id = $3", attempts, deliverErr.Error(), p.id)
+	default:
+		notificationsTotal.WithLabelValues(outboxRetried).Inc()
+		if wait == 0 {
+			wait = outboxBackoff(attempts)
+		}
+		_, err = n.db.ExecContext(ctx, "UPDATE notification_outbox SET attempts = $1, next_attempt_at = $2, last_error = $3 WHERE id = $4", attempts, time.Now().Add(wait), deliverErr.Error(), p.id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update notification %d: %w", p.id, err)
+	}
+	return nil
+}
+
+// deliver posts a notification signed with secret. Like WebhookSink.post, the
+// returned duration is negative if the failure is permanent, or the delay the
+// receiver asked for via Retry-After. Redirects count as permanent failures.
+func (n *Notifier) deliver(ctx context.Context, target, secret string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	var event Notification
+	_ = json.Unmarshal(body, &event)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "disapyr-notifier")
+	req.Header.Set(NotificationEventIDHeader, event.ID)
+	req.Header.Set(NotificationSignatureHeader, SignNotification(secret, time.Now(), body))
+
+	resp, err := n.client.Do(req)
+	if errors.Is(err, errForbiddenAddress) {
+		return -1, err
+	} else if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("notify_url returned status %d", resp.StatusCode)
+	default:
+		return -1, fmt.Errorf("notify_url returned status %d", resp.StatusCode)
+	}
+}
+
+// outboxBackoff returns the delay before an outbox entry is retried after the
+// given number of attempts: 30s doubling up to one hour.
+func outboxBackoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < time.Hour; i++ {
+		d *= 2
+	}
+	return min(d, time.Hour)
+}
@@ -0,0 +1,515 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// QR codes are encoded in byte mode with error correction level M, which
+// recovers from about 15% damage. Share links fit in version 5 or 6.
+const (
+	qrQuietZone = 4
+	qrMaxScale  = 32
+)
+
+// ErrQRTooLong is returned when the data does not fit in a QR code.
+var ErrQRTooLong = errors.New("data too long for a QR code")
+
+// qrBlocks describes the error correction blocks of a version at level M:
+// the error correction codewords per block and the count and data codewords
+// of each of the two groups of blocks.
+type qrBlocks struct {
+	ecLen          int
+	blocks1, data1 int
+	blocks2, data2 int
+}
+
+// qrVersions holds the block structure of versions 1 to 40 at level M.
+var qrVersions = [...]qrBlocks{
+	{10, 1, 16, 0, 0}, {16, 1, 28, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 32, 0, 0},
+	{24, 2, 43, 0, 0}, {16, 4, 27, 0, 0}, {18, 4, 31, 0, 0}, {22, 2, 38, 2, 39},
+	{22, 3, 36, 2, 37}, {26, 4, 43, 1, 44}, {30, 1, 50, 4, 51}, {22, 6, 36, 2, 37},
+	{22, 8, 37, 1, 38}, {24, 4, 40, 5, 41}, {24, 5, 41, 5, 42}, {28, 7, 45, 3, 46},
+	{28, 10, 46, 1, 47}, {26, 9, 43, 4, 44}, {26, 3, 44, 11, 45}, {26, 3, 41, 13, 42},
+	{26, 17, 42, 0, 0}, {28, 17, 46, 0, 0}, {28, 4, 47, 14, 48}, {28, 6, 45, 14, 46},
+	{28, 8, 47, 13, 48}, {28, 19, 46, 4, 47}, {28, 22, 45, 3, 46}, {28, 3, 45, 23, 46},
+	{28, 21, 45, 7, 46}, {28, 19, 47, 10, 48}, {28, 2, 46, 29, 47}, {28, 10, 46, 23, 47},
+	{28, 14, 46, 21, 47}, {28, 14, 46, 23, 47}, {28, 12, 47, 26, 48}, {28, 6, 47, 34, 48},
+	{28, 29, 46, 14, 47}, {28, 13, 46, 32, 47}, {28, 40, 47, 7, 48}, {28, 18, 47, 31, 48},
+}
+
+func (b qrBlocks) dataLen() int {
+	return b.blocks1*b.data1 + b.blocks2*b.data2
+}
+
+// QRCode is a QR code symbol: a square of dark and light modules, without
+// the quiet zone around it.
+type QRCode struct {
+	Size     int
+	modules  []bool
+	function []bool
+}
+
+// EncodeQR encodes data as a QR code of the smallest version it fits in.
+func EncodeQR(data string) (*QRCode, error) {
+	version := 0
+	for v := 1; v <= len(qrVersions); v++ {
+		if 4+qrCountBits(v)+8*len(data) <= 8*qrVersions[v-1].dataLen() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrQRTooLong
+	}
+
+	q := &QRCode{Size: 4*version + 17}
+	q.modules = make([]bool, q.Size*q.Size)
+	q.function = make([]bool, q.Size*q.Size)
+	q.drawFunctionPatterns(version)
+	q.drawCodewords(qrCodewords(version, []byte(data)))
+
+	// Use the mask with the lowest penalty, as the standard requires.
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+	q.function = nil
+	return q, nil
+}
+
+// Dark reports whether the module at column x and row y is dark. Modules
+// outside the symbol, in the quiet zone, are light.
+func (q *QRCode) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= q.Size || y >= q.Size {
+		return false
+	}
+	return q.modules[y*q.Size+x]
+}
+
+// WriteTerminal draws the code with Unicode half blocks, two rows of modules
+// per line, in black on white whatever the terminal's colours.
+func (q *QRCode) WriteTerminal(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for y := -qrQuietZone; y < q.Size+qrQuietZone; y += 2 {
+		bw.WriteString("\x1b[30;107m")
+		for x := -qrQuietZone; x < q.Size+qrQuietZone; x++ {
+			top, bottom := q.Dark(x, y), q.Dark(x, y+1)
+			switch {
+			case top && bottom:
+				bw.WriteString("█")
+			case top:
+				bw.WriteString("▀")
+			case bottom:
+				bw.WriteString("▄")
+			default:
+				bw.WriteString(" ")
+			}
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+	return bw.Flush()
+}
+
+// WritePNG writes the code as a PNG image with scale pixels per module.
+func (q *QRCode) WritePNG(w io.Writer, scale int) error {
+	if scale < 1 || scale > qrMaxScale {
+		return fmt.Errorf("QR code scale must be between 1 and %d", qrMaxScale)
+	}
+	size := (q.Size + 2*qrQuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			if q.Dark(px/scale-qrQuietZone, py/scale-qrQuietZone) {
+				img.SetColorIndex(px, py, 1)
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// WriteSVG writes the code as an SVG image, one unit per module, which scales
+// to the size it is displayed at.
+func (q *QRCode) WriteSVG(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	size := q.Size + 2*qrQuietZone
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.Dark(x, y) {
+				fmt.Fprintf(bw, "M%d,%dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+	bw.WriteString(`"/></svg>`)
+	return bw.Flush()
+}
+
+// qrCountBits returns the length of the byte mode character count.
+func qrCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// qrCodewords returns the data and error correction codewords of data in the
+// order they are placed in the symbol.
+func qrCodewords(version int, data []byte) []byte {
+	blocks := qrVersions[version-1]
+	capacity := blocks.dataLen()
+
+	// Byte mode indicator, count and data, then a terminator of up to four
+	// zero bits and alternating pad bytes.
+	var bits qrBits
+	bits.append(0b0100, 4)
+	bits.append(len(data), qrCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, 8*capacity-bits.n))
+	bits.append(0, (8-bits.n%8)%8)
+	for pad := 0xEC; len(bits.buf) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	// Split the data into blocks and interleave them, then their error
+	// correction codewords.
+	var dataBlocks, ecBlocks [][]byte
+	divisor := rsGenerator(blocks.ecLen)
+	offset := 0
+	for i := 0; i < blocks.blocks1+blocks.blocks2; i++ {
+		n := blocks.data1
+		if i >= blocks.blocks1 {
+			n = blocks.data2
+		}
+		block := bits.buf[offset : offset+n]
+		offset += n
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+	}
+	result := make([]byte, 0, capacity+len(ecBlocks)*blocks.ecLen)
+	for i := 0; i < max(blocks.data1, blocks.data2); i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ecLen; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// qrBits is a big-endian bit buffer.
+type qrBits struct {
+	buf []byte
+	n   int
+}
+
+func (b *qrBits) append(v, length int) {
+	for i := length - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.buf = append(b.buf, 0)
+		}
+		if v>>i&1 == 1 {
+			b.buf[b.n/8] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+}
+
+// rsMultiply multiplies in GF(2^8) with the QR code polynomial 0x11D.
+func rsMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z >> 7
+		z = z<<1 ^ carry*0x1D
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// rsGenerator returns the coefficients of the Reed-Solomon generator
+// polynomial of the given degree, highest first, without the leading 1.
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = rsMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = rsMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= rsMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+func (q *QRCode) set(x, y int, dark bool) {
+	q.modules[y*q.Size+x] = dark
+}
+
+func (q *QRCode) setFunction(x, y int, dark bool) {
+	q.set(x, y, dark)
+	q.function[y*q.Size+x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns and
+// the version information, and reserves the format information modules.
+func (q *QRCode) drawFunctionPatterns(version int) {
+	for i := 0; i < q.Size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(q.Size-4, 3)
+	q.drawFinder(3, q.Size-4)
+
+	positions := qrAlignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// Skip the three corners with finder patterns.
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	q.drawFormatBits(0)
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := q.Size-11+i%3, i/3
+			q.setFunction(a, b, dark)
+			q.setFunction(b, a, dark)
+		}
+	}
+}
+
+// drawFinder draws a finder pattern and its separator centred on x, y.
+func (q *QRCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= q.Size || yy >= q.Size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			q.setFunction(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the format information for level M
+// and mask, and the dark module.
+func (q *QRCode) drawFormatBits(mask int) {
+	data := 0b00<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.Size-15+i, bit(i))
+	}
+	q.setFunction(8, q.Size-8, true)
+}
+
+// qrAlignmentPositions returns the centre coordinates of the alignment
+// patterns of a version.
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + count*2 + 1) / (count*2 - 2) * 2
+	}
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, 4*version+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// drawCodewords places the codewords in the zigzag order of the standard,
+// skipping function modules.
+func (q *QRCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert
+				}
+				if !q.function[y*q.Size+x] && i < len(data)*8 {
+					q.set(x, y, data[i/8]>>(7-i%8)&1 == 1)
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by mask. Applying it twice
+// undoes it.
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y*q.Size+x] {
+				q.modules[y*q.Size+x] = !q.modules[y*q.Size+x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol by the four rules of the standard; masks with
+// lower scores are easier to scan.
+func (q *QRCode) penalty() int {
+	score, dark := 0, 0
+	finderLike := []bool{true, false, true, true, true, false, true, false, false, false, false}
+	for i := 0; i < q.Size; i++ {
+		for _, horizontal := range []bool{true, false} {
+			at := func(j int) bool {
+				if horizontal {
+					return q.Dark(j, i)
+				}
+				return q.Dark(i, j)
+			}
+			// Runs of five or more modules of the same colour.
+			run := 1
+			for j := 1; j <= q.Size; j++ {
+				if j < q.Size && at(j) == at(j-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+			// Patterns that look like finders, with light modules on
+			// either side.
+			for j := -len(finderLike); j <= q.Size; j++ {
+				forward, backward := true, true
+				for k, want := range finderLike {
+					forward = forward && at(j+k) == want
+					backward = backward && at(j+len(finderLike)-1-k) == want
+				}
+				if forward {
+					score += 40
+				}
+				if backward {
+					score += 40
+				}
+			}
+		}
+		for j := 0; j < q.Size; j++ {
+			if q.Dark(j, i) {
+				dark++
+			}
+			// Blocks of two by two modules of the same colour.
+			if i+1 < q.Size && j+1 < q.Size {
+				c := q.Dark(j, i)
+				if c == q.Dark(j+1, i) && c == q.Dark(j, i+1) && c == q.Dark(j+1, i+1) {
+					score += 3
+				}
+			}
+		}
+	}
+	// Balance of dark and light modules.
+	total := q.Size * q.Size
+	score += abs(dark*100/total-50) / 5 * 10
+	return score
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
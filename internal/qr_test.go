@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// The data codewords of HELLO WORLD at version 1-M and their error
+	// correction codewords, from the worked example of the standard.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	assert.Equal(t, want, rsRemainder(data, rsGenerator(10)))
+}
+
+func TestQRVersionTable(t *testing.T) {
+	// Each version's codewords fill the modules left by the function
+	// patterns, less the remainder bits.
+	for v := 1; v <= len(qrVersions); v++ {
+		blocks := qrVersions[v-1]
+		total := blocks.dataLen() + (blocks.blocks1+blocks.blocks2)*blocks.ecLen
+
+		q := &QRCode{Size: 4*v + 17}
+		q.modules = make([]bool, q.Size*q.Size)
+		q.function = make([]bool, q.Size*q.Size)
+		q.drawFunctionPatterns(v)
+		free := 0
+		for _, f := range q.function {
+			if !f {
+				free++
+			}
+		}
+		assert.Equal(t, free/8, total, "version %d", v)
+	}
+}
+
+func TestEncodeQRSize(t *testing.T) {
+	for _, tc := range []struct {
+		length, size int
+	}{
+		{0, 21},
+		{14, 21},
+		{15, 25},
+		{84, 37},
+		{85, 41},
+		{2331, 177},
+	} {
+		q, err := EncodeQR(strings.Repeat("a", tc.length))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.size, q.Size, "length %d", tc.length)
+	}
+
+	_, err := EncodeQR(strings.Repeat("a", 2332))
+	assert.ErrorIs(t, err, ErrQRTooLong)
+}
+
+func TestEncodeQRFormatInfo(t *testing.T) {
+	q, err := EncodeQR(ShareURL("https://disapyr.example.com", "abcdefghijklmnopqrstuvwxyz012345"))
+	assert.NoError(t, err)
+
+	// Read both copies of the format information back.
+	var first, second int
+	for i, p := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		if q.Dark(p[0], p[1]) {
+			first |= 1 << i
+		}
+	}
+	for i := 0; i < 15; i++ {
+		x, y := q.Size-1-i, 8
+		if i >= 8 {
+			x, y = 8, q.Size-15+i
+		}
+		if q.Dark(x, y) {
+			second |= 1 << i
+		}
+	}
+	assert.Equal(t, first, second)
+	// Level M is 00 in the top two bits of the unmasked data.
+	assert.Equal(t, 0, (first^0x5412)>>13)
+	assert.True(t, q.Dark(8, q.Size-8), "dark module")
+	// Finder pattern centres and separators.
+	for _, p := range [][2]int{{3, 3}, {q.Size - 4, 3}, {3, q.Size - 4}} {
+		assert.True(t, q.Dark(p[0], p[1]))
+		assert.False(t, q.Dark(p[0]+2, p[1]))
+		assert.True(t, q.Dark(p[0]+3, p[1]))
+	}
+}
+
+func TestQRCodeImages(t *testing.T) {
+	q, err := EncodeQR("https://disapyr.example.com/secret/key")
+	assert.NoError(t, err)
+	width := q.Size + 2*qrQuietZone
+
+	var buf bytes.Buffer
+	assert.NoError(t, q.WritePNG(&buf, 3))
+	img, err := png.Decode(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*width, img.Bounds().Dx())
+	r, _, _, _ := img.At(3*qrQuietZone, 3*qrQuietZone).RGBA()
+	assert.Zero(t, r, "top left finder is dark")
+	r, _, _, _ = img.At(0, 0).RGBA()
+	assert.NotZero(t, r, "quiet zone is light")
+	assert.Error(t, q.WritePNG(&buf, 0))
+
+	buf.Reset()
+	assert.NoError(t, q.WriteSVG(&buf))
+	assert.Contains(t, buf.String(), `viewBox="0 0 37 37"`)
+	assert.Contains(t, buf.String(), "M4,4h1v1h-1z")
+
+	buf.Reset()
+	assert.NoError(t, q.WriteTerminal(&buf))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, (width+1)/2)
+	assert.Contains(t, lines[2], "█")
+}
@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+var (
+	// ErrSecretNotFound is returned when no secret exists for a key
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrSecretAlreadyRetrieved is returned when a secret has already been consumed
+	ErrSecretAlreadyRetrieved = errors.New("secret already retrieved")
+)
+
+// Kinds of secret payload
+const (
+	secretKindText = "text"
+	secretKindFile = "file"
+)
+
+// secretRecord is a stored secret along with how its creator wants to hear
+// about it being opened. Secrets are held encrypted in Payload, or for large
+// files in the blob store named by BlobKey. Secret only holds text stored
+// before text was encrypted.
+type secretRecord struct {
+	Key            string
+	Kind           string
+	Secret         string
+	Filename       string
+	ContentType    string
+	Size           int64
+	Payload        []byte
+	BlobKey        string
+	NotifyURL      string
+	NotifySecret   string
+	NotifyEmail    string
+	WatchTokenHash string
+}
+
+// storeSecret inserts a new secret and queues any mails that go with it, such
+// as the link sent to its recipient, in a single transaction. Mail data and
+// the notification signing secret are sealed with encKey.
+func storeSecret(ctx context.Context, db *sql.DB, encKey []byte, rec secretRecord, mails ...OutgoingMail) (err error) {
+	ctx, span := startSpan(ctx, "db.insert_secret", semconv.DBSystemPostgreSQL)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Error("Failed to rollback transaction", "error", err)
+		}
+	}()
+
+	if rec.Kind == "" {
+		rec.Kind = secretKindText
+	}
+	if rec.NotifySecret != "" {
+		if rec.NotifySecret, err = sealText(rec.NotifySecret, encKey); err != nil {
+			return fmt.Errorf("failed to encrypt notification signing secret: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO secrets(key, kind, secret, filename, content_type, size, payload, blob_key, notify_url, notify_secret, notify_email, watch_token_hash)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		rec.Key, rec.Kind, rec.Secret, rec.Filename, rec.ContentType, rec.Size, rec.Payload, rec.BlobKey,
+		rec.NotifyURL, rec.NotifySecret, rec.NotifyEmail, rec.WatchTokenHash); err != nil {
+		return fmt.Errorf("failed to insert secret: %w", err)
+	}
+	for _, m := range mails {
+		if err := enqueueMail(ctx, tx, m, encKey); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// consumeSecret returns the secret stored under key and burns it in the same
+// transaction, so that it can only ever be returned once. If the creator asked
+// to be notified by webhook or email, that is queued in the transaction too,
+// and open status streams are told via NOTIFY on commit. A payload held in the
+// blob store is queued for deletion; the caller deletes it once it is sent.
+// Mail data is sealed with encKey.
+func consumeSecret(ctx context.Context, db *sql.DB, encKey []byte, key string) (rec secretRecord, err error) {
+	ctx, span := startSpan(ctx, "db.consume_secret", semconv.DBSystemPostgreSQL)
+	defer func() {
+		if errors.Is(err, ErrSecretNotFound) || errors.Is(err, ErrSecretAlreadyRetrieved) {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+
+	// Start a transaction to ensure atomic read-update.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return rec, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Error("Failed to rollback transaction", "error", err)
+		}
+	}()
+
+	var retrievedAt, revokedAt *time.Time
+	rec.Key = key
+	err = tx.QueryRowContext(ctx, `
+		SELECT kind, secret, filename, content_type, size, payload, blob_key, retrieved_at, revoked_at, notify_url, notify_secret, notify_email, watch_token_hash
+		FROM secrets WHERE key = $1 FOR UPDATE`, key).
+		Scan(&rec.Kind, &rec.Secret, &rec.Filename, &rec.ContentType, &rec.Size, &rec.Payload, &rec.BlobKey,
+			&retrievedAt, &revokedAt, &rec.NotifyURL, &rec.NotifySecret, &rec.NotifyEmail, &rec.WatchTokenHash)
+	if err == sql.ErrNoRows {
+		return secretRecord{}, ErrSecretNotFound
+	} else if err != nil {
+		return secretRecord{}, fmt.Errorf("failed to query secret from database: %w", err)
+	}
+
+	// Check if the secret has already been retrieved or revoked.
+	if retrievedAt != nil || revokedAt != nil || (rec.Secret == "" && rec.Payload == nil && rec.BlobKey == "") {
+		return secretRecord{}, ErrSecretAlreadyRetrieved
+	}
+
+	// Clear the secret and record the retrieval time. The notification
+	// signing secret moves to the outbox with the notification, still sealed.
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, "UPDATE secrets SET secret = '', payload = NULL, blob_key = '', notify_secret = '', retrieved_at = $1 WHERE key = $2", now, key)
+	if err != nil {
+		return secretRecord{}, fmt.Errorf("failed to update secret in database: %w", err)
+	}
+	if rec.BlobKey != "" {
+		if err = queueBlobDeletion(ctx, tx, rec.BlobKey); err != nil {
+			return secretRecord{}, err
+		}
+	}
+
+	if rec.NotifyURL != "" {
+		if err = enqueueNotification(ctx, tx, rec.NotifyURL, rec.NotifySecret, key); err != nil {
+			return secretRecord{}, err
+		}
+	}
+	if rec.WatchTokenHash != "" {
+		if err = notifySecretChanged(ctx, tx, rec.WatchTokenHash); err != nil {
+			return secretRecord{}, err
+		}
+	}
+	if rec.NotifyEmail != "" {
+		err = enqueueMail(ctx, tx, OutgoingMail{
+			To:       rec.NotifyEmail,
+			Template: MailSecretOpened,
+			Data:     MailData{KeyFingerprint: KeyFingerprint(key), OpenedAt: now.UTC()},
+		}, encKey)
+		if err != nil {
+			return secretRecord{}, err
+		}
+	}
+
+	// Commit the transaction.
+	if err = tx.Commit(); err != nil {
+		return secretRecord{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return rec, nil
+}
+
+// revokeSecret burns the secret stored under key without returning it. The
+// creator's watch stream is told, but no opened notifications are sent.
+func revokeSecret(ctx context.Context, db *sql.DB, key string) (err error) {
+	ctx, span := startSpan(ctx, "db.revoke_secret", semconv.DBSystemPostgreSQL)
+	defer func() {
+		if errors.Is(err, ErrSecretNotFound) || errors.Is(err, ErrSecretAlreadyRetrieved) {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Error("Failed to rollback transaction", "error", err)
+		}
+	}()
+
+	var retrievedAt, revokedAt *time.Time
+	var blobKey, watchTokenHash string
+	err = tx.QueryRowContext(ctx, `
+		SELECT blob_key, retrieved_at, revoked_at, watch_token_hash
+		FROM secrets WHERE key = $1 FOR UPDATE`, key).
+		Scan(&blobKey, &retrievedAt, &revokedAt, &watchTokenHash)
+	if err == sql.ErrNoRows {
+		return ErrSecretNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to query secret from database: %w", err)
+	}
+	if retrievedAt != nil || revokedAt != nil {
+		return ErrSecretAlreadyRetrieved
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE secrets SET secret = '', payload = NULL, blob_key = '', notify_secret = '', revoked_at = $1 WHERE key = $2", time.Now(), key)
+	if err != nil {
+		return fmt.Errorf("failed to update secret in database: %w", err)
+	}
+	if blobKey != "" {
+		if err = queueBlobDeletion(ctx, tx, blobKey); err != nil {
+			return err
+		}
+	}
+	if watchTokenHash != "" {
+		if err = notifySecretChanged(ctx, tx, watchTokenHash); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
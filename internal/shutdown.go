@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Serve runs listen until it fails or ctx is cancelled. On cancellation the app
+// stops accepting connections and in-flight requests are given up to timeout to
+// finish before Serve returns.
+func Serve(ctx context.Context, app *fiber.App, listen func() error, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- listen()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info("Shutting down, draining in-flight requests", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down server: %w", err)
+	}
+
+	// Listen returns once the listener has been closed.
+	if err := <-errCh; err != nil {
+		return fmt.Errorf("error stopping listener: %w", err)
+	}
+	log.Info("Server stopped")
+	return nil
+}
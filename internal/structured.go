@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Secret types accepted in the type field of POST /secret and returned on
+// retrieval. Key/value and dotenv secrets are stored encrypted in the payload
+// column, with the type in the kind column.
+const (
+	SecretTypeText   = secretKindText
+	SecretTypeKV     = "kv"
+	SecretTypeDotenv = "dotenv"
+)
+
+const (
+	// maxSecretFields bounds the number of fields in a structured secret.
+	maxSecretFields = 256
+	// maxFieldNameLength bounds each field name, in bytes.
+	maxFieldNameLength = 256
+)
+
+// envNamePattern matches names that can be used as environment variables.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretResponse is the JSON body of GET /secret/:key for everything but
+// files. Fields is set for key/value and dotenv secrets; Secret holds the text
+// or the dotenv document.
+type SecretResponse struct {
+	Type   string            `json:"type"`
+	Secret string            `json:"secret,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// ValidEnvName reports whether name can be used as an environment variable.
+func ValidEnvName(name string) bool {
+	return envNamePattern.MatchString(name)
+}
+
+// ParseDotenv parses a dotenv document into its variables. Blank lines,
+// comments and an "export " prefix are ignored. Values may be unquoted, with
+// an inline comment after " #", single quoted and taken literally, or double
+// quoted with \n, \r, \t, \" and \\ escapes. Quoted values may span lines. A
+// later assignment to the same name wins.
+func ParseDotenv(doc string) (map[string]string, error) {
+	fields := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(doc, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected NAME=value", lineNo)
+		}
+		if !ValidEnvName(name) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNo, name)
+		}
+		value = strings.TrimLeft(value, " \t")
+
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = value[:j]
+			}
+			fields[name] = strings.TrimSpace(value)
+			continue
+		}
+
+		// Quoted values continue until the closing quote, across lines.
+		quote := value[0]
+		rest := value[1:]
+		for {
+			end := closingQuote(rest, quote)
+			if end >= 0 {
+				trailing := strings.TrimSpace(rest[end+1:])
+				if trailing != "" && !strings.HasPrefix(trailing, "#") {
+					return nil, fmt.Errorf("line %d: unexpected text after closing quote", i+1)
+				}
+				rest = rest[:end]
+				break
+			}
+			if i+1 >= len(lines) {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", lineNo)
+			}
+			i++
+			rest += "\n" + lines[i]
+		}
+		if quote == '"' {
+			rest = unescapeDotenv(rest)
+		}
+		fields[name] = rest
+	}
+	return fields, nil
+}
+
+// closingQuote returns the index of the quote ending s, skipping escaped
+// double quotes, or -1.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+var dotenvEscapes = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`)
+
+func unescapeDotenv(s string) string {
+	return dotenvEscapes.Replace(s)
+}
+
+// structuredSecret validates a key/value or dotenv create request and returns
+// the content to encrypt. It fails with ErrSecretTooLarge if the content is
+// over the secret size limit.
+func structuredSecret(req createRequest, limits Limits) ([]byte, error) {
+	if req.file != nil {
+		return nil, fmt.Errorf("a %s secret cannot include a file", req.Type)
+	}
+
+	var content []byte
+	var fields map[string]string
+	switch req.Type {
+	case SecretTypeKV:
+		if req.Secret != "" {
+			return nil, errors.New("a kv secret takes fields, not secret")
+		}
+		fields = req.Fields
+		b, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		content = b
+	case SecretTypeDotenv:
+		if len(req.Fields) > 0 {
+			return nil, errors.New("a dotenv secret takes a document in secret, not fields")
+		}
+		var err error
+		if fields, err = ParseDotenv(req.Secret); err != nil {
+			return nil, err
+		}
+		content = []byte(req.Secret)
+	default:
+		return nil, fmt.Errorf("unknown secret type %q", req.Type)
+	}
+
+	if len(fields) == 0 {
+		return nil, errors.New("secret has no fields")
+	}
+	if len(fields) > maxSecretFields {
+		return nil, fmt.Errorf("secret has more than %d fields", maxSecretFields)
+	}
+	for name := range fields {
+		if name == "" || len(name) > maxFieldNameLength {
+			return nil, fmt.Errorf("field names must be between 1 and %d bytes", maxFieldNameLength)
+		}
+	}
+	if err := limits.CheckSecret(content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// sealSecret encrypts a text or structured secret for storage.
+func sealSecret(content []byte, encKey []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc, err := NewPayloadEncrypter(&buf, encKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := enc.Write(content); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sealText encrypts s for a text column, base64 encoded.
+func sealText(s string, encKey []byte) (string, error) {
+	sealed, err := sealSecret([]byte(s), encKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openText reverses sealText.
+func openText(raw string, encKey []byte) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return "", ErrPayloadCorrupt
+	}
+	dec, err := NewPayloadDecrypter(bytes.NewReader(sealed), encKey)
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(dec)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// openSecret decrypts a consumed secret into its response body. Text secrets
+// stored before they were sealed are returned from the secret column.
+func openSecret(rec secretRecord, encKey []byte) (SecretResponse, error) {
+	if rec.Kind == "" {
+		rec.Kind = secretKindText
+	}
+	if rec.Kind == secretKindText && rec.Payload == nil {
+		return SecretResponse{Type: SecretTypeText, Secret: rec.Secret}, nil
+	}
+
+	dec, err := NewPayloadDecrypter(bytes.NewReader(rec.Payload), encKey)
+	if err != nil {
+		return SecretResponse{}, err
+	}
+	content, err := io.ReadAll(dec)
+	if err != nil {
+		return SecretResponse{}, err
+	}
+
+	resp := SecretResponse{Type: rec.Kind}
+	switch rec.Kind {
+	case secretKindText:
+		resp.Secret = string(content)
+	case SecretTypeKV:
+		err = json.Unmarshal(content, &resp.Fields)
+	case SecretTypeDotenv:
+		resp.Secret = string(content)
+		resp.Fields, err = ParseDotenv(resp.Secret)
+	default:
+		err = fmt.Errorf("unknown secret kind %q", rec.Kind)
+	}
+	if err != nil {
+		return SecretResponse{}, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDotenv(t *testing.T) {
+	doc := strings.Join([]string{
+		"# database",
+		"DB_HOST=db.internal",
+		"export DB_USER = app # inline comment",
+		`DB_PASSWORD="p@ss \"word\"\n2"`,
+		`LITERAL='no $expansion\n here'`,
+		`CERT="-----BEGIN-----`,
+		`abc`,
+		`-----END-----"`,
+		"",
+		"EMPTY=",
+		"URL=https://example.com/#fragment",
+		"DB_HOST=db.override\r",
+	}, "\n")
+
+	fields, err := ParseDotenv(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_HOST":     "db.override",
+		"DB_USER":     "app",
+		"DB_PASSWORD": "p@ss \"word\"\n2",
+		"LITERAL":     `no $expansion\n here`,
+		"CERT":        "-----BEGIN-----\nabc\n-----END-----",
+		"EMPTY":       "",
+		"URL":         "https://example.com/#fragment",
+	}, fields)
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	for doc, msg := range map[string]string{
+		"A=1\nnot an assignment":  "line 2: expected NAME=value",
+		"1BAD=x":                  `line 1: invalid variable name "1BAD"`,
+		"A=1\nB=\"unterminated\n": "line 2: unterminated quoted value",
+		`A="x" trailing`:          "line 1: unexpected text after closing quote",
+	} {
+		_, err := ParseDotenv(doc)
+		assert.EqualError(t, err, msg, doc)
+	}
+}
+
+func TestStructuredSecret(t *testing.T) {
+	limits := Limits{MaxSecretSize: 64}
+
+	content, err := structuredSecret(createRequest{Type: SecretTypeKV, Fields: map[string]string{"user": "app", "password": "x"}}, limits)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"user":"app","password":"x"}`, string(content))
+
+	content, err = structuredSecret(createRequest{Type: SecretTypeDotenv, Secret: "A=1\nB=2\n"}, limits)
+	assert.NoError(t, err)
+	assert.Equal(t, "A=1\nB=2\n", string(content))
+
+	_, err = structuredSecret(createRequest{Type: SecretTypeKV, Fields: map[string]string{"big": strings.Repeat("x", 64)}}, limits)
+	assert.ErrorIs(t, err, ErrSecretTooLarge)
+
+	for _, req := range []createRequest{
+		{Type: SecretTypeKV},
+		{Type: SecretTypeKV, Fields: map[string]string{"": "x"}},
+		{Type: SecretTypeKV, Secret: "text", Fields: map[string]string{"a": "b"}},
+		{Type: SecretTypeDotenv, Secret: "# only a comment"},
+		{Type: SecretTypeDotenv, Secret: "A=1", Fields: map[string]string{"a": "b"}},
+		{Type: SecretTypeDotenv, Secret: "A=1", file: &fileUpload{}},
+		{Type: "yaml", Secret: "a: b"},
+	} {
+		_, err := structuredSecret(req, limits)
+		assert.Error(t, err, "%+v", req)
+	}
+}
+
+func TestSealAndOpenSecret(t *testing.T) {
+	resp, err := openSecret(secretRecord{Kind: secretKindText, Secret: "hunter2"}, testEncKey)
+	assert.NoError(t, err)
+	assert.Equal(t, SecretResponse{Type: SecretTypeText, Secret: "hunter2"}, resp)
+
+	payload, err := sealSecret([]byte("hunter2"), testEncKey)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(payload), "hunter2")
+	resp, err = openSecret(secretRecord{Kind: secretKindText, Payload: payload}, testEncKey)
+	assert.NoError(t, err)
+	assert.Equal(t, SecretResponse{Type: SecretTypeText, Secret: "hunter2"}, resp)
+
+	payload, err = sealSecret([]byte(`{"user":"app"}`), testEncKey)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(payload), "app")
+	resp, err = openSecret(secretRecord{Kind: SecretTypeKV, Payload: payload}, testEncKey)
+	assert.NoError(t, err)
+	assert.Equal(t, SecretResponse{Type: SecretTypeKV, Fields: map[string]string{"user": "app"}}, resp)
+
+	payload, err = sealSecret([]byte("A=1\n"), testEncKey)
+	assert.NoError(t, err)
+	resp, err = openSecret(secretRecord{Kind: SecretTypeDotenv, Payload: payload}, testEncKey)
+	assert.NoError(t, err)
+	assert.Equal(t, SecretResponse{Type: SecretTypeDotenv, Secret: "A=1\n", Fields: map[string]string{"A": "1"}}, resp)
+
+	_, err = openSecret(secretRecord{Kind: SecretTypeKV, Payload: payload[:len(payload)-1]}, testEncKey)
+	assert.ErrorIs(t, err, ErrPayloadCorrupt)
+}
+
+func TestSealAndOpenText(t *testing.T) {
+	sealed, err := sealText("whsec_1", testEncKey)
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "whsec_1")
+	opened, err := openText(sealed, testEncKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "whsec_1", opened)
+
+	_, err = openText("whsec_1", testEncKey)
+	assert.Error(t, err, "plaintext is not accepted")
+}
+
+func TestValidEnvName(t *testing.T) {
+	assert.True(t, ValidEnvName("DB_HOST"))
+	assert.True(t, ValidEnvName("_x1"))
+	assert.False(t, ValidEnvName("1X"))
+	assert.False(t, ValidEnvName("A-B"))
+	assert.False(t, ValidEnvName(""))
+}
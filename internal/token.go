@@ -1,59 +1,250 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"strings"
+	"time"
+)
+
+// Grant types sent to the token endpoint
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
 
-	"github.com/joho/godotenv"
+// tokenExpiryMargin is how long before its expiry a token is treated as
+// expired, so it is not sent just as it runs out.
+const tokenExpiryMargin = time.Minute
+
+var (
+	// ErrAuthorizationPending is returned while the user has not yet approved
+	// a device code
+	ErrAuthorizationPending = errors.New("authorization pending")
+	// ErrSlowDown is returned when a device code is polled too often
+	ErrSlowDown = errors.New("polling too frequently")
+	// ErrDeviceCodeExpired is returned when a device code expired before it was approved
+	ErrDeviceCodeExpired = errors.New("device code expired")
+	// ErrAccessDenied is returned when the user declined the login
+	ErrAccessDenied = errors.New("access denied")
 )
 
-func GetAccessToken() (string, error) {
+// Token is an access token issued by the identity provider.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid reports whether the token is set and not about to expire. Tokens
+// without an expiry are valid until rejected.
+func (t *Token) Valid(now time.Time) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || now.Add(tokenExpiryMargin).Before(t.Expiry)
+}
+
+// tokenResponse is the body of a token endpoint response, successful or not.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// DeviceCode is the response to a device authorization request. The user
+// visits VerificationURI and enters UserCode while the client polls for a
+// token.
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// ClientCredentialsToken requests a token for the configured client using
+// its client secret.
+func ClientCredentialsToken(ctx context.Context, client *http.Client, auth AuthConfig) (*Token, error) {
+	grantType := auth.GrantType
+	if grantType == "" {
+		grantType = GrantClientCredentials
+	}
+	return requestToken(ctx, client, auth.Domain, url.Values{
+		"grant_type":    {grantType},
+		"client_id":     {auth.ClientID},
+		"client_secret": {auth.ClientSecret},
+		"audience":      {auth.Audience},
+	})
+}
+
+// RefreshAccessToken exchanges a refresh token for a new token. The refresh
+// token is kept if the provider does not rotate it.
+func RefreshAccessToken(ctx context.Context, client *http.Client, auth AuthConfig, refreshToken string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {GrantRefreshToken},
+		"client_id":     {auth.ClientID},
+		"refresh_token": {refreshToken},
+	}
+	if auth.ClientSecret != "" {
+		form.Set("client_secret", auth.ClientSecret)
+	}
+	tok, err := requestToken(ctx, client, auth.Domain, form)
+	if err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	return tok, nil
+}
+
+// RequestDeviceCode starts the OAuth device authorization flow for a user
+// logging in on a device without a browser. scope should include
+// offline_access for a refresh token to be issued.
+func RequestDeviceCode(ctx context.Context, client *http.Client, auth AuthConfig, scope string) (*DeviceCode, error) {
+	if auth.Domain == "" {
+		return nil, fmt.Errorf("auth domain is not configured")
+	}
+	form := url.Values{"client_id": {auth.ClientID}, "audience": {auth.Audience}}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	resp, err := postForm(ctx, client, fmt.Sprintf("https://%s/oauth/device/code", auth.Domain), form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		return "", fmt.Errorf("error loading .env file: %w", err)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var tr tokenResponse
+		_ = json.Unmarshal(body, &tr)
+		return nil, oauthError(resp.StatusCode, tr)
 	}
-	urlEnv := os.Getenv("URL")
-	if urlEnv == "" {
-		return "", fmt.Errorf("environment variable URL is not set")
+	var dc DeviceCode
+	if err := json.Unmarshal(body, &dc); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response body: %w", err)
 	}
-	url := fmt.Sprintf("https://%s/oauth/token", urlEnv)
+	if dc.DeviceCode == "" || dc.UserCode == "" {
+		return nil, fmt.Errorf("device code not found in response body")
+	}
+	if dc.Interval <= 0 {
+		dc.Interval = 5
+	}
+	return &dc, nil
+}
 
-	payload := strings.NewReader(fmt.Sprintf("{\"client_id\":\"%s\",\"client_secret\":\"%s\",\"audience\":\"%s\",\"grant_type\":\"%s\"}",
-		os.Getenv("CLIENT_ID"),
-		os.Getenv("CLIENT_SECRET"),
-		os.Getenv("AUDIENCE"),
-		os.Getenv("GRANT_TYPE"),
-	))
+// PollDeviceToken polls for the token of an approved device code until the
+// user approves or declines it, the code expires or ctx is done.
+func PollDeviceToken(ctx context.Context, client *http.Client, auth AuthConfig, dc *DeviceCode) (*Token, error) {
+	interval := time.Duration(dc.Interval) * time.Second
+	var expired <-chan time.Time
+	if dc.ExpiresIn > 0 {
+		timer := time.NewTimer(time.Duration(dc.ExpiresIn) * time.Second)
+		defer timer.Stop()
+		expired = timer.C
+	}
 
-	req, _ := http.NewRequest("POST", url, payload)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-expired:
+			return nil, ErrDeviceCodeExpired
+		case <-time.After(interval):
+		}
 
-	req.Header.Add("content-type", "application/json")
+		tok, err := requestToken(ctx, client, auth.Domain, url.Values{
+			"grant_type":  {GrantDeviceCode},
+			"client_id":   {auth.ClientID},
+			"device_code": {dc.DeviceCode},
+		})
+		switch {
+		case errors.Is(err, ErrAuthorizationPending):
+		case errors.Is(err, ErrSlowDown):
+			interval += 5 * time.Second
+		default:
+			return tok, err
+		}
+	}
+}
 
-	res, err := http.DefaultClient.Do(req)
+// requestToken posts form to the token endpoint of domain.
+func requestToken(ctx context.Context, client *http.Client, domain string, form url.Values) (*Token, error) {
+	if domain == "" {
+		return nil, fmt.Errorf("auth domain is not configured")
+	}
+	resp, err := postForm(ctx, client, fmt.Sprintf("https://%s/oauth/token", domain), form)
 	if err != nil {
-		return "", fmt.Errorf("error making request: %w", err)
+		return nil, err
 	}
-	defer res.Body.Close()
+	defer resp.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
 	if err != nil {
-		return "", fmt.Errorf("error reading response body: %w", err)
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, oauthError(resp.StatusCode, tr)
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("access_token not found in response body")
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("error unmarshalling response body: %w", err)
+	tok := &Token{AccessToken: tr.AccessToken, RefreshToken: tr.RefreshToken}
+	if tr.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
 	}
+	return tok, nil
+}
 
-	accessToken, ok := result["access_token"].(string)
-	if !ok {
-		return "", fmt.Errorf("access_token not found in response body")
+func postForm(ctx context.Context, client *http.Client, endpoint string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	return resp, nil
+}
 
-	return accessToken, nil
+// oauthError maps an OAuth error response to an error, using the sentinel
+// errors for the device flow states.
+func oauthError(status int, tr tokenResponse) error {
+	switch tr.Error {
+	case "authorization_pending":
+		return ErrAuthorizationPending
+	case "slow_down":
+		return ErrSlowDown
+	case "expired_token":
+		return ErrDeviceCodeExpired
+	case "access_denied":
+		return ErrAccessDenied
+	case "":
+		return fmt.Errorf("token request failed with status %d", status)
+	}
+	if tr.ErrorDescription != "" {
+		return fmt.Errorf("token request failed: %s: %s", tr.Error, tr.ErrorDescription)
+	}
+	return fmt.Errorf("token request failed: %s", tr.Error)
 }
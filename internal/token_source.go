@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// tokenIdleCheck is how often Run looks at a token without an expiry.
+const tokenIdleCheck = time.Hour
+
+// TokenSource supplies client credentials tokens to a long running service.
+// The token is cached and, while Run is running, refreshed when three
+// quarters of its lifetime has passed. A token rejected by the API is
+// discarded with InvalidateToken and replaced on the next call. Failed
+// requests to the identity provider are retried with backoff, so a service
+// can start while the provider is unreachable and report not ready until it
+// has a token.
+type TokenSource struct {
+	auth   AuthConfig
+	client *http.Client
+
+	// fetchMu serializes requests to the identity provider.
+	fetchMu sync.Mutex
+
+	mu        sync.Mutex
+	token     *Token
+	refreshAt time.Time
+	err       error
+	failures  int
+	retryAt   time.Time
+}
+
+// NewTokenSource returns a token source for the client credentials in auth,
+// requesting tokens with client.
+func NewTokenSource(auth AuthConfig, client *http.Client) *TokenSource {
+	return &TokenSource{auth: auth, client: client}
+}
+
+// Token returns the cached token, requesting a new one if it is missing or
+// about to expire. While waiting to retry after a failed request it returns
+// the error of that request.
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	if token, err := s.current(time.Now()); token != "" || err != nil {
+		return token, err
+	}
+	if err := s.refresh(ctx); err != nil {
+		return "", err
+	}
+	token, err := s.current(time.Now())
+	if token == "" && err == nil {
+		err = errors.New("no access token available")
+	}
+	return token, err
+}
+
+// InvalidateToken discards token if it is still cached, so the next call to
+// Token requests a new one.
+func (s *TokenSource) InvalidateToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && s.token.AccessToken == token {
+		s.token = nil
+	}
+}
+
+// Check reports whether a valid token is cached, for readiness checks.
+func (s *TokenSource) Check(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.token.Valid(time.Now()):
+		return nil
+	case s.err != nil:
+		return s.err
+	default:
+		return errors.New("no access token available")
+	}
+}
+
+// Run requests a token and keeps it fresh until ctx is done, retrying with
+// backoff while the identity provider fails.
+func (s *TokenSource) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(s.untilDue(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.refresh(ctx); err != nil && ctx.Err() == nil {
+			s.mu.Lock()
+			retryAt := s.retryAt
+			s.mu.Unlock()
+			log.Warn("Failed to get access token", "err", err, "retry_at", retryAt)
+		}
+	}
+}
+
+// current returns the cached token if it is valid, or the last error while
+// waiting to retry after a failed request. Both are empty when a token
+// should be requested.
+func (s *TokenSource) current(now time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Valid(now) {
+		return s.token.AccessToken, nil
+	}
+	if s.err != nil && now.Before(s.retryAt) {
+		return "", s.err
+	}
+	return "", nil
+}
+
+// untilDue returns how long until a token should be requested.
+func (s *TokenSource) untilDue(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case now.Before(s.retryAt):
+		return s.retryAt.Sub(now)
+	case !s.token.Valid(now):
+		return 0
+	case s.refreshAt.IsZero():
+		return tokenIdleCheck
+	default:
+		return max(s.refreshAt.Sub(now), 0)
+	}
+}
+
+// refresh requests a new token if one is due: there is no valid token or the
+// cached one is past its refresh time, and no failed request is waiting to
+// be retried. A failure caused by ctx ending is not counted.
+func (s *TokenSource) refresh(ctx context.Context) error {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+	if s.untilDue(time.Now()) > 0 {
+		return nil
+	}
+
+	tok, err := ClientCredentialsToken(ctx, s.client, s.auth)
+	now := time.Now()
+	if err == nil && !tok.Valid(now) {
+		// Retrying at once would loop until the provider issues longer tokens.
+		err = fmt.Errorf("token expires at %s, too soon to use", tok.Expiry.Format(time.RFC3339))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if ctx.Err() == nil {
+			s.failures++
+			s.err = err
+			s.retryAt = now.Add(tokenBackoff(s.failures))
+		}
+		return err
+	}
+	s.token, s.err, s.failures, s.retryAt = tok, nil, 0, time.Time{}
+	s.refreshAt = time.Time{}
+	if !tok.Expiry.IsZero() {
+		s.refreshAt = now.Add(tok.Expiry.Sub(now) * 3 / 4)
+	}
+	return nil
+}
+
+// tokenBackoff returns the delay before a token request is retried after the
+// given number of consecutive failures: 1s doubling up to one minute.
+func tokenBackoff(failures int) time.Duration {
+	d := time.Second
+	for i := 1; i < failures && d < time.Minute; i++ {
+		d *= 2
+	}
+	return min(d, time.Minute)
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newCountingTokenServer starts a fake identity provider issuing tokens
+// tok1, tok2, ... valid for an hour, failing while fail is set.
+func newCountingTokenServer(t *testing.T) (*TokenSource, *atomic.Int32, *atomic.Bool) {
+	var requests atomic.Int32
+	var fail atomic.Bool
+	auth, client := newTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if fail.Load() {
+			writeTokenJSON(w, http.StatusServiceUnavailable, map[string]any{})
+			return
+		}
+		writeTokenJSON(w, http.StatusOK, map[string]any{"access_token": fmt.Sprintf("tok%d", n), "expires_in": 3600})
+	})
+	return NewTokenSource(auth, client), &requests, &fail
+}
+
+func TestTokenSourceCachesAndInvalidates(t *testing.T) {
+	ts, requests, _ := newCountingTokenServer(t)
+	ctx := context.Background()
+
+	assert.Error(t, ts.Check(ctx))
+	tok, err := ts.Token(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "tok1", tok)
+	tok, _ = ts.Token(ctx)
+	assert.Equal(t, "tok1", tok)
+	assert.Equal(t, int32(1), requests.Load())
+	assert.NoError(t, ts.Check(ctx))
+
+	// Only the rejected token is discarded.
+	ts.InvalidateToken("stale")
+	tok, _ = ts.Token(ctx)
+	assert.Equal(t, "tok1", tok)
+	ts.InvalidateToken("tok1")
+	tok, _ = ts.Token(ctx)
+	assert.Equal(t, "tok2", tok)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestTokenSourceBacksOff(t *testing.T) {
+	ts, requests, fail := newCountingTokenServer(t)
+	ctx := context.Background()
+	fail.Store(true)
+
+	_, err := ts.Token(ctx)
+	assert.ErrorContains(t, err, "status 503")
+	_, err = ts.Token(ctx)
+	assert.ErrorContains(t, err, "status 503")
+	assert.Equal(t, int32(1), requests.Load(), "no request while backing off")
+	assert.ErrorContains(t, ts.Check(ctx), "status 503")
+
+	// Once the backoff has passed the provider is asked again.
+	fail.Store(false)
+	ts.mu.Lock()
+	ts.retryAt = time.Now().Add(-time.Second)
+	ts.mu.Unlock()
+	tok, err := ts.Token(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "tok2", tok)
+	assert.NoError(t, ts.Check(ctx))
+}
+
+func TestTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	ts, requests, _ := newCountingTokenServer(t)
+	ctx := context.Background()
+
+	_, err := ts.Token(ctx)
+	assert.NoError(t, err)
+	assert.InDelta(t, 45*time.Minute, ts.untilDue(time.Now()), float64(time.Minute))
+	assert.NoError(t, ts.refresh(ctx))
+	assert.Equal(t, int32(1), requests.Load(), "not due yet")
+
+	// Past its refresh time the token is replaced while still valid.
+	ts.mu.Lock()
+	ts.refreshAt = time.Now().Add(-time.Second)
+	ts.mu.Unlock()
+	assert.Zero(t, ts.untilDue(time.Now()))
+	assert.NoError(t, ts.refresh(ctx))
+	tok, _ := ts.Token(ctx)
+	assert.Equal(t, "tok2", tok)
+}
+
+func TestTokenSourceRunRetries(t *testing.T) {
+	ts, requests, fail := newCountingTokenServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	fail.Store(true)
+
+	done := make(chan struct{})
+	go func() {
+		ts.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return requests.Load() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Error(t, ts.Check(ctx), "not ready while the provider fails")
+
+	fail.Store(false)
+	assert.Eventually(t, func() bool { return ts.Check(ctx) == nil }, 3*time.Second, 50*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestTokenBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, tokenBackoff(1))
+	assert.Equal(t, 2*time.Second, tokenBackoff(2))
+	assert.Equal(t, 32*time.Second, tokenBackoff(6))
+	assert.Equal(t, time.Minute, tokenBackoff(20))
+}
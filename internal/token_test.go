@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTokenServer starts a fake identity provider and returns the auth
+// configuration pointing at it and a client that trusts it.
+func newTokenServer(t *testing.T, handler http.HandlerFunc) (AuthConfig, *http.Client) {
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	auth := AuthConfig{
+		Domain:       strings.TrimPrefix(server.URL, "https://"),
+		Audience:     "https://api.example.com",
+		ClientID:     "cli",
+		ClientSecret: "shh",
+	}
+	return auth, server.Client()
+}
+
+func writeTokenJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestTokenValid(t *testing.T) {
+	now := time.Now()
+	assert.False(t, (*Token)(nil).Valid(now))
+	assert.False(t, (&Token{}).Valid(now))
+	assert.True(t, (&Token{AccessToken: "a"}).Valid(now))
+	assert.True(t, (&Token{AccessToken: "a", Expiry: now.Add(time.Hour)}).Valid(now))
+	assert.False(t, (&Token{AccessToken: "a", Expiry: now.Add(30 * time.Second)}).Valid(now))
+}
+
+func TestClientCredentialsToken(t *testing.T) {
+	auth, client := newTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/oauth/token", r.URL.Path)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, GrantClientCredentials, r.PostForm.Get("grant_type"))
+		assert.Equal(t, "shh", r.PostForm.Get("client_secret"))
+		assert.Equal(t, "https://api.example.com", r.PostForm.Get("audience"))
+		writeTokenJSON(w, http.StatusOK, map[string]any{"access_token": "tok", "expires_in": 3600})
+	})
+
+	tok, err := ClientCredentialsToken(context.Background(), client, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, "tok", tok.AccessToken)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), tok.Expiry, time.Minute)
+}
+
+func TestTokenRequestErrors(t *testing.T) {
+	auth, client := newTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTokenJSON(w, http.StatusUnauthorized, map[string]any{"error": "access_denied", "error_description": "Unauthorized"})
+	})
+	_, err := ClientCredentialsToken(context.Background(), client, auth)
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	auth, client = newTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTokenJSON(w, http.StatusForbidden, map[string]any{"error": "invalid_grant", "error_description": "Unknown or invalid refresh token."})
+	})
+	_, err = RefreshAccessToken(context.Background(), client, auth, "old")
+	assert.ErrorContains(t, err, "invalid_grant: Unknown or invalid refresh token.")
+
+	_, err = ClientCredentialsToken(context.Background(), client, AuthConfig{})
+	assert.ErrorContains(t, err, "auth domain is not configured")
+}
+
+func TestRefreshAccessTokenKeepsRefreshToken(t *testing.T) {
+	auth, client := newTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, GrantRefreshToken, r.PostForm.Get("grant_type"))
+		assert.Equal(t, "old", r.PostForm.Get("refresh_token"))
+		writeTokenJSON(w, http.StatusOK, map[string]any{"access_token": "new", "expires_in": 60})
+	})
+
+	tok, err := RefreshAccessToken(context.Background(), client, auth, "old")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", tok.AccessToken)
+	assert.Equal(t, "old", tok.RefreshToken)
+}
+
+func TestDeviceCodeFlow(t *testing.T) {
+	polls := 0
+	auth, client := newTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		switch r.URL.Path {
+		case "/oauth/device/code":
+			assert.Equal(t, "offline_access", r.PostForm.Get("scope"))
+			writeTokenJSON(w, http.StatusOK, DeviceCode{
+				DeviceCode:      "dev",
+				UserCode:        "ABCD-EFGH",
+				VerificationURI: "https://login.example.com/activate",
+				ExpiresIn:       60,
+			})
+		case "/oauth/token":
+			assert.Equal(t, GrantDeviceCode, r.PostForm.Get("grant_type"))
+			assert.Equal(t, "dev", r.PostForm.Get("device_code"))
+			polls++
+			if polls < 3 {
+				writeTokenJSON(w, http.StatusForbidden, map[string]any{"error": "authorization_pending"})
+				return
+			}
+			writeTokenJSON(w, http.StatusOK, map[string]any{"access_token": "user", "refresh_token": "r", "expires_in": 60})
+		}
+	})
+
+	dc, err := RequestDeviceCode(context.Background(), client, auth, "offline_access")
+	assert.NoError(t, err)
+	assert.Equal(t, "ABCD-EFGH", dc.UserCode)
+	assert.Equal(t, 5, dc.Interval)
+
+	dc.Interval = 0
+	tok, err := PollDeviceToken(context.Background(), client, auth, dc)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, polls)
+	assert.Equal(t, "user", tok.AccessToken)
+	assert.Equal(t, "r", tok.RefreshToken)
+}
+
+func TestPollDeviceTokenStops(t *testing.T) {
+	auth, client := newTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTokenJSON(w, http.StatusForbidden, map[string]any{"error": "expired_token"})
+	})
+	_, err := PollDeviceToken(context.Background(), client, auth, &DeviceCode{DeviceCode: "dev"})
+	assert.ErrorIs(t, err, ErrDeviceCodeExpired)
+
+	auth, client = newTokenServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTokenJSON(w, http.StatusForbidden, map[string]any{"error": "authorization_pending"})
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = PollDeviceToken(ctx, client, auth, &DeviceCode{DeviceCode: "dev"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -1,97 +1,134 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/log"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/joho/godotenv"
 	"github.com/squarehole/disapyr/internal"
 )
 
-func getCertKeyPaths() (string, string, error) {
-	httpsEnabled := os.Getenv("HTTPS_ENABLED") != "false"
-	certPath := os.Getenv("CERT_PATH")
-	keyPath := os.Getenv("KEY_PATH")
-
-	if httpsEnabled {
-		log.Info("HTTPS enabled")
-		if certPath == "" || keyPath == "" {
-			return "", "", fmt.Errorf("CERT_PATH and KEY_PATH must be set when HTTPS_ENABLED is true")
-		}
-
-		if _, err := os.Stat(certPath); os.IsNotExist(err) {
-			return "", "", fmt.Errorf("CERT_PATH file does not exist: %s", certPath)
-		}
-
-		if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-			return "", "", fmt.Errorf("KEY_PATH file does not exist: %s", keyPath)
-		}
-	}
-
-	return certPath, keyPath, nil
-}
-
 func main() {
 
-	logger := log.NewWithOptions(os.Stderr, log.Options{
-		ReportCaller:    true,
-		ReportTimestamp: true,
-		TimeFormat:      time.RFC3339Nano,
-		Level:           log.DebugLevel,
-		Formatter:       log.JSONFormatter,
-	})
+	logger := internal.NewLogger()
 
 	log.SetDefault(logger)
 
 	log.Debug("Starting API server...", "time", time.Now())
 
-	// Load environment variables from .env file.
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("Error loading .env file")
+	// Load the configuration from file, environment and flags.
+	cfg, err := internal.LoadConfig(internal.APIComponent, os.Args[1:])
+	if errors.Is(err, internal.ErrConfigPrinted) || errors.Is(err, flag.ErrHelp) {
+		return
 	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	internal.ApplyLogConfig(logger, cfg.Log)
 
-	// Retrieve rate limit from environment variable.
-	rateLimitStr := os.Getenv("RATE_LIMIT")
-	rateLimit := 10 // Default to 10 requests per second.
-	if rateLimitStr != "" {
-		var err error
-		rateLimit, err = strconv.Atoi(rateLimitStr)
-		if err != nil {
-			log.Fatal("Invalid RATE_LIMIT value: %v", err)
-		}
+	// Export audit events to the configured syslog, file and webhook sinks.
+	auditSinks, err := internal.NewAuditSinks(cfg.Audit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up trace export before any spans are started.
+	shutdownTracing, err := internal.InitTracing(context.Background(), cfg.Tracing, "disapyr-api")
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Create a new Fiber app.
-	app := fiber.New()
+	// Create a new Fiber app. Request bodies are streamed so that file uploads
+	// are encrypted as they arrive; a streamed body is not held to BodyLimit,
+	// so POST /secret enforces the body size limit as it reads.
+	app := fiber.New(fiber.Config{
+		StreamRequestBody: true,
+		BodyLimit:         int(cfg.Limits.MaxBodySize),
+	})
+
+	// Expose Prometheus metrics. This comes first so every route is counted.
+	internal.RegisterMetrics(app, cfg.Metrics)
+	app.Use(internal.RequestIDMiddleware())
+	app.Use(internal.TracingMiddleware())
+	app.Use(internal.AccessLogMiddleware())
 
 	// Initialize the database connection.
-	db, err := internal.NewDatabaseConnection()
+	db, err := internal.NewDatabaseConnection(cfg.Database)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Include the database pool statistics in the metrics.
+	internal.RegisterDBMetrics(db)
+
 	// Create the rate limiter.
-	limiter := internal.CreateRateLimiter(rateLimit)
+	limiter := internal.CreateRateLimiter(cfg.Server.RateLimit)
 
-	// Register the routes.
-	internal.RegisterRoutes(app, db, limiter, os.Getenv("ENC_KEY"), 32) // Assuming keyLen is 32
+	// Follow secret status changes from every replica for the watch streams.
+	events := internal.NewSecretEvents(cfg.Database)
 
-	// Start the Fiber app.
-	port := ":8080"
-	certPath, keyPath, err := getCertKeyPaths()
+	// Keep large file payloads in the configured blob store, if any.
+	blobs, err := internal.NewBlobs(db, cfg.Blob)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Register the routes.
+	internal.RegisterRoutes(app, db, limiter, cfg, internal.NewAuditLog(db, auditSinks...), events, blobs)
+
+	// Start the Fiber app.
+	port := fmt.Sprintf(":%d", cfg.Server.Port)
+	listen := func() error { return app.Listen(port) }
+	if cfg.Server.HTTPSEnabled {
+		log.Info("HTTPS enabled")
+		listen = func() error { return app.ListenTLS(port, cfg.Server.CertPath, cfg.Server.KeyPath) }
+	}
+
+	// Stop accepting connections on SIGINT/SIGTERM and drain in-flight requests.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Dispatch secret events until shutdown, which also ends open streams.
+	go events.Run(ctx)
+
+	// Delete consumed and orphaned blobs until shutdown.
+	if blobs != nil {
+		go blobs.RunSweeper(ctx)
+	}
+
+	// Deliver queued creator notifications until shutdown.
+	if cfg.Notify.Enabled {
+		go internal.NewNotifier(db, cfg.Notify, []byte(cfg.Security.EncKey)).Run(ctx)
+	}
+
+	// Send queued secret links and open notifications by email.
+	if cfg.Mail.Enabled {
+		mailer, err := internal.NewMailer(db, cfg.Mail, []byte(cfg.Security.EncKey))
+		if err != nil {
+			log.Fatal(err)
+		}
+		go mailer.Run(ctx)
+	}
+
 	log.Infof("Starting API server on port %s...", port)
-	if os.Getenv("HTTPS_ENABLED") != "false" {
-		log.Fatal(app.ListenTLS(port, certPath, keyPath))
-	} else {
-		log.Fatal(app.Listen(port))
+	serveErr := internal.Serve(ctx, app, listen, cfg.Server.ShutdownTimeout)
+
+	internal.CloseAuditSinks(auditSinks)
+	if err := db.Close(); err != nil {
+		log.Error("Failed to close database connection", "error", err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Error("Failed to flush traces", "error", err)
+	}
+	if serveErr != nil {
+		log.Fatal(serveErr)
 	}
 }
@@ -14,9 +14,11 @@ import (
 )
 
 func init() {
-	// Load environment variables from .env file.
-	if err := godotenv.Load(); err != nil {
-		panic("Error loading .env file")
+	// Load environment variables from .env file if one exists.
+	if _, err := os.Stat(".env"); err == nil {
+		if err := godotenv.Load(); err != nil {
+			panic("Error loading .env file")
+		}
 	}
 }
 